@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/anton2920/gofa/errors"
+	"github.com/anton2920/gofa/net/http"
+	"github.com/anton2920/gofa/trace"
+)
+
+/* Blocklist contains hosts links must not lead to. Blocking a domain also blocks all of its subdomains. */
+type Blocklist struct {
+	Hosts map[string]struct{}
+}
+
+var CurrentBlocklist atomic.Pointer[Blocklist]
+
+/* LoadBlocklistFromFile reads file with one host per line. Empty lines and lines starting with '#' are ignored. */
+func LoadBlocklistFromFile(filename string) (*Blocklist, error) {
+	defer trace.End(trace.Begin(""))
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	bl := &Blocklist{Hosts: make(map[string]struct{})}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		host := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if (len(host) == 0) || (host[0] == '#') {
+			continue
+		}
+		if strings.ContainsAny(host, "/: \t") {
+			return nil, errors.New("invalid host " + host)
+		}
+		bl.Hosts[strings.TrimSuffix(host, ".")] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return bl, nil
+}
+
+func (bl *Blocklist) HostBlocked(host string) bool {
+	defer trace.End(trace.Begin(""))
+
+	if (bl == nil) || (len(bl.Hosts) == 0) {
+		return false
+	}
+
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for {
+		if _, ok := bl.Hosts[host]; ok {
+			return true
+		}
+		dot := strings.IndexByte(host, '.')
+		if dot == -1 {
+			return false
+		}
+		host = host[dot+1:]
+	}
+}
+
+/* URLTargetBlocked reports whether 'rawURL' leads to one of blocked hosts. */
+func URLTargetBlocked(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	return CurrentBlocklist.Load().HostBlocked(u.Hostname())
+}
+
+func URLBlockedPage(w *http.Response, r *http.Request) error {
+	w.StatusCode = http.StatusForbidden
+	return URLUnavailablePage(w, r, "Link is blocked", "Destination of this link has been blocked by administrator")
+}
@@ -0,0 +1,725 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	stdtime "time"
+	"unicode/utf8"
+
+	"github.com/anton2920/gofa/database"
+	"github.com/anton2920/gofa/errors"
+	"github.com/anton2920/gofa/net/http"
+	"github.com/anton2920/gofa/time"
+)
+
+/* Command works on data files directly, without going through HTTP. */
+type Command struct {
+	Name  string
+	Usage string
+
+	/* Commands that change data hold exclusive lock and store data files when they succeed, others hold shared lock. None of them runs along with server. */
+	Modifies bool
+
+	Run func(args []string) error
+}
+
+var Commands = [...]Command{
+	{"serve", "[-config FILE] [-print-config] [-OPTION VALUE]...", false, nil},
+	{"user create", "-email EMAIL -password PASSWORD -first NAME -last NAME", true, UserCreateCommand},
+	{"user list", "", false, UserListCommand},
+	{"user disable", "ID|EMAIL", true, UserDisableCommand},
+	{"user enable", "ID|EMAIL", true, UserEnableCommand},
+	{"link create", "[-user ID] [-alias ALIAS] [-expires YYYY-MM-DD] URL", true, LinkCreateCommand},
+	{"link show", "CODE", false, LinkShowCommand},
+	{"link delete", "[-purge] CODE", true, LinkDeleteCommand},
+	{"import", "-user ID [-format csv|json] [-commit] FILE", true, ImportCommand},
+	{"export", "[-user ID] [-format csv|json] [-o FILE]", false, ExportCommand},
+	{"stats", "[CODE]", false, StatsCommand},
+	{"gc", "[-deleted]", true, GCCommand},
+	{"check", "", false, CheckCommand},
+}
+
+var UsageError = errors.New("wrong usage")
+
+func PrintUsage(w io.Writer) {
+	name := filepath.Base(os.Args[0])
+
+	fmt.Fprintf(w, "usage:\n")
+	for _, command := range Commands {
+		fmt.Fprintf(w, "\t%s\n", strings.TrimSpace(name+" "+command.Name+" "+command.Usage))
+	}
+}
+
+/* CommandErrorMessage returns message of errors coming both from handlers of HTTP requests and from everything else. */
+func CommandErrorMessage(err error) string {
+	if httpError, ok := err.(http.Error); ok {
+		return httpError.DisplayMessage
+	}
+	return err.Error()
+}
+
+/* RunCommand runs subcommand 'args[0]' and returns exit code of the process. */
+func RunCommand(args []string) int {
+	if len(args) == 0 {
+		PrintUsage(os.Stderr)
+		return 2
+	}
+
+	name, rest := args[0], args[1:]
+	if ((name == "user") || (name == "link")) && (len(rest) > 0) {
+		name, rest = name+" "+rest[0], rest[1:]
+	}
+
+	var command *Command
+	for i := 0; i < len(Commands); i++ {
+		if (Commands[i].Name == name) && (Commands[i].Run != nil) {
+			command = &Commands[i]
+			break
+		}
+	}
+	if command == nil {
+		PrintUsage(os.Stderr)
+		return 2
+	}
+
+	/* NOTE(anton2920): commands take configuration only from file and environment, their flags are their own. */
+	cfg, _, err := LoadConfig(nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: failed to load configuration: %v\n", name, err)
+		return 1
+	}
+	CurrentConfig.Store(cfg)
+	if cfg.BlocklistFile != "" {
+		bl, err := LoadBlocklistFromFile(cfg.BlocklistFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: failed to load blocklist: %v\n", name, err)
+			return 1
+		}
+		CurrentBlocklist.Store(bl)
+	}
+
+	lock, err := LockDataFiles(command.Modifies)
+	if err != nil {
+		if err == DataFilesBusy {
+			/* NOTE(anton2920): running server keeps data in memory and stores it only when it stops, so its files could be arbitrarily old. */
+			fmt.Fprintf(os.Stderr, "%s: data files are in use by running server or another command, stop it first\n", name)
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: failed to lock data files: %v\n", name, err)
+		}
+		return 1
+	}
+	defer UnlockDataFiles(lock)
+
+	if err := RestoreDataFromFiles(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: failed to restore data from files: %v\n", name, err)
+		return 1
+	}
+
+	if err := command.Run(rest); err != nil {
+		if err == UsageError {
+			fmt.Fprintf(os.Stderr, "usage: %s\n", strings.TrimSpace(filepath.Base(os.Args[0])+" "+command.Name+" "+command.Usage))
+			return 2
+		}
+		fmt.Fprintf(os.Stderr, "%s: %s\n", name, CommandErrorMessage(err))
+		return 1
+	}
+
+	if command.Modifies {
+		if err := StoreDataToFiles(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: failed to store data to files: %v\n", name, err)
+			return 1
+		}
+	}
+
+	return 0
+}
+
+/* ParseCommandFlags parses flags of the command and returns its positional arguments. */
+func ParseCommandFlags(fs *flag.FlagSet, args []string, nargs int) ([]string, error) {
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil {
+		return nil, UsageError
+	}
+	if fs.NArg() != nargs {
+		return nil, UsageError
+	}
+	return fs.Args(), nil
+}
+
+/* FindUser finds user by ID or by email. */
+func FindUser(s string, user *User) error {
+	if id, err := strconv.Atoi(s); err == nil {
+		return GetUserByID(database.ID(id), user)
+	}
+	return GetUserByEmail(s, user)
+}
+
+func FormatTime(t int64) string {
+	if t == 0 {
+		return "-"
+	}
+	return stdtime.Unix(t, 0).UTC().Format("2006-01-02 15:04:05")
+}
+
+func UserCreateCommand(args []string) error {
+	var user User
+
+	fs := flag.NewFlagSet("user create", flag.ContinueOnError)
+	email := fs.String("email", "", "")
+	password := fs.String("password", "", "")
+	fs.StringVar(&user.FirstName, "first", "", "")
+	fs.StringVar(&user.LastName, "last", "", "")
+	if _, err := ParseCommandFlags(fs, args, 0); err != nil {
+		return err
+	}
+
+	if err := UserNameValid(GL, user.FirstName); err != nil {
+		return err
+	}
+	if err := UserNameValid(GL, user.LastName); err != nil {
+		return err
+	}
+	address, err := mail.ParseAddress(*email)
+	if err != nil {
+		return http.BadRequest(Ls(GL, "provided email is not valid"))
+	}
+	user.Email = address.Address
+	if n := utf8.RuneCountInString(*password); (n < GetConfig().MinPasswordLen) || (n > GetConfig().MaxPasswordLen) {
+		return http.BadRequest(Ls(GL, "password length must be between %d and %d characters long"), GetConfig().MinPasswordLen, GetConfig().MaxPasswordLen)
+	}
+	user.Password = *password
+
+	var u User
+	if err := GetUserByEmail(user.Email, &u); err == nil {
+		return http.Conflict(Ls(GL, "user with this email already exists"))
+	}
+
+	user.CreatedOn = int64(time.Unix())
+	if err := CreateUser(&user); err != nil {
+		return err
+	}
+
+	fmt.Println(user.ID)
+	return nil
+}
+
+func UserListCommand(args []string) error {
+	if len(args) != 0 {
+		return UsageError
+	}
+
+	UsersLock.RLock()
+	users := make([]User, 0, len(Users))
+	for _, user := range Users {
+		users = append(users, user)
+	}
+	UsersLock.RUnlock()
+	slices.SortFunc(users, func(a, b User) int {
+		return int(a.ID - b.ID)
+	})
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "ID\tEMAIL\tNAME\tCREATED\tLINKS\tSTATUS\n")
+	for _, user := range users {
+		status := "active"
+		if user.Flags&FlagUserDisabled == FlagUserDisabled {
+			status = "disabled"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s %s\t%s\t%d\t%s\n", user.ID, user.Email, user.LastName, user.FirstName, FormatTime(user.CreatedOn), len(GetURLPathsByUserID(user.ID)), status)
+	}
+	return tw.Flush()
+}
+
+func SetUserDisabled(args []string, disabled bool) error {
+	if len(args) != 1 {
+		return UsageError
+	}
+
+	var user User
+	if err := FindUser(args[0], &user); err != nil {
+		if err == database.NotFound {
+			return http.NotFound(Ls(GL, "user with this email does not exist"))
+		}
+		return err
+	}
+
+	if disabled {
+		user.Flags |= FlagUserDisabled
+
+		SessionsLock.Lock()
+		for token, session := range Sessions {
+			if session.ID == user.ID {
+				delete(Sessions, token)
+			}
+		}
+		SessionsLock.Unlock()
+	} else {
+		user.Flags &^= FlagUserDisabled
+	}
+
+	return SaveUser(&user)
+}
+
+func UserDisableCommand(args []string) error {
+	return SetUserDisabled(args, true)
+}
+
+func UserEnableCommand(args []string) error {
+	return SetUserDisabled(args, false)
+}
+
+func LinkCreateCommand(args []string) error {
+	fs := flag.NewFlagSet("link create", flag.ContinueOnError)
+	userID := fs.Int("user", 0, "")
+	alias := fs.String("alias", "", "")
+	expires := fs.String("expires", "", "")
+	args, err := ParseCommandFlags(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	if *userID != 0 {
+		var user User
+		if err := GetUserByID(database.ID(*userID), &user); err != nil {
+			return http.NotFound(Ls(GL, "user with ID %d does not exist"), *userID)
+		}
+	}
+
+	row := URLBulkRow{RawURL: args[0], Alias: *alias}
+	if err := URLBulkRowValid(GL, &row); err != nil {
+		return err
+	}
+	expiresAt, err := ParseExpiry(*expires)
+	if err != nil {
+		return http.BadRequest(Ls(GL, "expiry must be in format YYYY-MM-DD, YYYY-MM-DDTHH:MM or RFC 3339"))
+	}
+
+	var url URL
+	url.UserID = database.ID(*userID)
+	url.RawURL = row.RawURL
+	url.ExpiresAt = expiresAt
+	url.RedirectCounts = make(map[int64]int64)
+	url.RedirectFrom = make(map[string]int64)
+
+	paths := []string{row.Alias}
+	if err := CreateURLs(paths, []URL{url}); err != nil {
+		return err
+	}
+
+	fmt.Println(paths[0])
+	return nil
+}
+
+func LinkShowCommand(args []string) error {
+	if len(args) != 1 {
+		return UsageError
+	}
+	path := args[0]
+
+	var url URL
+	if err := GetURLByPath(path, &url); err != nil {
+		return http.NotFound(Ls(GL, "shortened URL does not exist"))
+	}
+
+	status := "active"
+	if url.Flags&FlagDeleted == FlagDeleted {
+		status = "deleted"
+	} else if reason := URLUnavailable(&url, int64(time.Unix())); reason != ReasonNone {
+		status = "unavailable"
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "Code:\t%s\n", path)
+	fmt.Fprintf(tw, "Target:\t%s\n", url.RawURL)
+	fmt.Fprintf(tw, "Title:\t%s\n", URLDisplayTitle(&url))
+	fmt.Fprintf(tw, "Owner:\t%d\n", url.UserID)
+	fmt.Fprintf(tw, "Status:\t%s\n", status)
+	fmt.Fprintf(tw, "Created:\t%s\n", FormatTime(url.CreatedAt))
+	fmt.Fprintf(tw, "Expires:\t%s\n", FormatTime(url.ExpiresAt))
+	fmt.Fprintf(tw, "Tags:\t%s\n", strings.Join(url.Tags, ", "))
+	fmt.Fprintf(tw, "Folder:\t%s\n", url.Folder)
+	fmt.Fprintf(tw, "Redirects:\t%d\n", url.Redirects)
+	if url.MaxRedirects > 0 {
+		fmt.Fprintf(tw, "Max redirects:\t%d\n", url.MaxRedirects)
+	}
+	fmt.Fprintf(tw, "QR code scans:\t%d\n", url.Scans)
+	fmt.Fprintf(tw, "Password:\t%t\n", len(url.PasswordHash) > 0)
+	return tw.Flush()
+}
+
+func LinkDeleteCommand(args []string) error {
+	fs := flag.NewFlagSet("link delete", flag.ContinueOnError)
+	purge := fs.Bool("purge", false, "")
+	args, err := ParseCommandFlags(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	path := args[0]
+
+	if *purge {
+		err = DeleteURL(path)
+	} else {
+		err = UpdateURL(path, func(u *URL) error {
+			u.Flags |= FlagDeleted
+			return nil
+		})
+	}
+	if err == database.NotFound {
+		return http.NotFound(Ls(GL, "shortened URL does not exist"))
+	}
+	return err
+}
+
+/* ReadCommandFile reads file 'name', or standard input if it is '-'. */
+func ReadCommandFile(name string) (string, error) {
+	var data []byte
+	var err error
+
+	if name == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(name)
+	}
+	return string(data), err
+}
+
+func ImportCommand(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	userID := fs.Int("user", 0, "")
+	formatName := fs.String("format", "", "")
+	commit := fs.Bool("commit", false, "")
+	args, err := ParseCommandFlags(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	var user User
+	if err := GetUserByID(database.ID(*userID), &user); err != nil {
+		return http.NotFound(Ls(GL, "user with ID %d does not exist"), *userID)
+	}
+
+	if *formatName == "" {
+		*formatName = strings.TrimPrefix(filepath.Ext(args[0]), ".")
+	}
+	format, ok := ParseImportFormat(*formatName)
+	if !ok {
+		return http.BadRequest(Ls(GL, "format must be either 'csv' or 'json'"))
+	}
+
+	data, err := ReadCommandFile(args[0])
+	if err != nil {
+		return err
+	}
+	rows, err := ParseImport(GL, format, data)
+	if err != nil {
+		return err
+	}
+	PlanImport(rows)
+
+	if *commit {
+		if err := CommitImport(user.ID, rows); err != nil {
+			return err
+		}
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "LINE\tCODE\tRESULT\n")
+	for i := 0; i < len(rows); i++ {
+		row := &rows[i]
+
+		var result string
+		switch {
+		case row.Error != "":
+			result = "error: " + row.Error
+		case (row.Path != "") && (row.Path == row.Code):
+			result = "code is preserved"
+		case row.Path != "":
+			result = "new code " + row.Path
+		default:
+			result = "new code will be assigned"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", row.Line, row.Code, result)
+	}
+	if !*commit {
+		fmt.Fprintf(tw, "\nThis is a dry run, use -commit to import links.\n")
+	}
+	return tw.Flush()
+}
+
+func ExportCommand(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	userID := fs.Int("user", 0, "")
+	format := fs.String("format", "csv", "")
+	output := fs.String("o", "-", "")
+	if _, err := ParseCommandFlags(fs, args, 0); err != nil {
+		return err
+	}
+
+	URLsLock.RLock()
+	paths := make([]string, 0, len(URLs))
+	for path, url := range URLs {
+		if (*userID == 0) || (url.UserID == database.ID(*userID)) {
+			paths = append(paths, path)
+		}
+	}
+	slices.SortFunc(paths, func(a, b string) int {
+		return int(URLs[a].ID - URLs[b].ID)
+	})
+	URLsLock.RUnlock()
+
+	items := make([]URLExportItem, 0, len(paths))
+	var url URL
+	for _, path := range paths {
+		if GetURLByPath(path, &url) == nil {
+			items = append(items, URLToExportItem(path, &url))
+		}
+	}
+
+	w := io.Writer(os.Stdout)
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	switch *format {
+	default:
+		return http.BadRequest(Ls(GL, "format must be either 'csv' or 'json'"))
+	case "csv":
+		return EncodeURLExportCSV(w, items)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		return enc.Encode(items)
+	}
+}
+
+func StatsCommand(args []string) error {
+	switch len(args) {
+	default:
+		return UsageError
+	case 0:
+		return GlobalStatsCommand()
+	case 1:
+		return URLStatsCommand(args[0])
+	}
+}
+
+func GlobalStatsCommand() error {
+	var links, deleted, redirects, scans int64
+	type top struct {
+		Path      string
+		Redirects int64
+	}
+	var tops []top
+
+	URLsLock.RLock()
+	for path, url := range URLs {
+		links++
+		if url.Flags&FlagDeleted == FlagDeleted {
+			deleted++
+		}
+		redirects += url.Redirects
+		scans += url.Scans
+		tops = append(tops, top{path, url.Redirects})
+	}
+	URLsLock.RUnlock()
+
+	UsersLock.RLock()
+	users := len(Users)
+	UsersLock.RUnlock()
+
+	SessionsLock.RLock()
+	sessions := len(Sessions)
+	SessionsLock.RUnlock()
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "Users:\t%d\n", users)
+	fmt.Fprintf(tw, "Sessions:\t%d\n", sessions)
+	fmt.Fprintf(tw, "Links:\t%d\n", links)
+	fmt.Fprintf(tw, "Deleted links:\t%d\n", deleted)
+	fmt.Fprintf(tw, "Redirects:\t%d\n", redirects)
+	fmt.Fprintf(tw, "QR code scans:\t%d\n", scans)
+
+	const ntop = 10
+	slices.SortFunc(tops, func(a, b top) int {
+		if a.Redirects != b.Redirects {
+			return int(b.Redirects - a.Redirects)
+		}
+		return strings.Compare(a.Path, b.Path)
+	})
+	if len(tops) > 0 {
+		fmt.Fprintf(tw, "\nTOP LINKS\tREDIRECTS\n")
+		for i := 0; (i < len(tops)) && (i < ntop); i++ {
+			fmt.Fprintf(tw, "%s\t%d\n", tops[i].Path, tops[i].Redirects)
+		}
+	}
+	return tw.Flush()
+}
+
+func URLStatsCommand(path string) error {
+	var url URL
+	if err := GetURLByPath(path, &url); err != nil {
+		return http.NotFound(Ls(GL, "shortened URL does not exist"))
+	}
+	counts, scans := GetURLDailyCounts(path)
+	today := UnixDay(int64(time.Unix()))
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "DAY\tREDIRECTS\tSCANS\n")
+	for day := today; day > today-StatsDays; day-- {
+		if counts[day] == 0 {
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\n", stdtime.Unix(day*OneDay, 0).UTC().Format("2006-01-02"), counts[day], scans[day])
+	}
+	fmt.Fprintf(tw, "Total\t%d\t%d\n", url.Redirects, url.Scans)
+
+	if len(url.RedirectFrom) > 0 {
+		referers := make([]string, 0, len(url.RedirectFrom))
+		for referer := range url.RedirectFrom {
+			referers = append(referers, referer)
+		}
+		slices.SortFunc(referers, func(a, b string) int {
+			return int(url.RedirectFrom[b] - url.RedirectFrom[a])
+		})
+
+		fmt.Fprintf(tw, "\nREFERER\tREDIRECTS\n")
+		for _, referer := range referers {
+			name := referer
+			if name == "" {
+				name = "-"
+			}
+			fmt.Fprintf(tw, "%s\t%d\n", name, url.RedirectFrom[referer])
+		}
+	}
+	return tw.Flush()
+}
+
+/* GCCommand removes expired sessions and statistics older than StatsDays. With '-deleted' it also purges deleted links. */
+func GCCommand(args []string) error {
+	fs := flag.NewFlagSet("gc", flag.ContinueOnError)
+	deleted := fs.Bool("deleted", false, "")
+	if _, err := ParseCommandFlags(fs, args, 0); err != nil {
+		return err
+	}
+
+	now := time.Unix()
+	oldest := UnixDay(int64(now)) - StatsDays
+
+	var nsessions, ndays, nlinks int
+
+	SessionsLock.Lock()
+	for token, session := range Sessions {
+		if now > session.Expiry {
+			delete(Sessions, token)
+			nsessions++
+		}
+	}
+	SessionsLock.Unlock()
+
+	var purge []string
+	URLsLock.Lock()
+	for path, url := range URLs {
+		if (*deleted) && (url.Flags&FlagDeleted == FlagDeleted) {
+			purge = append(purge, path)
+			continue
+		}
+		for day := range url.RedirectCounts {
+			if day <= oldest {
+				delete(url.RedirectCounts, day)
+				ndays++
+			}
+		}
+		for day := range url.ScanCounts {
+			if day <= oldest {
+				delete(url.ScanCounts, day)
+			}
+		}
+	}
+	URLsLock.Unlock()
+
+	for _, path := range purge {
+		if DeleteURL(path) == nil {
+			nlinks++
+		}
+	}
+
+	fmt.Printf("Removed %d expired sessions, %d days of old statistics and %d deleted links.\n", nsessions, ndays, nlinks)
+	return nil
+}
+
+/* CheckCommand looks for inconsistencies in data files. */
+func CheckCommand(args []string) error {
+	if len(args) != 0 {
+		return UsageError
+	}
+
+	var problems int
+	report := func(format string, args ...interface{}) {
+		fmt.Printf(format+"\n", args...)
+		problems++
+	}
+
+	UsersLock.RLock()
+	emails := make(map[string]database.ID)
+	for id, user := range Users {
+		if id != user.ID {
+			report("user %d is stored under ID %d", user.ID, id)
+		}
+		if other, ok := emails[user.Email]; ok {
+			report("users %d and %d have the same email %q", other, user.ID, user.Email)
+		}
+		emails[user.Email] = user.ID
+	}
+	UsersLock.RUnlock()
+
+	var user User
+	ids := make(map[database.ID]string)
+
+	URLsLock.RLock()
+	for path, u := range URLs {
+		if other, ok := ids[u.ID]; ok {
+			report("links %q and %q have the same ID %d", other, path, u.ID)
+		}
+		ids[u.ID] = path
+
+		if (u.UserID != 0) && (GetUserByID(u.UserID, &user) != nil) {
+			report("link %q belongs to user %d that does not exist", path, u.UserID)
+		}
+		if _, err := url.Parse(u.RawURL); (err != nil) || (u.RawURL == "") {
+			report("link %q has invalid target %q", path, u.RawURL)
+		}
+		if (u.Redirects < 0) || (u.Scans > u.Redirects) {
+			report("link %q has invalid number of redirects %d and scans %d", path, u.Redirects, u.Scans)
+		}
+	}
+	URLsLock.RUnlock()
+
+	SessionsLock.RLock()
+	for _, session := range Sessions {
+		if GetUserByID(session.ID, &user) != nil {
+			report("session belongs to user %d that does not exist", session.ID)
+		}
+	}
+	SessionsLock.RUnlock()
+
+	if problems > 0 {
+		return fmt.Errorf("found %d problems", problems)
+	}
+	fmt.Println("No problems found.")
+	return nil
+}
@@ -0,0 +1,567 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	stdtime "time"
+
+	"github.com/anton2920/gofa/errors"
+	"github.com/anton2920/gofa/log"
+	"github.com/anton2920/gofa/net/http"
+	"github.com/anton2920/gofa/trace"
+)
+
+/* Config holds everything that may be changed without rebuilding the server. */
+type Config struct {
+	/* Address of TCP listener, empty means none. */
+	Address string
+
+	/* UnixSocket is path of Unix domain socket listener, empty means none. */
+	UnixSocket     string
+	UnixSocketMode os.FileMode
+
+	/* TrustUnixSocket makes 'X-Forwarded-For' believed from connections over ListenerUnix, which only local reverse proxy should reach. */
+	TrustUnixSocket bool
+
+	/* TLSAddress is address of HTTPS listener, certificates are matched with names clients ask for. */
+	TLSAddress   string
+	TLSCertFiles []string
+	TLSKeyFiles  []string
+
+	/* RedirectAddress is address of listener sending clients to HTTPS. */
+	RedirectAddress string
+
+	/* TrustedProxies are networks of reverse proxies whose 'X-Forwarded-For' is believed. */
+	TrustedProxies []netip.Prefix
+
+	Backlog int
+
+	/* Workers of zero means choose automatically. */
+	Workers           int
+	ContextsPerWorker int
+	ShutdownTimeout   stdtime.Duration
+
+	DataDir          string
+	GeoIPFile        string
+	BlocklistFile    string
+	LocalizationFile string
+
+	LogLevel log.Level
+
+	SessionLifetime stdtime.Duration
+	SecureCookies   bool
+
+	BaseURL               string
+	DefaultRedirectStatus http.Status
+	DefaultFallbackURL    string
+
+	MaxURLLen      int
+	MinPasswordLen int
+	MaxPasswordLen int
+
+	MetadataFetch        bool
+	MetadataAllowPrivate bool
+}
+
+const (
+	/* DefaultConfigFile is read if it exists and no other file is specified. */
+	DefaultConfigFile = "shortener.conf"
+
+	/* ConfigEnvPrefix is prepended to names of options to get names of environment variables overriding them. */
+	ConfigEnvPrefix = "SHORTENER_"
+
+	MinSessionLifetime  = stdtime.Minute
+	MaxURLLenLimit      = 8192
+	MaxPasswordLenLimit = 1024
+)
+
+var CurrentConfig atomic.Pointer[Config]
+
+func init() {
+	cfg := DefaultConfig()
+	CurrentConfig.Store(&cfg)
+}
+
+/* GetConfig returns configuration in effect. It must not be modified. */
+func GetConfig() *Config {
+	return CurrentConfig.Load()
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Address: "0.0.0.0:7075",
+
+		UnixSocketMode:  0660,
+		TrustUnixSocket: true,
+
+		Backlog: 128,
+
+		ContextsPerWorker: 512,
+		ShutdownTimeout:   10 * stdtime.Second,
+
+		DataDir:   ".",
+		GeoIPFile: "geoip.csv",
+
+		LogLevel: DefaultLogLevel(),
+
+		SessionLifetime: 7 * 24 * stdtime.Hour,
+		SecureCookies:   !Debug,
+
+		DefaultRedirectStatus: http.StatusSeeOther,
+
+		MaxURLLen:      128,
+		MinPasswordLen: 5,
+		MaxPasswordLen: 64,
+
+		MetadataFetch: true,
+	}
+}
+
+/* StatusValue makes redirect status settable by flag package. */
+type StatusValue http.Status
+
+func (v *StatusValue) String() string {
+	return strconv.Itoa(int(*v))
+}
+
+func (v *StatusValue) Set(s string) error {
+	status, err := strconv.Atoi(s)
+	if err != nil {
+		return err
+	}
+	*v = StatusValue(status)
+	return nil
+}
+
+var LogLevel2String = [...]string{
+	log.LevelDebug: "debug",
+	log.LevelInfo:  "info",
+	log.LevelWarn:  "warn",
+	log.LevelError: "error",
+	log.LevelFatal: "fatal",
+}
+
+func DefaultLogLevel() log.Level {
+	if Debug {
+		return log.LevelDebug
+	}
+	return log.LevelInfo
+}
+
+/* ListValue makes comma-separated lists settable by flag package. */
+type ListValue []string
+
+func (v *ListValue) String() string {
+	return strings.Join(*v, ",")
+}
+
+func (v *ListValue) Set(s string) error {
+	*v = nil
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*v = append(*v, item)
+		}
+	}
+	return nil
+}
+
+/* PrefixListValue makes comma-separated lists of networks settable by flag package. Bare addresses mean single hosts. */
+type PrefixListValue []netip.Prefix
+
+func (v *PrefixListValue) String() string {
+	items := make([]string, len(*v))
+	for i, prefix := range *v {
+		items[i] = prefix.String()
+	}
+	return strings.Join(items, ",")
+}
+
+func (v *PrefixListValue) Set(s string) error {
+	*v = nil
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			addr, err := netip.ParseAddr(item)
+			if err != nil {
+				return fmt.Errorf("%q is neither address nor network", item)
+			}
+			addr = addr.Unmap()
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		*v = append(*v, prefix.Masked())
+	}
+	return nil
+}
+
+/* FileModeValue makes permissions settable by flag package in octal. */
+type FileModeValue os.FileMode
+
+func (v *FileModeValue) String() string {
+	return fmt.Sprintf("%#o", uint32(*v))
+}
+
+func (v *FileModeValue) Set(s string) error {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return err
+	}
+	*v = FileModeValue(mode)
+	return nil
+}
+
+/* LogLevelValue makes log level settable by flag package. */
+type LogLevelValue log.Level
+
+func (v *LogLevelValue) String() string {
+	return LogLevel2String[*v]
+}
+
+func (v *LogLevelValue) Set(s string) error {
+	for level := range LogLevel2String {
+		if LogLevel2String[level] == s {
+			*v = LogLevelValue(level)
+			return nil
+		}
+	}
+	return errors.New("must be one of debug, info, warn, error or fatal")
+}
+
+/* ConfigFlags defines options of 'cfg' in 'fs'. The same names are used in configuration file and, with ConfigEnvPrefix, in environment. */
+func ConfigFlags(fs *flag.FlagSet, cfg *Config) {
+	fs.StringVar(&cfg.Address, "address", cfg.Address, "TCP address to listen on, empty disables TCP listener")
+	fs.StringVar(&cfg.UnixSocket, "unix-socket", cfg.UnixSocket, "path of Unix domain socket to listen on, empty disables it")
+	fs.Var((*FileModeValue)(&cfg.UnixSocketMode), "unix-socket-mode", "permissions of Unix domain socket in octal")
+	fs.BoolVar(&cfg.TrustUnixSocket, "trust-unix-socket", cfg.TrustUnixSocket, "believe 'X-Forwarded-For' from connections over Unix domain sockets")
+	fs.StringVar(&cfg.TLSAddress, "tls-address", cfg.TLSAddress, "TCP address to listen on for HTTPS, empty disables it")
+	fs.Var((*ListValue)(&cfg.TLSCertFiles), "tls-cert", "comma-separated PEM files with certificate chains, one for each domain")
+	fs.Var((*ListValue)(&cfg.TLSKeyFiles), "tls-key", "comma-separated PEM files with private keys in the same order as certificates")
+	fs.StringVar(&cfg.RedirectAddress, "redirect-address", cfg.RedirectAddress, "TCP address to listen on for redirects from HTTP to HTTPS, empty disables it")
+	fs.Var((*PrefixListValue)(&cfg.TrustedProxies), "trusted-proxies", "comma-separated addresses and networks of reverse proxies allowed to set 'X-Forwarded-For'")
+	fs.IntVar(&cfg.Backlog, "backlog", cfg.Backlog, "maximum length of the queue of pending connections")
+	fs.IntVar(&cfg.Workers, "workers", cfg.Workers, "number of worker threads, 0 means choose automatically")
+	fs.IntVar(&cfg.ContextsPerWorker, "contexts-per-worker", cfg.ContextsPerWorker, "number of connections each worker can serve at the same time")
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", cfg.ShutdownTimeout, "how long to wait for connections to finish on shutdown")
+
+	fs.StringVar(&cfg.DataDir, "data-dir", cfg.DataDir, "directory with data files")
+	fs.StringVar(&cfg.GeoIPFile, "geoip-file", cfg.GeoIPFile, "CSV file with GeoIP database, empty disables geo targeting")
+	fs.StringVar(&cfg.BlocklistFile, "blocklist-file", cfg.BlocklistFile, "file with hosts links must not lead to, one per line")
+	fs.StringVar(&cfg.LocalizationFile, "localization-file", cfg.LocalizationFile, "JSON file with translations overriding built-in ones")
+
+	fs.Var((*LogLevelValue)(&cfg.LogLevel), "log-level", "minimum level of logged messages: debug, info, warn, error or fatal")
+
+	fs.DurationVar(&cfg.SessionLifetime, "session-lifetime", cfg.SessionLifetime, "how long user stays signed in without activity")
+	fs.BoolVar(&cfg.SecureCookies, "secure-cookies", cfg.SecureCookies, "send cookies only over HTTPS")
+
+	fs.StringVar(&cfg.BaseURL, "base-url", cfg.BaseURL, "public address of the service like 'https://example.com', empty means derive it from requests")
+	fs.Var((*StatusValue)(&cfg.DefaultRedirectStatus), "redirect-status", "status code of redirects for links that have not chosen their own")
+	fs.StringVar(&cfg.DefaultFallbackURL, "fallback-url", cfg.DefaultFallbackURL, "where to send visitors of unavailable links that have no fallback of their own")
+
+	fs.IntVar(&cfg.MaxURLLen, "max-url-len", cfg.MaxURLLen, "maximum length of destination URL")
+	fs.IntVar(&cfg.MinPasswordLen, "min-password-len", cfg.MinPasswordLen, "minimum length of passwords")
+	fs.IntVar(&cfg.MaxPasswordLen, "max-password-len", cfg.MaxPasswordLen, "maximum length of passwords")
+
+	fs.BoolVar(&cfg.MetadataFetch, "metadata-fetch", cfg.MetadataFetch, "fetch titles and descriptions of destination pages")
+	fs.BoolVar(&cfg.MetadataAllowPrivate, "metadata-allow-private", cfg.MetadataAllowPrivate, "allow fetching metadata from private networks")
+}
+
+/* ConfigEnvName returns name of environment variable for option 'name', e.g. 'SHORTENER_DATA_DIR' for 'data-dir'. */
+func ConfigEnvName(name string) string {
+	return ConfigEnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+/* ApplyConfigFile sets options from lines of the form 'name = value'. Empty lines and lines starting with '#' are ignored. */
+func ApplyConfigFile(fs *flag.FlagSet, filename string, r io.Reader) error {
+	defer trace.End(trace.Begin(""))
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if (len(text) == 0) || (text[0] == '#') {
+			continue
+		}
+
+		name, value, ok := strings.Cut(text, "=")
+		if !ok {
+			return fmt.Errorf("%s:%d: expected 'name = value'", filename, line)
+		}
+		name = strings.TrimSpace(name)
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("%s:%d: unknown option %q", filename, line, name)
+		}
+		if err := fs.Set(name, strings.TrimSpace(value)); err != nil {
+			return fmt.Errorf("%s:%d: invalid value for %q: %v", filename, line, name, err)
+		}
+	}
+	return scanner.Err()
+}
+
+func ApplyConfigEnv(fs *flag.FlagSet) error {
+	var err error
+
+	fs.VisitAll(func(f *flag.Flag) {
+		env := ConfigEnvName(f.Name)
+		if value, ok := os.LookupEnv(env); (ok) && (err == nil) {
+			if e := fs.Set(f.Name, value); e != nil {
+				err = fmt.Errorf("invalid value of %s: %v", env, e)
+			}
+		}
+	})
+	return err
+}
+
+/*
+ * LoadConfig builds configuration from defaults, configuration file, environment and command-line flags 'args', each overriding the previous.
+ * It also reports whether '-print-config' was requested.
+ */
+func LoadConfig(args []string) (*Config, bool, error) {
+	defer trace.End(trace.Begin(""))
+
+	/* NOTE(anton2920): flags must win over file and environment, so they are parsed first only to learn which of them are set. */
+	var scratch Config
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	ConfigFlags(fs, &scratch)
+	filename := fs.String("config", "", "configuration file, also "+ConfigEnvPrefix+"CONFIG")
+	printConfig := fs.Bool("print-config", false, "print configuration in effect and exit")
+	if err := fs.Parse(args); err != nil {
+		return nil, false, err
+	}
+	if fs.NArg() > 0 {
+		return nil, false, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+
+	cfg := DefaultConfig()
+	cfs := flag.NewFlagSet("config", flag.ContinueOnError)
+	ConfigFlags(cfs, &cfg)
+
+	if *filename == "" {
+		*filename = os.Getenv(ConfigEnvPrefix + "CONFIG")
+	}
+	required := *filename != ""
+	if !required {
+		*filename = DefaultConfigFile
+	}
+	f, err := os.Open(*filename)
+	if err == nil {
+		err = ApplyConfigFile(cfs, *filename, f)
+		f.Close()
+		if err != nil {
+			return nil, false, err
+		}
+	} else if (required) || (!os.IsNotExist(err)) {
+		return nil, false, err
+	}
+
+	if err := ApplyConfigEnv(cfs); err != nil {
+		return nil, false, err
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		if cfs.Lookup(f.Name) != nil {
+			cfs.Set(f.Name, f.Value.String())
+		}
+	})
+
+	if err := ConfigValid(&cfg); err != nil {
+		return nil, false, err
+	}
+	return &cfg, *printConfig, nil
+}
+
+func ConfigValid(cfg *Config) error {
+	defer trace.End(trace.Begin(""))
+
+	for _, address := range [...]string{cfg.Address, cfg.TLSAddress, cfg.RedirectAddress} {
+		if address == "" {
+			continue
+		}
+		if _, port, err := net.SplitHostPort(address); err != nil {
+			return fmt.Errorf("invalid address %q: %v", address, err)
+		} else if n, err := strconv.Atoi(port); (err != nil) || (n < 0) || (n > 65535) {
+			return fmt.Errorf("invalid port in address %q", address)
+		}
+	}
+	if len(cfg.TLSCertFiles) != len(cfg.TLSKeyFiles) {
+		return errors.New("number of TLS certificates and keys must be the same")
+	}
+	if (cfg.TLSAddress != "") && (len(cfg.TLSCertFiles) == 0) {
+		return errors.New("TLS listener requires at least one certificate")
+	}
+	if cfg.UnixSocketMode&^os.ModePerm != 0 {
+		return fmt.Errorf("invalid permissions %#o of Unix domain socket", uint32(cfg.UnixSocketMode))
+	}
+	if cfg.Backlog <= 0 {
+		return errors.New("backlog must be positive")
+	}
+	if cfg.Workers < 0 {
+		return errors.New("number of workers must not be negative")
+	}
+	if cfg.ContextsPerWorker <= 0 {
+		return errors.New("number of contexts per worker must be positive")
+	}
+	if cfg.ShutdownTimeout < 0 {
+		return errors.New("shutdown timeout must not be negative")
+	}
+
+	if cfg.DataDir == "" {
+		return errors.New("data directory must not be empty")
+	}
+
+	if cfg.SessionLifetime < MinSessionLifetime {
+		return fmt.Errorf("session lifetime must be at least %v", MinSessionLifetime)
+	}
+
+	if cfg.BaseURL != "" {
+		u, err := url.Parse(cfg.BaseURL)
+		if (err != nil) || ((u.Scheme != "http") && (u.Scheme != "https")) || (u.Host == "") {
+			return fmt.Errorf("base URL %q must be absolute HTTP or HTTPS URL", cfg.BaseURL)
+		}
+		if strings.HasSuffix(cfg.BaseURL, "/") {
+			return fmt.Errorf("base URL %q must not end with '/'", cfg.BaseURL)
+		}
+	}
+	if _, ok := RedirectStatus2String[cfg.DefaultRedirectStatus]; !ok {
+		return fmt.Errorf("redirect status %d is not supported", cfg.DefaultRedirectStatus)
+	}
+	if cfg.DefaultFallbackURL != "" {
+		if u, err := url.Parse(cfg.DefaultFallbackURL); (err != nil) || (u.Scheme == "") {
+			return fmt.Errorf("fallback URL %q must be absolute", cfg.DefaultFallbackURL)
+		}
+	}
+
+	if (cfg.MaxURLLen < MinURLLen) || (cfg.MaxURLLen > MaxURLLenLimit) {
+		return fmt.Errorf("maximum length of URL must be between %d and %d", MinURLLen, MaxURLLenLimit)
+	}
+	if (cfg.MinPasswordLen < 1) || (cfg.MaxPasswordLen < cfg.MinPasswordLen) || (cfg.MaxPasswordLen > MaxPasswordLenLimit) {
+		return fmt.Errorf("password lengths must satisfy 1 <= minimum <= maximum <= %d", MaxPasswordLenLimit)
+	}
+
+	return nil
+}
+
+/* ConfigResources are loaded from files named in configuration. */
+type ConfigResources struct {
+	GeoIP         *GeoIPDatabase
+	Blocklist     *Blocklist
+	Localizations *LocalizationCatalog
+	Certificates  *CertificateStore
+}
+
+func LoadConfigResources(cfg *Config) (ConfigResources, error) {
+	defer trace.End(trace.Begin(""))
+
+	var res ConfigResources
+	var err error
+
+	if cfg.GeoIPFile != "" {
+		if res.GeoIP, err = LoadGeoIPFromFile(cfg.GeoIPFile); err != nil {
+			/* NOTE(anton2920): without GeoIP database geo rules are just skipped, so server can do without it. */
+			log.Warnf("Failed to load GeoIP database from file: %v", err)
+			res.GeoIP = GeoIP.Load()
+		}
+	}
+	if cfg.BlocklistFile != "" {
+		if res.Blocklist, err = LoadBlocklistFromFile(cfg.BlocklistFile); err != nil {
+			return res, fmt.Errorf("failed to load blocklist: %v", err)
+		}
+	}
+	if cfg.LocalizationFile != "" {
+		if res.Localizations, err = LoadLocalizationsFromFile(cfg.LocalizationFile); err != nil {
+			return res, fmt.Errorf("failed to load localizations: %v", err)
+		}
+	}
+	if len(cfg.TLSCertFiles) > 0 {
+		if res.Certificates, err = LoadCertificatesFromFiles(cfg.TLSCertFiles, cfg.TLSKeyFiles); err != nil {
+			return res, fmt.Errorf("failed to load TLS certificates: %v", err)
+		}
+	}
+
+	return res, nil
+}
+
+/* ApplyConfig puts 'cfg' and 'res' in effect. Requests that are being served may still see previous values. */
+func ApplyConfig(cfg *Config, res *ConfigResources) {
+	defer trace.End(trace.Begin(""))
+
+	CurrentConfig.Store(cfg)
+	GeoIP.Store(res.GeoIP)
+	CurrentBlocklist.Store(res.Blocklist)
+	CurrentLocalizations.Store(res.Localizations)
+	CurrentCertificates.Store(res.Certificates)
+	log.SetLevel(cfg.LogLevel)
+}
+
+/* ReloadConfig reads configuration and files it names again. If anything is invalid, configuration in effect stays unchanged. */
+func ReloadConfig(args []string) error {
+	defer trace.End(trace.Begin(""))
+
+	cfg, _, err := LoadConfig(args)
+	if err != nil {
+		return err
+	}
+
+	/* NOTE(anton2920): listeners, workers and data files are set up only once. */
+	old := GetConfig()
+	if (cfg.Address != old.Address) || (cfg.UnixSocket != old.UnixSocket) || (cfg.UnixSocketMode != old.UnixSocketMode) || (cfg.TLSAddress != old.TLSAddress) || (cfg.RedirectAddress != old.RedirectAddress) || (cfg.Backlog != old.Backlog) || (cfg.Workers != old.Workers) || (cfg.ContextsPerWorker != old.ContextsPerWorker) || (cfg.DataDir != old.DataDir) {
+		log.Warnf("Changes of listeners, backlog, workers, contexts per worker and data directory take effect after restart")
+		cfg.Address = old.Address
+		cfg.UnixSocket = old.UnixSocket
+		cfg.UnixSocketMode = old.UnixSocketMode
+		cfg.TLSAddress = old.TLSAddress
+		cfg.RedirectAddress = old.RedirectAddress
+		cfg.Backlog = old.Backlog
+		cfg.Workers = old.Workers
+		cfg.ContextsPerWorker = old.ContextsPerWorker
+		cfg.DataDir = old.DataDir
+	}
+	/* NOTE(anton2920): listeners that stay open must still be valid with the rest of new configuration, like HTTPS one without certificates. */
+	if err := ConfigValid(cfg); err != nil {
+		return err
+	}
+
+	res, err := LoadConfigResources(cfg)
+	if err != nil {
+		return err
+	}
+
+	ApplyConfig(cfg, &res)
+	return nil
+}
+
+/* WriteConfig writes 'cfg' in format of configuration file. */
+func WriteConfig(w io.Writer, cfg *Config) {
+	c := *cfg
+	fs := flag.NewFlagSet("config", flag.ContinueOnError)
+	ConfigFlags(fs, &c)
+
+	fs.VisitAll(func(f *flag.Flag) {
+		fmt.Fprintf(w, "# %s.\n%s = %s\n\n", f.Usage, f.Name, f.Value.String())
+	})
+}
+
+/* DataFilePath returns path of data file 'name' inside of configured data directory. */
+func DataFilePath(name string) string {
+	return filepath.Join(GetConfig().DataDir, name)
+}
+
+/* NumWorkers returns number of worker threads to start. */
+func NumWorkers(cfg *Config) int {
+	if cfg.Workers > 0 {
+		return cfg.Workers
+	}
+	return max(min(runtime.GOMAXPROCS(0)/2, runtime.NumCPU()), 1)
+}
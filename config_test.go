@@ -0,0 +1,261 @@
+package main
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	stdtime "time"
+)
+
+/* WriteTestConfigFile creates configuration file with 'contents' in temporary directory and returns its path. */
+func WriteTestConfigFile(t *testing.T, contents string) string {
+	t.Helper()
+
+	filename := filepath.Join(t.TempDir(), "test.conf")
+	if err := os.WriteFile(filename, []byte(contents), 0600); err != nil {
+		t.Fatalf("Failed to write configuration file: %v", err)
+	}
+	return filename
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	cfg, printConfig, err := LoadConfig(nil)
+	if err != nil {
+		t.Fatalf("LoadConfig() failed: %v", err)
+	}
+	if printConfig {
+		t.Errorf("LoadConfig() requested printing configuration")
+	}
+
+	expected := DefaultConfig()
+	if (cfg.Address != expected.Address) || (cfg.DataDir != expected.DataDir) || (cfg.MaxURLLen != expected.MaxURLLen) || (cfg.SessionLifetime != expected.SessionLifetime) || (len(cfg.TrustedProxies) != 0) {
+		t.Errorf("LoadConfig() = %+v, expected defaults %+v", cfg, expected)
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	filename := WriteTestConfigFile(t, `
+# Comment.
+address = 127.0.0.1:8080
+backlog = 64
+data-dir = /from/file
+max-url-len = 256
+trusted-proxies = 10.0.0.0/8, 192.168.1.1
+log-level = warn
+`)
+	t.Setenv("SHORTENER_DATA_DIR", "/from/env")
+	t.Setenv("SHORTENER_MAX_URL_LEN", "512")
+	t.Setenv("SHORTENER_SESSION_LIFETIME", "1h")
+
+	cfg, printConfig, err := LoadConfig([]string{"-config", filename, "-max-url-len", "1024", "-print-config"})
+	if err != nil {
+		t.Fatalf("LoadConfig() failed: %v", err)
+	}
+	if !printConfig {
+		t.Errorf("LoadConfig() ignored -print-config")
+	}
+
+	tests := [...]struct {
+		Name     string
+		Value    interface{}
+		Expected interface{}
+	}{
+		{"address from file", cfg.Address, "127.0.0.1:8080"},
+		{"backlog from file", cfg.Backlog, 64},
+		{"log level from file", LogLevel2String[cfg.LogLevel], "warn"},
+		{"data directory from environment", cfg.DataDir, "/from/env"},
+		{"session lifetime from environment", cfg.SessionLifetime, stdtime.Hour},
+		{"maximum URL length from flag", cfg.MaxURLLen, 1024},
+		{"default contexts per worker", cfg.ContextsPerWorker, DefaultConfig().ContextsPerWorker},
+		{"trusted proxies from file", (*PrefixListValue)(&cfg.TrustedProxies).String(), "10.0.0.0/8,192.168.1.1/32"},
+	}
+	for _, test := range tests {
+		if test.Value != test.Expected {
+			t.Errorf("%s: got %v, expected %v", test.Name, test.Value, test.Expected)
+		}
+	}
+}
+
+func TestLoadConfigFileFromEnv(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	t.Setenv("SHORTENER_CONFIG", WriteTestConfigFile(t, "workers = 3\n"))
+	cfg, _, err := LoadConfig(nil)
+	if err != nil {
+		t.Fatalf("LoadConfig() failed: %v", err)
+	}
+	if cfg.Workers != 3 {
+		t.Errorf("LoadConfig() read %d workers, expected 3", cfg.Workers)
+	}
+
+	/* NOTE(anton2920): default file is read only when no other is specified. */
+	if err := os.WriteFile(DefaultConfigFile, []byte("workers = 5\n"), 0600); err != nil {
+		t.Fatalf("Failed to write default configuration file: %v", err)
+	}
+	if cfg, _, err = LoadConfig(nil); (err != nil) || (cfg.Workers != 3) {
+		t.Errorf("LoadConfig() = (%v, %v), expected 3 workers from file in environment", cfg, err)
+	}
+	t.Setenv("SHORTENER_CONFIG", "")
+	if cfg, _, err = LoadConfig(nil); (err != nil) || (cfg.Workers != 5) {
+		t.Errorf("LoadConfig() = (%v, %v), expected 5 workers from default file", cfg, err)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := [...]struct {
+		Name string
+		File string
+		Env  [2]string
+		Args []string
+	}{
+		{"unknown option in file", "no-such-option = 1\n", [2]string{}, nil},
+		{"line without value in file", "address\n", [2]string{}, nil},
+		{"invalid number in file", "backlog = many\n", [2]string{}, nil},
+		{"invalid log level in file", "log-level = loud\n", [2]string{}, nil},
+		{"invalid network in file", "trusted-proxies = 10.0.0.0/33\n", [2]string{}, nil},
+		{"invalid duration in environment", "", [2]string{"SHORTENER_SHUTDOWN_TIMEOUT", "soon"}, nil},
+		{"invalid mode in environment", "", [2]string{"SHORTENER_UNIX_SOCKET_MODE", "rw"}, nil},
+		{"unknown flag", "", [2]string{}, []string{"-no-such-flag"}},
+		{"unexpected argument", "", [2]string{}, []string{"serve"}},
+		{"missing file", "", [2]string{}, []string{"-config", "/nonexistent/shortener.conf"}},
+		{"invalid configuration", "backlog = 0\n", [2]string{}, nil},
+		{"flag makes configuration invalid", "", [2]string{}, []string{"-base-url", "example.com"}},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+
+			args := test.Args
+			if test.File != "" {
+				args = append([]string{"-config", WriteTestConfigFile(t, test.File)}, args...)
+			}
+			if test.Env[0] != "" {
+				t.Setenv(test.Env[0], test.Env[1])
+			}
+
+			if _, _, err := LoadConfig(args); err == nil {
+				t.Errorf("LoadConfig() succeeded, expected error")
+			}
+		})
+	}
+}
+
+func TestWriteConfig(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	cfg := DefaultConfig()
+	cfg.UnixSocket = "/run/shortener.sock"
+	cfg.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("::1/128")}
+	cfg.BaseURL = "https://example.com"
+	cfg.MaxURLLen = 2048
+
+	var buf strings.Builder
+	WriteConfig(&buf, &cfg)
+
+	loaded, _, err := LoadConfig([]string{"-config", WriteTestConfigFile(t, buf.String())})
+	if err != nil {
+		t.Fatalf("LoadConfig() of written configuration failed: %v", err)
+	}
+	if (loaded.UnixSocket != cfg.UnixSocket) || (loaded.BaseURL != cfg.BaseURL) || (loaded.MaxURLLen != cfg.MaxURLLen) || ((*PrefixListValue)(&loaded.TrustedProxies).String() != "10.0.0.0/8,::1/128") {
+		t.Errorf("LoadConfig() of written configuration = %+v, expected %+v", loaded, cfg)
+	}
+}
+
+func TestConfigValid(t *testing.T) {
+	tests := [...]struct {
+		Name   string
+		Modify func(cfg *Config)
+		Valid  bool
+	}{
+		{"defaults", func(cfg *Config) {}, true},
+		{"no listeners", func(cfg *Config) { cfg.Address = "" }, true},
+		{"address without port", func(cfg *Config) { cfg.Address = "127.0.0.1" }, false},
+		{"address with invalid port", func(cfg *Config) { cfg.Address = "127.0.0.1:65536" }, false},
+		{"redirect address with named port", func(cfg *Config) { cfg.RedirectAddress = ":http" }, false},
+		{"TLS without certificates", func(cfg *Config) { cfg.TLSAddress = ":443" }, false},
+		{"TLS with certificate", func(cfg *Config) {
+			cfg.TLSAddress = ":443"
+			cfg.TLSCertFiles = []string{"cert.pem"}
+			cfg.TLSKeyFiles = []string{"key.pem"}
+		}, true},
+		{"certificate without key", func(cfg *Config) { cfg.TLSCertFiles = []string{"cert.pem"} }, false},
+		{"socket mode with extra bits", func(cfg *Config) { cfg.UnixSocketMode = 01777 }, false},
+		{"zero backlog", func(cfg *Config) { cfg.Backlog = 0 }, false},
+		{"negative workers", func(cfg *Config) { cfg.Workers = -1 }, false},
+		{"zero contexts", func(cfg *Config) { cfg.ContextsPerWorker = 0 }, false},
+		{"negative shutdown timeout", func(cfg *Config) { cfg.ShutdownTimeout = -stdtime.Second }, false},
+		{"empty data directory", func(cfg *Config) { cfg.DataDir = "" }, false},
+		{"short session lifetime", func(cfg *Config) { cfg.SessionLifetime = stdtime.Second }, false},
+		{"base URL", func(cfg *Config) { cfg.BaseURL = "https://example.com:8443" }, true},
+		{"relative base URL", func(cfg *Config) { cfg.BaseURL = "example.com" }, false},
+		{"base URL with other scheme", func(cfg *Config) { cfg.BaseURL = "ftp://example.com" }, false},
+		{"base URL with trailing slash", func(cfg *Config) { cfg.BaseURL = "https://example.com/" }, false},
+		{"permanent redirect status", func(cfg *Config) { cfg.DefaultRedirectStatus = 308 }, true},
+		{"unsupported redirect status", func(cfg *Config) { cfg.DefaultRedirectStatus = 200 }, false},
+		{"fallback URL", func(cfg *Config) { cfg.DefaultFallbackURL = "https://example.com/gone" }, true},
+		{"relative fallback URL", func(cfg *Config) { cfg.DefaultFallbackURL = "/gone" }, false},
+		{"zero maximum URL length", func(cfg *Config) { cfg.MaxURLLen = 0 }, false},
+		{"huge maximum URL length", func(cfg *Config) { cfg.MaxURLLen = MaxURLLenLimit + 1 }, false},
+		{"zero minimum password length", func(cfg *Config) { cfg.MinPasswordLen = 0 }, false},
+		{"minimum password length above maximum", func(cfg *Config) { cfg.MinPasswordLen = cfg.MaxPasswordLen + 1 }, false},
+		{"huge maximum password length", func(cfg *Config) { cfg.MaxPasswordLen = MaxPasswordLenLimit + 1 }, false},
+	}
+	for _, test := range tests {
+		cfg := DefaultConfig()
+		test.Modify(&cfg)
+
+		if err := ConfigValid(&cfg); (err == nil) != test.Valid {
+			t.Errorf("%s: ConfigValid() = %v, expected valid %v", test.Name, err, test.Valid)
+		}
+	}
+}
+
+func TestReloadConfigKeepsListeners(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	old := DefaultConfig()
+	old.TLSAddress = ":443"
+	old.TLSCertFiles = []string{"cert.pem"}
+	old.TLSKeyFiles = []string{"key.pem"}
+	previous := CurrentConfig.Swap(&old)
+	defer CurrentConfig.Store(previous)
+
+	/* NOTE(anton2920): HTTPS listener stays open after reload, so dropping its certificates must be refused. */
+	if err := ReloadConfig(nil); err == nil {
+		t.Errorf("ReloadConfig() without certificates succeeded, expected error")
+	}
+	if GetConfig() != &old {
+		t.Errorf("ReloadConfig() changed configuration in effect after failure")
+	}
+}
+
+func TestPrefixListValue(t *testing.T) {
+	tests := [...]struct {
+		Value    string
+		Expected string
+		Error    bool
+	}{
+		{"", "", false},
+		{"10.0.0.0/8", "10.0.0.0/8", false},
+		{" 10.1.2.3/8 , , 192.168.1.1 ", "10.0.0.0/8,192.168.1.1/32", false},
+		{"::ffff:10.0.0.1", "10.0.0.1/32", false},
+		{"2001:db8::/32,::1", "2001:db8::/32,::1/128", false},
+		{"10.0.0.0/33", "", true},
+		{"proxy.example.com", "", true},
+	}
+	for _, test := range tests {
+		var v PrefixListValue
+		err := v.Set(test.Value)
+		if (err != nil) != test.Error {
+			t.Errorf("Set(%q) returned error %v, expected error %v", test.Value, err, test.Error)
+		} else if (err == nil) && (v.String() != test.Expected) {
+			t.Errorf("Set(%q) = %s, expected %s", test.Value, v.String(), test.Expected)
+		}
+	}
+}
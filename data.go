@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/gob"
+	"os"
+	"syscall"
+	stdtime "time"
+
+	"github.com/anton2920/gofa/database"
+	"github.com/anton2920/gofa/errors"
+	"github.com/anton2920/gofa/trace"
+)
+
+/* LockFile is locked by whoever works with data files: exclusively by server and by commands that change data, shared by commands that read it. */
+const LockFile = "shortener.lock"
+
+/* DataLockTimeout is how long server waits for commands working with data files to finish before it starts. */
+const DataLockTimeout = 30 * stdtime.Second
+
+var DataFilesBusy = errors.New("data files are in use by another process")
+
+func LockDataFiles(exclusive bool) (*os.File, error) {
+	defer trace.End(trace.Begin(""))
+
+	f, err := os.OpenFile(DataFilePath(LockFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	if err := syscall.Flock(int(f.Fd()), how|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, DataFilesBusy
+		}
+		return nil, err
+	}
+
+	return f, nil
+}
+
+/* WaitDataFiles takes exclusive lock of data files, waiting for previous process or running commands to release it. */
+func WaitDataFiles(timeout stdtime.Duration) (*os.File, error) {
+	defer trace.End(trace.Begin(""))
+
+	deadline := stdtime.Now().Add(timeout)
+	for {
+		f, err := LockDataFiles(true)
+		if (err != DataFilesBusy) || (stdtime.Now().After(deadline)) {
+			return f, err
+		}
+		stdtime.Sleep(100 * stdtime.Millisecond)
+	}
+}
+
+func UnlockDataFiles(f *os.File) {
+	f.Close()
+}
+
+/* StoreGobToFile writes 'v' into temporary file and then renames it, so readers never see partially written data. */
+func StoreGobToFile(filename string, v interface{}) error {
+	defer trace.End(trace.Begin(""))
+
+	tmp := filename + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	enc := gob.NewEncoder(f)
+	if err := enc.Encode(v); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, filename)
+}
+
+func RestoreGobFromFile(filename string, v interface{}) error {
+	defer trace.End(trace.Begin(""))
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := gob.NewDecoder(f)
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+/* RestoreDataFromFiles loads users, links and sessions. Missing files are not an error, they are created on store. */
+func RestoreDataFromFiles() error {
+	defer trace.End(trace.Begin(""))
+
+	if err := RestoreUsersFromFile(DataFilePath(UsersFile)); (err != nil) && (!os.IsNotExist(err)) {
+		return err
+	}
+	if err := RestoreURLsFromFile(DataFilePath(URLsFile)); (err != nil) && (!os.IsNotExist(err)) {
+		return err
+	}
+	if err := RestoreSessionsFromFile(DataFilePath(SessionsFile)); (err != nil) && (!os.IsNotExist(err)) {
+		return err
+	}
+	return nil
+}
+
+/* CheckDataFiles reports whether data files can be restored without restoring them. Files are replaced with rename, so they may be read while another process owns them. */
+func CheckDataFiles() error {
+	defer trace.End(trace.Begin(""))
+
+	var users map[database.ID]User
+	if err := RestoreGobFromFile(DataFilePath(UsersFile), &users); (err != nil) && (!os.IsNotExist(err)) {
+		return err
+	}
+	if _, err := DecodeURLsFromFile(DataFilePath(URLsFile)); (err != nil) && (!os.IsNotExist(err)) {
+		return err
+	}
+	var sessions map[string]*Session
+	if err := RestoreGobFromFile(DataFilePath(SessionsFile), &sessions); (err != nil) && (!os.IsNotExist(err)) {
+		return err
+	}
+	return nil
+}
+
+func StoreDataToFiles() error {
+	defer trace.End(trace.Begin(""))
+
+	if err := StoreUsersToFile(DataFilePath(UsersFile)); err != nil {
+		return err
+	}
+	if err := StoreURLsToFile(DataFilePath(URLsFile)); err != nil {
+		return err
+	}
+	if err := StoreSessionsToFile(DataFilePath(SessionsFile)); err != nil {
+		return err
+	}
+	return nil
+}
@@ -18,6 +18,14 @@ func DisplayErrorMessage(w *http.Response, l Language, message string) {
 	}
 }
 
+/* ErrorMessage returns message of 'err' that could be shown to the client. */
+func ErrorMessage(err error) string {
+	if httpError, ok := err.(http.Error); ok {
+		return httpError.DisplayMessage
+	}
+	return http.ServerError(nil).DisplayMessage
+}
+
 func DisplayError(w *http.Response, l Language, err error) {
 	var message string
 
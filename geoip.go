@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/anton2920/gofa/errors"
+	"github.com/anton2920/gofa/trace"
+)
+
+/* GeoIPRange maps addresses in [Start, End] to ISO 3166-1 alpha-2 country code. */
+type GeoIPRange struct {
+	Start   netip.Addr
+	End     netip.Addr
+	Country string
+}
+
+type GeoIPDatabase struct {
+	Ranges []GeoIPRange
+}
+
+var GeoIP atomic.Pointer[GeoIPDatabase]
+
+/* LoadGeoIPFromFile reads CSV file with lines of the form 'start,end,country'. Lines starting with '#' are ignored. */
+func LoadGeoIPFromFile(filename string) (*GeoIPDatabase, error) {
+	defer trace.End(trace.Begin(""))
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.Comment = '#'
+	r.FieldsPerRecord = 3
+	r.ReuseRecord = true
+
+	db := new(GeoIPDatabase)
+	for {
+		record, err := r.Read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+
+		start, err := netip.ParseAddr(strings.TrimSpace(record[0]))
+		if err != nil {
+			return nil, err
+		}
+		end, err := netip.ParseAddr(strings.TrimSpace(record[1]))
+		if err != nil {
+			return nil, err
+		}
+		start, end = start.Unmap(), end.Unmap()
+		if (start.BitLen() != end.BitLen()) || (end.Less(start)) {
+			return nil, errors.New("invalid range " + record[0] + "-" + record[1])
+		}
+
+		country := strings.ToUpper(strings.TrimSpace(record[2]))
+		if len(country) != 2 {
+			return nil, errors.New("invalid country code " + record[2])
+		}
+
+		db.Ranges = append(db.Ranges, GeoIPRange{Start: start, End: end, Country: country})
+	}
+
+	slices.SortFunc(db.Ranges, func(a, b GeoIPRange) int {
+		return a.Start.Compare(b.Start)
+	})
+	return db, nil
+}
+
+/* Country returns country code for 'addr' or empty string if it's unknown. */
+func (db *GeoIPDatabase) Country(addr netip.Addr) string {
+	defer trace.End(trace.Begin(""))
+
+	if (db == nil) || (!addr.IsValid()) {
+		return ""
+	}
+	addr = addr.Unmap()
+
+	/* Find the last range that starts before or at 'addr'. */
+	i, _ := slices.BinarySearchFunc(db.Ranges, addr, func(r GeoIPRange, addr netip.Addr) int {
+		if r.Start.Compare(addr) <= 0 {
+			return -1
+		}
+		return 1
+	})
+	if i == 0 {
+		return ""
+	}
+
+	r := &db.Ranges[i-1]
+	if (r.Start.BitLen() != addr.BitLen()) || (r.End.Less(addr)) {
+		return ""
+	}
+	return r.Country
+}
+
+func GeoIPCountry(addr netip.Addr) string {
+	return GeoIP.Load().Country(addr)
+}
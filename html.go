@@ -27,10 +27,44 @@ func DisplayBodyStart(w *http.Response) {
 	w.WriteString(`<body>`)
 }
 
+func DisplayFormattedDate(w *http.Response, t int64) {
+	w.Write(time.Unix(t, 0).UTC().AppendFormat(make([]byte, 0, 10), "2006/01/02"))
+}
+
 func DisplayFormattedTime(w *http.Response, t int64) {
 	w.Write(time.Unix(t, 0).AppendFormat(make([]byte, 0, 20), "2006/01/02 15:04:05"))
 }
 
+/* DateTimeInputLayout is the format of values of HTML 'datetime-local' inputs. */
+const DateTimeInputLayout = "2006-01-02T15:04"
+
+func DisplayDateTimeInput(w *http.Response, name string, t int64, required bool) {
+	w.WriteString(`<input type="datetime-local" name="`)
+	w.WriteString(name)
+	w.WriteString(`" value="`)
+	if t != 0 {
+		w.Write(time.Unix(t, 0).AppendFormat(make([]byte, 0, len(DateTimeInputLayout)), DateTimeInputLayout))
+	}
+	w.WriteString(`"`)
+	if required {
+		w.WriteString(` required`)
+	}
+	w.WriteString(`>`)
+}
+
+/* ParseDateTimeInput converts value of 'datetime-local' input to Unix time. Empty value results in zero. */
+func ParseDateTimeInput(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	t, err := time.ParseInLocation(DateTimeInputLayout, value, time.Local)
+	if err != nil {
+		return 0, err
+	}
+	return t.Unix(), nil
+}
+
 func DisplayLabel(w *http.Response, l Language, label string) {
 	w.WriteString(`<label>`)
 	w.WriteString(label)
@@ -55,6 +89,50 @@ func DisplayConstraintInput(w *http.Response, t string, minLength int, maxLength
 	w.WriteString(`>`)
 }
 
+func DisplayHiddenInput(w *http.Response, name string, value string) {
+	w.WriteString(`<input type="hidden" name="`)
+	w.WriteString(name)
+	w.WriteString(`" value="`)
+	w.WriteHTMLString(value)
+	w.WriteString(`">`)
+}
+
+func DisplayNumberInput(w *http.Response, min int, max int, name string, value string, required bool) {
+	w.WriteString(`<input type="number" min="`)
+	w.WriteInt(min)
+	w.WriteString(`" max="`)
+	w.WriteInt(max)
+	w.WriteString(`" name="`)
+	w.WriteString(name)
+	w.WriteString(`" value="`)
+	w.WriteHTMLString(value)
+	w.WriteString(`"`)
+	if required {
+		w.WriteString(` required`)
+	}
+	w.WriteString(`>`)
+}
+
+func DisplayCheckbox(w *http.Response, name string, checked bool) {
+	w.WriteString(`<input type="checkbox" name="`)
+	w.WriteString(name)
+	w.WriteString(`"`)
+	if checked {
+		w.WriteString(` checked`)
+	}
+	w.WriteString(`>`)
+}
+
+func DisplayTextarea(w *http.Response, name string, rows int, value string) {
+	w.WriteString(`<textarea name="`)
+	w.WriteString(name)
+	w.WriteString(`" rows="`)
+	w.WriteInt(rows)
+	w.WriteString(`" cols="80">`)
+	w.WriteHTMLString(value)
+	w.WriteString(`</textarea>`)
+}
+
 func DisplaySubmit(w *http.Response, l Language, name string, value string) {
 	w.WriteString(`<input type="submit" name="`)
 	w.WriteString(name)
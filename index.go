@@ -7,7 +7,8 @@ import (
 
 const (
 	MinURLLen = 1
-	MaxURLLen = 128
+
+	MaxRedirectsLimit = 1 << 31
 )
 
 func IndexPage(w *http.Response, r *http.Request, shortened string, ierr error) error {
@@ -16,6 +17,7 @@ func IndexPage(w *http.Response, r *http.Request, shortened string, ierr error)
 	const title = "URL shortener"
 
 	session, _ := GetSessionFromRequest(r)
+	var user *User
 
 	if err := r.ParseForm(); err != nil {
 		return http.ClientError(err)
@@ -46,15 +48,15 @@ func IndexPage(w *http.Response, r *http.Request, shortened string, ierr error)
 			w.WriteString(Ls(GL, "Sign up"))
 			w.WriteString(`</a>`)
 		} else {
-			var user User
-			if err := GetUserByID(session.ID, &user); err != nil {
+			user = new(User)
+			if err := GetUserByID(session.ID, user); err != nil {
 				return http.ServerError(err)
 			}
 
 			w.WriteString(`<a href="/user/`)
 			w.WriteID(session.ID)
 			w.WriteString(`">`)
-			DisplayUserTitle(w, &user)
+			DisplayUserTitle(w, user)
 			w.WriteString(`</a>`)
 
 			w.WriteString(` <a href="` + APIPrefix + `/user/signout">`)
@@ -75,6 +77,16 @@ func IndexPage(w *http.Response, r *http.Request, shortened string, ierr error)
 			w.WriteString(shortened)
 			w.WriteString(`</a>`)
 			w.WriteString(`</label>`)
+			if session != nil {
+				w.WriteString(` <a href="/url/`)
+				w.WriteString(shortened)
+				w.WriteString(`">`)
+				w.WriteString(Ls(GL, "Manage"))
+				w.WriteString(`</a>`)
+			}
+			w.WriteString(`<br><br>`)
+
+			DisplayURLQR(w, shortened)
 			w.WriteString(`<br><br>`)
 		}
 
@@ -83,10 +95,33 @@ func IndexPage(w *http.Response, r *http.Request, shortened string, ierr error)
 			w.WriteString(`<label>`)
 			w.WriteString(Ls(GL, "URL"))
 			w.WriteString(`: `)
-			DisplayConstraintInput(w, "text", MinURLLen, MaxURLLen, "URL", r.Form.Get("URL"), true)
+			DisplayConstraintInput(w, "text", MinURLLen, GetConfig().MaxURLLen, "URL", r.Form.Get("URL"), true)
+			w.WriteString(`</label>`)
+			w.WriteString(`<br><br>`)
+
+			w.WriteString(`<label>`)
+			w.WriteString(Ls(GL, "Password (optional)"))
+			w.WriteString(`: `)
+			DisplayConstraintInput(w, "password", GetConfig().MinPasswordLen, GetConfig().MaxPasswordLen, "Password", "", false)
 			w.WriteString(`</label>`)
 			w.WriteString(`<br><br>`)
 
+			w.WriteString(`<label>`)
+			w.WriteString(Ls(GL, "Maximum number of redirects (optional)"))
+			w.WriteString(`: `)
+			DisplayNumberInput(w, 0, MaxRedirectsLimit, "MaxRedirects", r.Form.Get("MaxRedirects"), false)
+			w.WriteString(`</label>`)
+			w.WriteString(`<br><br>`)
+
+			w.WriteString(`<label>`)
+			DisplayCheckbox(w, "OneTime", r.Form.Get("OneTime") != "")
+			w.WriteString(` `)
+			w.WriteString(Ls(GL, "One-time link"))
+			w.WriteString(`</label>`)
+			w.WriteString(`<br><br>`)
+
+			DisplayUTMInputs(w, r, user)
+
 			DisplaySubmit(w, GL, "", "Shorten!")
 		}
 		w.WriteString(`</form>`)
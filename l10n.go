@@ -1,6 +1,11 @@
 package main
 
 import (
+	"encoding/json"
+	"os"
+	"sync/atomic"
+
+	"github.com/anton2920/gofa/errors"
 	"github.com/anton2920/gofa/log"
 	"github.com/anton2920/gofa/trace"
 )
@@ -20,21 +25,571 @@ var Language2String = [...]string{
 	FR: "Français",
 }
 
+var Language2Code = [...]string{
+	EN: "en",
+	RU: "ru",
+	FR: "fr",
+}
+
 /* TODO(anton2920): remove '([A-Z]|[a-z])[a-z]+' duplicates. */
 var Localizations = map[string]*[XX]string{
+	"Active from": {
+		RU: "Aktivna s",
+	},
+	"Active until": {
+		RU: "Aktivna do",
+	},
+	"Add tag": {
+		RU: "Dobavit' teg",
+	},
+	"Append extra path and query of the short link to the target": {
+		RU: "Dobavlyat' k tseli dopolnitel'nyy put' i parametry korotkoy ssylki",
+	},
+	"Availability": {
+		RU: "Dostupnost'",
+	},
+	"Back": {
+		RU: "Nazad",
+	},
+	"Bulk shortening": {
+		RU: "Massovoe sokrashchenie",
+	},
+	"By clicks": {
+		RU: "Po perekhodam",
+	},
+	"By relevance": {
+		RU: "Po relevantnosti",
+	},
+	"CSV must have header with columns 'code', 'target', 'created' and 'clicks'. JSON must be an array of objects with the same keys. Common names used by other shorteners are recognized too": {
+		RU: "CSV dolzhen imet' zagolovok so stolbtsami 'code', 'target', 'created' i 'clicks'. JSON dolzhen byt' massivom ob'ektov s temi zhe klyuchami. Rasprostranennye nazvaniya, ispol'zuemye drugimi sokrashchatelyami, takzhe raspoznayutsya",
+	},
+	"Campaign": {
+		RU: "Kampaniya",
+	},
+	"Campaign (optional)": {
+		RU: "Kampaniya (neobyazatel'no)",
+	},
+	"Campaign presets": {
+		RU: "Shablony kampaniy",
+	},
+	"Campaigns": {
+		RU: "Kampanii",
+	},
+	"Change destination": {
+		RU: "Izmenit' tsel'",
+	},
+	"Changed by": {
+		RU: "Izmenil",
+	},
+	"Chat apps and social networks will show this card instead of the one of destination page. Leave all fields empty to use destination's card": {
+		RU: "Messendzhery i sotsial'nye seti budut pokazyvat' etu kartochku vmesto kartochki tselevoy stranitsy. Ostav'te vse polya pustymi, chtoby ispol'zovat' kartochku tseli",
+	},
+	"Check": {
+		RU: "Proverit'",
+	},
+	"Code": {
+		RU: "Kod",
+	},
+	"Code is preserved": {
+		RU: "Kod sokhranen",
+	},
+	"Coming soon": {
+		RU: "Skoro",
+	},
+	"Content": {
+		RU: "Soderzhanie",
+	},
+	"Continue": {
+		RU: "Prodolzhit'",
+	},
+	"Country": {
+		RU: "Strana",
+	},
+	"Country and language routing": {
+		RU: "Marshrutizatsiya po strane i yazyku",
+	},
+	"Created": {
+		RU: "Sozdana",
+	},
+	"Day": {
+		RU: "Den'",
+	},
+	"Default": {
+		RU: "Po umolchaniyu",
+	},
+	"Default fallback URL": {
+		RU: "Zapasnaya ssylka po umolchaniyu",
+	},
+	"Delete": {
+		RU: "Udalit'",
+	},
+	"Delete link": {
+		RU: "Udalit' ssylku",
+	},
+	"Deleted": {
+		RU: "Udalena",
+	},
+	"Description": {
+		RU: "Opisanie",
+	},
+	"Destination": {
+		RU: "Tsel'",
+	},
+	"Destination changes": {
+		RU: "Izmeneniya tseli",
+	},
+	"Destination of this link has been blocked by administrator": {
+		RU: "Mesto naznacheniya etoy ssylki zablokirovano administratorom",
+	},
+	"Device routing": {
+		RU: "Marshrutizatsiya po ustroystvam",
+	},
+	"Device rule fires": {
+		RU: "Srabatyvaet pravilo dlya ustroystva",
+	},
+	"Enter password to follow this link": {
+		RU: "Vvedite parol', chtoby pereyti po ssylke",
+	},
+	"Error": {
+		RU: "Oshibka",
+	},
+	"Examples": {
+		RU: "Primery",
+	},
+	"Exhausted": {
+		RU: "Ischerpana",
+	},
+	"Expired": {
+		RU: "Istekla",
+	},
+	"Expires at": {
+		RU: "Istekaet",
+	},
+	"Export": {
+		RU: "Eksport",
+	},
+	"Failed to fetch information about destination": {
+		RU: "Ne udalos' poluchit' informatsiyu o tseli",
+	},
+	"Fallback URL (optional)": {
+		RU: "Zapasnaya ssylka (neobyazatel'no)",
+	},
+	"Filter": {
+		RU: "Filtrovat'",
+	},
+	"Folder": {
+		RU: "Papka",
+	},
+	"Folders": {
+		RU: "Papki",
+	},
+	"Found": {
+		RU: "Naydeno",
+	},
+	"History": {
+		RU: "Istoriya",
+	},
+	"I understand that clients may keep using cached permanent redirect": {
+		RU: "Ya ponimayu, chto klienty mogut prodolzhit' ispol'zovat' zakeshirovannoe postoyannoe perenapravlenie",
+	},
+	"IP address": {
+		RU: "IP-adres",
+	},
+	"Image URL": {
+		RU: "URL izobrazheniya",
+	},
+	"Import": {
+		RU: "Importirovat'",
+	},
+	"Import links": {
+		RU: "Import ssylok",
+	},
+	"Information about destination has not been fetched yet": {
+		RU: "Informatsiya o tseli eshshyo ne poluchena",
+	},
+	"JSON object must contain array of links in 'links', 'urls' or 'data'": {
+		RU: "ob'ekt JSON dolzhen soderzhat' massiv ssylok v 'links', 'urls' ili 'data'",
+	},
+	"Keep both values": {
+		RU: "Ostavit' oba znacheniya",
+	},
+	"Keep target's value": {
+		RU: "Ostavit' znachenie tseli",
+	},
+	"Keep visitor on the same variant": {
+		RU: "Sokhranyat' variant dlya posetitelya",
+	},
+	"Link": {
+		RU: "Ssylka",
+	},
+	"Link is blocked": {
+		RU: "Ssylka zablokirovana",
+	},
+	"Link is exhausted": {
+		RU: "Ssylka ischerpana",
+	},
+	"Link is no longer active": {
+		RU: "Ssylka bol'she ne aktivna",
+	},
+	"Link is no longer available": {
+		RU: "Ssylka bol'she ne dostupna",
+	},
+	"Links": {
+		RU: "Ssylki",
+	},
+	"Links imported with their codes": {
+		RU: "Ssylok importirovano so svoimi kodami",
+	},
+	"Links to be imported with their codes": {
+		RU: "Ssylok budet importirovano so svoimi kodami",
+	},
+	"Manage": {
+		RU: "Upravlyat'",
+	},
+	"Maximum number of redirects (optional)": {
+		RU: "Maksimal'noe chislo perekhodov (neobyazatel'no)",
+	},
+	"Medium": {
+		RU: "Kanal",
+	},
+	"Message outside of schedule (optional)": {
+		RU: "Soobshshenie vne raspisaniya (neobyazatel'no)",
+	},
+	"New code": {
+		RU: "Novyy kod",
+	},
+	"New code will be assigned": {
+		RU: "Budet naznachen novyy kod",
+	},
+	"New target": {
+		RU: "Novaya tsel'",
+	},
+	"No rule fires, visitor follows the default target": {
+		RU: "Ni odno pravilo ne srabatyvaet, posetitel' perekhodit po osnovnoy ssylke",
+	},
+	"No rule fires, visitor is split between variants": {
+		RU: "Ni odno pravilo ne srabatyvaet, posetitel' raspredelyaetsya mezhdu variantami",
+	},
+	"Note": {
+		RU: "Zametka",
+	},
+	"Old target": {
+		RU: "Staraya tsel'",
+	},
+	"One link per line as 'target,alias,tags,expiry'. Only target is required, tags are comma-separated inside quotes, expiry is YYYY-MM-DD. Either all links are created or none of them, results are returned as CSV": {
+		RU: "Odna ssylka na stroku v vide 'tsel',psevdonim,tegi,srok'. Obyazatel'na tol'ko tsel', tegi perechislyayutsya cherez zapyatuyu v kavychkakh, srok v formate GGGG-MM-DD. Sozdayutsya libo vse ssylki, libo ni odnoy, rezul'taty vozvrashchayutsya v CSV",
+	},
+	"One-time link": {
+		RU: "Odnorazovaya ssylka",
+	},
+	"Organization": {
+		RU: "Organizatsiya",
+	},
+	"Outside of schedule": {
+		RU: "Vne raspisaniya",
+	},
+	"Passthrough": {
+		RU: "Peredacha puti",
+	},
+	"Password": {
+		RU: "Parol'",
+	},
+	"Password (optional)": {
+		RU: "Parol' (neobyazatel'no)",
+	},
+	"Platforms": {
+		RU: "Platformy",
+	},
+	"Preset": {
+		RU: "Shablon",
+	},
+	"Protected link": {
+		RU: "Zashshishshyonnaya ssylka",
+	},
+	"QR code scans": {
+		RU: "Skanirovaniya QR-koda",
+	},
+	"Redirect status": {
+		RU: "Kod perenapravleniya",
+	},
+	"Redirects": {
+		RU: "Perekhody",
+	},
+	"Redirects per day": {
+		RU: "Perekhody po dnyam",
+	},
+	"Remove tag": {
+		RU: "Udalit' teg",
+	},
+	"Result": {
+		RU: "Rezul'tat",
+	},
+	"Roll back": {
+		RU: "Otkatit'",
+	},
+	"Rule": {
+		RU: "Pravilo",
+	},
+	"Rules, evaluated in order": {
+		RU: "Pravila, proveryaemye po poryadku",
+	},
+	"Rules, one per line as 'platform URL'": {
+		RU: "Pravila, po odnomu na stroku v vide 'platforma ssylka'",
+	},
+	"Save": {
+		RU: "Sokhranit'",
+	},
+	"Save as preset": {
+		RU: "Sokhranit' kak shablon",
+	},
+	"Save schedule": {
+		RU: "Sokhranit' raspisanie",
+	},
+	"Schedule": {
+		RU: "Raspisanie",
+	},
+	"Search": {
+		RU: "Iskat'",
+	},
+	"Selected": {
+		RU: "Vybrannye",
+	},
 	"Shorten!": {
 		RU: "Sokraryt'",
 	},
+	"Simulate": {
+		RU: "Simulirovat'",
+	},
+	"Simulate visitor": {
+		RU: "Simulyatsiya posetitelya",
+	},
+	"Skipped because of errors": {
+		RU: "Propushcheno iz-za oshibok",
+	},
+	"Social preview": {
+		RU: "Predprosmotr v sotsial'nykh setyakh",
+	},
+	"Source": {
+		RU: "Istochnik",
+	},
+	"Split testing": {
+		RU: "Split-testirovanie",
+	},
+	"Tag": {
+		RU: "Teg",
+	},
+	"Tags": {
+		RU: "Tegi",
+	},
+	"Tags, comma-separated": {
+		RU: "Tegi cherez zapyatuyu",
+	},
+	"Target": {
+		RU: "Tsel'",
+	},
+	"Targets, one per line as 'weight URL'": {
+		RU: "Tseli, po odnoy na stroku v vide 'ves ssylka'",
+	},
+	"Term": {
+		RU: "Klyuchevoe slovo",
+	},
+	"This link becomes active on": {
+		RU: "Ssylka stanet aktivnoy",
+	},
+	"This link has expired or was deleted by its owner": {
+		RU: "Srok deystviya ssylki istyok ili ona byla udalena vladel'tsem",
+	},
+	"This link has reached its limit of redirects and can no longer be followed": {
+		RU: "Ssylka dostigla limita perekhodov i bol'she ne mozhet byt' ispol'zovana",
+	},
+	"Time": {
+		RU: "Vremya",
+	},
+	"Title": {
+		RU: "Zagolovok",
+	},
 	"URL": {
 		RU: "Ssylka",
 	},
+	"URL outside of schedule (optional)": {
+		RU: "Ssylka vne raspisaniya (neobyazatel'no)",
+	},
 	"URL shortener": {
 		RU: "Sokrashshyatel' ssylok",
 	},
+	"Unavailable": {
+		RU: "Nedostupna",
+	},
+	"Use request's value": {
+		RU: "Ispol'zovat' znachenie zaprosa",
+	},
+	"Variant": {
+		RU: "Variant",
+	},
+	"Visitors that match no rule follow the default target": {
+		RU: "Posetiteli, ne podkhodyashshie ni pod odno pravilo, perekhodyat po osnovnoy ssylke",
+	},
+	"Weight": {
+		RU: "Ves",
+	},
+	"When parameter is present in both": {
+		RU: "Esli parametr prisutstvuet v oboikh",
+	},
+	"With new codes": {
+		RU: "S novymi kodami",
+	},
+	"alias is already taken": {
+		RU: "psevdonim uzhe zanyat",
+	},
+	"alias is used on line %d already": {
+		RU: "psevdonim uzhe ispol'zovan v stroke %d",
+	},
+	"alias may contain only latin letters, digits, '-' and '_'": {
+		RU: "psevdonim mozhet soderzhat' tol'ko latinskie bukvy, tsifry, '-' i '_'",
+	},
+	"alias must not start with '%s'": {
+		RU: "psevdonim ne dolzhen nachinat'sya s '%s'",
+	},
+	"creation time must be Unix time, YYYY-MM-DD or RFC 3339": {
+		RU: "vremya sozdaniya dolzhno byt' vremenem Unix, GGGG-MM-DD ili RFC 3339",
+	},
+	"deleted": {
+		RU: "udalena",
+	},
+	"destination of the link is blocked": {
+		RU: "mesto naznacheniya ssylki zablokirovano",
+	},
+	"error correction level must be one of L, M, Q or H": {
+		RU: "uroven' korrektsii oshibok dolzhen byt' odnim iz L, M, Q ili H",
+	},
+	"expiry must be in format YYYY-MM-DD, YYYY-MM-DDTHH:MM or RFC 3339": {
+		RU: "srok dolzhen byt' v formate GGGG-MM-DD, GGGG-MM-DDTCHCH:MM ili RFC 3339",
+	},
+	"expiry must be in the future": {
+		RU: "srok dolzhen byt' v budushchem",
+	},
+	"failed to parse CSV: %v": {
+		RU: "ne udalos' razobrat' CSV: %v",
+	},
+	"failed to parse JSON: %v": {
+		RU: "ne udalos' razobrat' JSON: %v",
+	},
+	"file does not contain any links": {
+		RU: "fayl ne soderzhit ssylok",
+	},
+	"file must not exceed %d bytes": {
+		RU: "fayl ne dolzhen prevyshat' %d bayt",
+	},
+	"fires": {
+		RU: "srabatyvaet",
+	},
+	"format must be either 'csv' or 'json'": {
+		RU: "format dolzhen byt' 'csv' ili 'json'",
+	},
+	"header of CSV must contain column with target URL": {
+		RU: "zagolovok CSV dolzhen soderzhat' stolbets s tselevym URL",
+	},
+	"image URL must be absolute and start with http:// or https://": {
+		RU: "URL izobrazheniya dolzhen byt' absolyutnym i nachinat'sya s http:// ili https://",
+	},
+	"imported": {
+		RU: "importirovano",
+	},
+	"language": {
+		RU: "yazyk",
+	},
+	"length of the URL must be between %d and %d characters": {
+		RU: "dlina URL dolzhna byt' ot %d do %d simvolov",
+	},
+	"length of the alias must be between %d and %d characters": {
+		RU: "dlina psevdonima dolzhna byt' ot %d do %d simvolov",
+	},
+	"length of the description must not exceed %d characters": {
+		RU: "dlina opisaniya ne dolzhna prevyshat' %d simvolov",
+	},
+	"length of the image URL must not exceed %d characters": {
+		RU: "dlina URL izobrazheniya ne dolzhna prevyshat' %d simvolov",
+	},
+	"length of the title must not exceed %d characters": {
+		RU: "dlina zagolovka ne dolzhna prevyshat' %d simvolov",
+	},
+	"margin must be between %d and %d modules": {
+		RU: "otstup dolzhen byt' ot %d do %d moduley",
+	},
+	"number of clicks must be a non-negative integer": {
+		RU: "kolichestvo perekhodov dolzhno byt' neotritsatel'nym tselym chislom",
+	},
+	"number of rows must not exceed %d": {
+		RU: "kolichestvo strok ne dolzhno prevyshat' %d",
+	},
+	"platform": {
+		RU: "platforma",
+	},
+	"shortened URL does not exist": {
+		RU: "sokrashchennaya ssylka ne sushchestvuet",
+	},
+	"size must be between %d and %d pixels": {
+		RU: "razmer dolzhen byt' ot %d do %d pikseley",
+	},
+	"some of the aliases have been taken while links were created, try again": {
+		RU: "nekotorye psevdonimy byli zanyaty vo vremya sozdaniya ssylok, poprobuyte snova",
+	},
+	"some of the codes have been taken while links were imported, check again": {
+		RU: "nekotorye kody byli zanyaty vo vremya importa, prover'te snova",
+	},
+	"this account has been disabled": {
+		RU: "etot akkaunt otklyuchen",
+	},
+	"unknown": {
+		RU: "neizvestno",
+	},
+	"user with ID %d does not exist": {
+		RU: "pol'zovatel' s ID %d ne sushchestvuet",
+	},
 }
 
 var GL = EN
 
+/* LocalizationCatalog overrides and extends built-in Localizations without rebuilding. */
+type LocalizationCatalog map[string]*[XX]string
+
+var CurrentLocalizations atomic.Pointer[LocalizationCatalog]
+
+/* LoadLocalizationsFromFile reads JSON object which maps English strings to translations by language code, e.g. '{"Back": {"ru": "Nazad"}}'. */
+func LoadLocalizationsFromFile(filename string) (*LocalizationCatalog, error) {
+	defer trace.End(trace.Begin(""))
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries map[string]map[string]string
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+
+	catalog := make(LocalizationCatalog, len(entries))
+	for s, translations := range entries {
+		ls := new([XX]string)
+		for code, translation := range translations {
+			var l Language
+			for l = EN; l < XX; l++ {
+				if Language2Code[l] == code {
+					break
+				}
+			}
+			if l == XX {
+				return nil, errors.New("unknown language code " + code)
+			}
+			ls[l] = translation
+		}
+		catalog[s] = ls
+	}
+
+	return &catalog, nil
+}
+
 func (l Language) String() string {
 	defer trace.End(trace.Begin(""))
 
@@ -48,6 +603,12 @@ func Ls(l Language, s string) string {
 		return s
 	}
 
+	if catalog := CurrentLocalizations.Load(); catalog != nil {
+		if ls := (*catalog)[s]; (ls != nil) && (ls[l] != "") {
+			return ls[l]
+		}
+	}
+
 	ls := Localizations[s]
 	if (ls == nil) || (ls[l] == "") {
 
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/anton2920/gofa/net/tcp"
+	"github.com/anton2920/gofa/trace"
+)
+
+type ListenerKind int32
+
+const (
+	/* ListenerHTTP is served by workers directly. */
+	ListenerHTTP ListenerKind = iota
+
+	/* ListenerHTTPS is served by TLS terminator, which passes requests to workers through ListenerBackend. */
+	ListenerHTTPS
+
+	/* ListenerRedirect sends clients to HTTPS version of the page. */
+	ListenerRedirect
+
+	ListenerBackend
+
+	/* ListenerUnix is Unix domain socket served by workers directly, usually reached by local reverse proxy. */
+	ListenerUnix
+
+	ListenerKindCount
+)
+
+var ListenerKind2String = [...]string{
+	ListenerHTTP:     "http",
+	ListenerHTTPS:    "https",
+	ListenerRedirect: "redirect",
+	ListenerBackend:  "backend",
+	ListenerUnix:     "unix",
+}
+
+func (kind ListenerKind) String() string {
+	return ListenerKind2String[kind]
+}
+
+/* ParseListenerKind returns kind by its name. Unknown names mean ListenerHTTP. */
+func ParseListenerKind(s string) ListenerKind {
+	for kind := ListenerHTTP; kind < ListenerKindCount; kind++ {
+		if ListenerKind2String[kind] == s {
+			return kind
+		}
+	}
+	return ListenerHTTP
+}
+
+/* Listener is listening socket accepting connections for the server. */
+type Listener struct {
+	FD   int32
+	Name string
+	Kind ListenerKind
+}
+
+/* ListenFDsStart is the first descriptor passed by service manager, see sd_listen_fds(3). */
+const ListenFDsStart = 3
+
+/* ListenUnix creates Unix domain socket at 'path', replacing stale one left by previous run. */
+func ListenUnix(path string, mode os.FileMode, backlog int) (int32, error) {
+	defer trace.End(trace.Begin(""))
+
+	fd, err := syscall.Socket(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return -1, err
+	}
+
+	/* NOTE(anton2920): data files are locked before listening, so socket can't belong to another running server. */
+	if err := os.Remove(path); (err != nil) && (!os.IsNotExist(err)) {
+		syscall.Close(fd)
+		return -1, err
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrUnix{Name: path}); err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+	if err := syscall.Listen(fd, backlog); err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+
+	return int32(fd), nil
+}
+
+/* ListenerName describes socket 'fd' by its local address. */
+func ListenerName(fd int32) string {
+	sa, err := syscall.Getsockname(int(fd))
+	if err != nil {
+		return "descriptor " + strconv.Itoa(int(fd))
+	}
+
+	switch sa := sa.(type) {
+	default:
+		return "descriptor " + strconv.Itoa(int(fd))
+	case *syscall.SockaddrInet4:
+		return netip.AddrPortFrom(netip.AddrFrom4(sa.Addr), uint16(sa.Port)).String()
+	case *syscall.SockaddrInet6:
+		return netip.AddrPortFrom(netip.AddrFrom16(sa.Addr), uint16(sa.Port)).String()
+	case *syscall.SockaddrUnix:
+		return "unix:" + sa.Name
+	}
+}
+
+/* InheritListeners takes listening sockets of 'kinds' starting from ListenFDsStart. */
+func InheritListeners(kinds []ListenerKind) []Listener {
+	defer trace.End(trace.Begin(""))
+
+	ls := make([]Listener, len(kinds))
+	for i := 0; i < len(kinds); i++ {
+		fd := ListenFDsStart + i
+		syscall.CloseOnExec(fd)
+
+		/* NOTE(anton2920): accepting is edge-triggered, so it must never block. */
+		syscall.SetNonblock(fd, true)
+
+		ls[i] = Listener{FD: int32(fd), Name: ListenerName(int32(fd)), Kind: kinds[i]}
+	}
+	return ls
+}
+
+/*
+ * ActivatedListeners returns sockets passed by service manager through LISTEN_FDS, e.g. systemd socket units.
+ * Sockets named 'https' or 'redirect' in LISTEN_FDNAMES are of those kinds, other Unix domain sockets are ListenerUnix and the rest are plain HTTP.
+ */
+func ActivatedListeners() []Listener {
+	defer trace.End(trace.Begin(""))
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if (err != nil) || (pid != os.Getpid()) {
+		return nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if (err != nil) || (n <= 0) {
+		return nil
+	}
+
+	kinds := make([]ListenerKind, n)
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for i := 0; (i < n) && (i < len(names)); i++ {
+		kinds[i] = ParseListenerKind(names[i])
+	}
+
+	/* NOTE(anton2920): children must not think sockets are meant for them. */
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	ls := InheritListeners(kinds)
+	for i := 0; i < len(ls); i++ {
+		if (ls[i].Kind == ListenerHTTP) && (strings.HasPrefix(ls[i].Name, "unix:")) {
+			ls[i].Kind = ListenerUnix
+		}
+	}
+	return ls
+}
+
+/* OpenListeners creates listeners for configured TCP addresses and Unix domain socket. */
+func OpenListeners(cfg *Config) ([]Listener, error) {
+	defer trace.End(trace.Begin(""))
+
+	var ls []Listener
+
+	addresses := [...]struct {
+		Address string
+		Kind    ListenerKind
+	}{
+		{cfg.Address, ListenerHTTP},
+		{cfg.TLSAddress, ListenerHTTPS},
+		{cfg.RedirectAddress, ListenerRedirect},
+	}
+	for _, a := range addresses {
+		if a.Address == "" {
+			continue
+		}
+		l, err := tcp.Listen(a.Address, cfg.Backlog)
+		if err != nil {
+			CloseListeners(ls)
+			return nil, fmt.Errorf("failed to listen on %s: %v", a.Address, err)
+		}
+		ls = append(ls, Listener{FD: l, Name: a.Address, Kind: a.Kind})
+	}
+	if cfg.UnixSocket != "" {
+		l, err := ListenUnix(cfg.UnixSocket, cfg.UnixSocketMode, cfg.Backlog)
+		if err != nil {
+			CloseListeners(ls)
+			return nil, fmt.Errorf("failed to listen on %s: %v", cfg.UnixSocket, err)
+		}
+		ls = append(ls, Listener{FD: l, Name: "unix:" + cfg.UnixSocket, Kind: ListenerUnix})
+	}
+
+	return ls, nil
+}
+
+func CloseListeners(ls []Listener) {
+	for i := 0; i < len(ls); i++ {
+		syscall.Close(int(ls[i].FD))
+	}
+}
+
+/* ListenerKindByFD returns kind of listener 'fd' among 'ls'. */
+func ListenerKindByFD(ls []Listener, fd int32) ListenerKind {
+	for i := 0; i < len(ls); i++ {
+		if ls[i].FD == fd {
+			return ls[i].Kind
+		}
+	}
+	return ListenerHTTP
+}
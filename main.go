@@ -1,11 +1,13 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
-	"runtime"
 	"runtime/pprof"
+	"sync"
 	"sync/atomic"
+	stdtime "time"
 	"unsafe"
 
 	"github.com/anton2920/gofa/alloc"
@@ -15,7 +17,6 @@ import (
 	"github.com/anton2920/gofa/log"
 	"github.com/anton2920/gofa/net/http"
 	"github.com/anton2920/gofa/net/http/http1"
-	"github.com/anton2920/gofa/net/tcp"
 	"github.com/anton2920/gofa/strings"
 	"github.com/anton2920/gofa/syscall"
 	"github.com/anton2920/gofa/time"
@@ -36,12 +37,21 @@ var WorkingDirectory string
 
 var DateBufferPtr unsafe.Pointer
 
-func HandlePageRequest(w *http.Response, r *http.Request, path string) error {
+func HandlePageRequest(w *http.Response, r *http.Request, path string, addr string) error {
 	switch {
 	default:
-		return URLRedirectHandler(w, r, path[1:])
+		if code, format, ok := SplitQRPath(path[1:]); ok {
+			return URLQRHandler(w, r, code, format)
+		}
+		return URLRedirectHandler(w, r, path[1:], addr)
 	case path == "/":
 		return IndexPage(w, r, "", nil)
+	case path == "/url/bulk":
+		return URLBulkPage(w, r, nil)
+	case path == "/url/import":
+		return URLImportPage(w, r, nil, true, nil)
+	case strings.StartsWith(path, "/url/"):
+		return URLPage(w, r, path[len("/url/"):], nil)
 	case strings.StartsWith(path, "/user"):
 		switch path[len("/user"):] {
 		default:
@@ -60,11 +70,51 @@ func HandleAPIRequest(w *http.Response, r *http.Request, path string) error {
 	switch {
 	case strings.StartsWith(path, "/url"):
 		switch path[len("/url"):] {
+		case "/bulk":
+			return URLBulkHandler(w, r)
 		case "/create":
 			return URLCreateHandler(w, r)
+		case "/delete":
+			return URLDeleteHandler(w, r)
+		case "/devices":
+			return URLDeviceRulesHandler(w, r)
+		case "/export":
+			return URLExportHandler(w, r)
+		case "/fallback":
+			return URLFallbackHandler(w, r)
+		case "/geo":
+			return URLGeoRulesHandler(w, r)
+		case "/import":
+			return URLImportHandler(w, r)
+		case "/list":
+			return URLListHandler(w, r)
+		case "/organize":
+			return URLOrganizeHandler(w, r)
+		case "/passthrough":
+			return URLPassthroughHandler(w, r)
+		case "/preview":
+			return URLPreviewHandler(w, r)
+		case "/retarget":
+			return URLRetargetHandler(w, r)
+		case "/rollback":
+			return URLRollbackHandler(w, r)
+		case "/schedule":
+			return URLScheduleHandler(w, r)
+		case "/search":
+			return URLSearchHandler(w, r)
+		case "/status":
+			return URLStatusHandler(w, r)
+		case "/tag":
+			return URLBulkTagHandler(w, r)
+		case "/targets":
+			return URLTargetsHandler(w, r)
 		}
 	case strings.StartsWith(path, "/user"):
 		switch path[len("/user"):] {
+		case "/fallback":
+			return UserFallbackHandler(w, r)
+		case "/preset/delete":
+			return UserPresetDeleteHandler(w, r)
 		case "/signin":
 			return UserSigninHandler(w, r)
 		case "/signout":
@@ -82,7 +132,7 @@ func HandleFSRequest(w *http.Response, r *http.Request, path string) error {
 	return http.NotFound(Ls(GL, "requested file does not exist"))
 }
 
-func RouterFunc(w *http.Response, r *http.Request) (err error) {
+func RouterFunc(w *http.Response, r *http.Request, addr string) (err error) {
 	defer trace.End(trace.Begin(""))
 
 	defer func() {
@@ -94,7 +144,7 @@ func RouterFunc(w *http.Response, r *http.Request) (err error) {
 	path := r.URL.Path
 	switch {
 	default:
-		return HandlePageRequest(w, r, path)
+		return HandlePageRequest(w, r, path, addr)
 	case strings.StartsWith(path, APIPrefix):
 		return HandleAPIRequest(w, r, path[len(APIPrefix):])
 	case strings.StartsWith(path, FSPrefix):
@@ -114,11 +164,13 @@ func Router(ctx *http.Context, ws []http.Response, rs []http.Request) {
 		w := &ws[i]
 		r := &rs[i]
 
+		addr := ClientAddress(ctx, r)
+
 		start := intel.RDTSC()
 		w.Headers.Set("Content-Type", `text/html; charset="UTF-8"`)
 		level := log.LevelDebug
 
-		err := RouterFunc(w, r)
+		err := RouterFunc(w, r, addr)
 		if err != nil {
 			ErrorPageHandler(w, r, GL, err)
 			if (w.StatusCode >= http.StatusBadRequest) && (w.StatusCode < http.StatusInternalServerError) {
@@ -126,18 +178,14 @@ func Router(ctx *http.Context, ws []http.Response, rs []http.Request) {
 			} else {
 				level = log.LevelError
 			}
-			http.CloseAfterWrite(ctx)
+			CloseConnectionAfterWrite(ctx)
 		}
 
-		if r.Headers.Get("Connection") == "close" {
+		if (r.Headers.Get("Connection") == "close") || (ShuttingDown.Load()) {
 			w.Headers.Set("Connection", "close")
-			http.CloseAfterWrite(ctx)
+			CloseConnectionAfterWrite(ctx)
 		}
 
-		addr := ctx.ClientAddress
-		if r.Headers.Has("X-Forwarded-For") {
-			addr = r.Headers.Get("X-Forwarded-For")
-		}
 		end := intel.RDTSC()
 		elapsed := end - start
 
@@ -157,7 +205,78 @@ func UpdateDateHeader(now int) {
 	atomic.StorePointer(&DateBufferPtr, unsafe.Pointer(&buffer[0]))
 }
 
-func ServerWorker(q *event.Queue) {
+/* ShuttingDown is set when server stops accepting connections. Workers then answer with 'Connection: close', and once their clients are quiet they close idle keep-alive connections and exit. */
+var ShuttingDown atomic.Bool
+
+/* Connection describes accepted connection: listener it came from and worker serving it. */
+type Connection struct {
+	Listener ListenerKind
+	Worker   int
+	Closed   bool
+}
+
+/* NOTE(anton2920): contexts are reused from pool, so entry is simply overwritten when context gets new connection. */
+var (
+	Connections     = make(map[*http.Context]Connection)
+	ConnectionsLock sync.RWMutex
+)
+
+func AddConnection(ctx *http.Context, conn Connection) {
+	ConnectionsLock.Lock()
+	Connections[ctx] = conn
+	ConnectionsLock.Unlock()
+}
+
+func GetConnection(ctx *http.Context) Connection {
+	ConnectionsLock.RLock()
+	defer ConnectionsLock.RUnlock()
+
+	return Connections[ctx]
+}
+
+func MarkConnectionClosed(ctx *http.Context) {
+	ConnectionsLock.Lock()
+	if conn, ok := Connections[ctx]; ok {
+		conn.Closed = true
+		Connections[ctx] = conn
+	}
+	ConnectionsLock.Unlock()
+}
+
+func CloseConnection(ctx *http.Context) {
+	MarkConnectionClosed(ctx)
+	http.Close(ctx)
+}
+
+func CloseConnectionAfterWrite(ctx *http.Context) {
+	MarkConnectionClosed(ctx)
+	http.CloseAfterWrite(ctx)
+}
+
+/* CloseWorkerConnections closes connections still open on 'worker'. */
+func CloseWorkerConnections(worker int) {
+	defer trace.End(trace.Begin(""))
+
+	var ctxs []*http.Context
+
+	ConnectionsLock.Lock()
+	for ctx, conn := range Connections {
+		if (conn.Worker == worker) && (!conn.Closed) {
+			conn.Closed = true
+			Connections[ctx] = conn
+			ctxs = append(ctxs, ctx)
+		}
+	}
+	ConnectionsLock.Unlock()
+
+	for _, ctx := range ctxs {
+		http.Close(ctx)
+	}
+}
+
+func ServerWorker(id int, q *event.Queue, wg *sync.WaitGroup) {
+	defer wg.Done()
+
 	events := make([]event.Event, 64)
 
 	const batchSize = 32
@@ -169,6 +288,7 @@ func ServerWorker(q *event.Queue) {
 		return q.GetEvents(events)
 	}
 
+	var active bool
 	for {
 		n, err := getEvents(q, events)
 		if err != nil {
@@ -179,6 +299,17 @@ func ServerWorker(q *event.Queue) {
 
 		for i := 0; i < n; i++ {
 			e := &events[i]
+			if e.Type == event.Timer {
+				/* NOTE(anton2920): timer is added only on shutdown, a whole period without events means remaining connections are idle. */
+				if !active {
+					CloseWorkerConnections(id)
+					return
+				}
+				active = false
+				continue
+			}
+			active = true
+
 			if errno := e.Error(); errno != 0 {
 				log.Errorf("Event for %v returned code %d (%s)", e.Identifier, errno, errno)
 				continue
@@ -189,7 +320,7 @@ func ServerWorker(q *event.Queue) {
 				continue
 			}
 			if e.EndOfFile() {
-				http.Close(ctx)
+				CloseConnection(ctx)
 				continue
 			}
 
@@ -201,11 +332,11 @@ func ServerWorker(q *event.Queue) {
 					if err != nil {
 						if err == http.NoSpaceLeft {
 							http1.FillError(ctx, err, dateBuffer)
-							http.CloseAfterWrite(ctx)
+							CloseConnectionAfterWrite(ctx)
 							break
 						}
 						log.Errorf("Failed to read data from client: %v", err)
-						http.Close(ctx)
+						CloseConnection(ctx)
 						break
 					}
 					read += n
@@ -214,7 +345,7 @@ func ServerWorker(q *event.Queue) {
 						n, err = http1.ParseRequestsUnsafe(ctx, rs)
 						if err != nil {
 							http1.FillError(ctx, err, dateBuffer)
-							http.CloseAfterWrite(ctx)
+							CloseConnectionAfterWrite(ctx)
 							break
 						}
 						Router(ctx, ws[:n], rs[:n])
@@ -226,7 +357,7 @@ func ServerWorker(q *event.Queue) {
 				_, err = http.Write(ctx)
 				if err != nil {
 					log.Errorf("Failed to write data to client: %v", err)
-					http.Close(ctx)
+					CloseConnection(ctx)
 					continue
 				}
 			}
@@ -234,16 +365,52 @@ func ServerWorker(q *event.Queue) {
 	}
 }
 
+/* DrainServer waits for workers and pending metadata updates no longer than 'timeout' and reports whether they are done. */
+func DrainServer(workers *sync.WaitGroup, timeout stdtime.Duration) bool {
+	defer trace.End(trace.Begin(""))
+
+	done := make(chan struct{})
+	go func() {
+		workers.Wait()
+		MetadataUpdates.Wait()
+		close(done)
+	}()
+
+	expired := stdtime.After(timeout)
+	ticker := stdtime.NewTicker(stdtime.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return true
+		case <-expired:
+			return false
+		case <-ticker.C:
+			/* NOTE(anton2920): main loop no longer updates it, but workers are still writing responses. */
+			UpdateDateHeader(time.Unix())
+		}
+	}
+}
+
 func main() {
 	var err error
 
-	nworkers := min(runtime.GOMAXPROCS(0)/2, runtime.NumCPU())
+	args := os.Args[1:]
+	if len(args) > 0 {
+		if args[0] == "serve" {
+			args = args[1:]
+		} else if !strings.StartsWith(args[0], "-") {
+			os.Exit(RunCommand(args))
+		}
+	}
+
+	nworkers := 0
 	switch BuildMode {
 	default:
 		BuildMode = "Release"
 	case "Debug":
 		Debug = true
-		log.SetLevel(log.LevelDebug)
 	case "Profiling":
 		f, err := os.Create(fmt.Sprintf("masters-cpu.pprof"))
 		if err != nil {
@@ -259,20 +426,90 @@ func main() {
 		trace.BeginProfile()
 		defer trace.EndAndPrintProfile()
 	}
-	log.Infof("Starting Shortener in %q mode...", BuildMode)
 
-	if err := RestoreSessionsFromFile(SessionsFile); err != nil {
-		log.Warnf("Failed to restore sessions from file: %v", err)
+	cfg, printConfig, err := LoadConfig(args)
+	if err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(0)
+		}
+		log.Fatalf("Failed to load configuration: %v", err)
 	}
+	if printConfig {
+		WriteConfig(os.Stdout, cfg)
+		return
+	}
+	res, err := LoadConfigResources(cfg)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	ApplyConfig(cfg, &res)
+	if nworkers == 0 {
+		nworkers = NumWorkers(cfg)
+	}
+
+	log.Infof("Starting Shortener in %q mode...", BuildMode)
 
-	const address = "0.0.0.0:7075"
-	l, err := tcp.Listen(address, 128)
+	var lock *os.File
+	ls, upgrade := UpgradeListeners()
+	if upgrade {
+		log.Infof("Taking over from previous process...")
+		if err := CheckDataFiles(); err != nil {
+			log.Fatalf("Failed to read data files, previous process continues to serve: %v", err)
+		}
+		NotifyUpgradeReady(len(ls))
+		lock, err = WaitUpgradeDataFiles(cfg)
+	} else {
+		/* NOTE(anton2920): commands reading data files hold shared lock for a short time, so they are waited for instead of failing to start. */
+		lock, err = WaitDataFiles(DataLockTimeout)
+	}
 	if err != nil {
-		log.Fatalf("Failed to listen on port: %v", err)
+		log.Fatalf("Failed to lock data files: %v", err)
+	}
+	defer UnlockDataFiles(lock)
+
+	if upgrade {
+		if err := TakeUpgradeHandoff(); err != nil {
+			log.Fatalf("Refusing to start with data files previous process has not stored: %v", err)
+		}
+	}
+	if err := RestoreDataFromFiles(); err != nil {
+		log.Fatalf("Failed to restore data from files: %v", err)
+	}
+	if URLAccessKey, err = LoadURLAccessKey(DataFilePath(URLAccessKeyFile)); err != nil {
+		log.Fatalf("Failed to load key of access cookies: %v", err)
+	}
+
+	if !upgrade {
+		ls = ActivatedListeners()
+		opened, err := OpenListeners(cfg)
+		if err != nil {
+			log.Fatalf("Failed to open listeners: %v", err)
+		}
+		ls = append(ls, opened...)
+	}
+	if len(ls) == 0 {
+		log.Fatalf("No listeners are configured")
 	}
-	defer syscall.Close(l)
 
-	log.Infof("Listening on %s...", address)
+	for i := 0; i < len(ls); i++ {
+		if ls[i].Kind == ListenerHTTPS {
+			if CurrentCertificates.Load() == nil {
+				log.Fatalf("HTTPS listener %s requires TLS certificates", ls[i].Name)
+			}
+			backend := DataFilePath(TLSBackendSocket)
+			fd, err := ListenUnix(backend, 0600, cfg.Backlog)
+			if err != nil {
+				log.Fatalf("Failed to listen on backend socket for TLS: %v", err)
+			}
+			ls = append(ls, Listener{FD: fd, Name: "unix:" + backend, Kind: ListenerBackend})
+			go WatchCertificates()
+			break
+		}
+	}
+	tlsServers, err := StartTLSServers(ls, DataFilePath(TLSBackendSocket))
+	if err != nil {
+		log.Fatalf("Failed to start TLS servers: %v", err)
+	}
 
 	q, err := event.NewQueue()
 	if err != nil {
@@ -280,20 +517,27 @@ func main() {
 	}
 	defer q.Close()
 
-	_ = q.AddSocket(l, event.RequestRead, event.TriggerEdge, nil)
+	for i := 0; i < len(ls); i++ {
+		if (ls[i].Kind == ListenerHTTP) || (ls[i].Kind == ListenerBackend) || (ls[i].Kind == ListenerUnix) {
+			_ = q.AddSocket(ls[i].FD, event.RequestRead, event.TriggerEdge, nil)
+		}
+		log.Infof("Listening on %s (%s)...", ls[i].Name, ls[i].Kind)
+	}
 	_ = q.AddTimer(1, 1, event.Seconds, nil)
 
-	_ = syscall.IgnoreSignals(syscall.SIGINT, syscall.SIGTERM)
-	_ = q.AddSignals(syscall.SIGINT, syscall.SIGTERM)
+	_ = syscall.IgnoreSignals(syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR2)
+	_ = q.AddSignals(syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR2)
 
-	ctxPool := alloc.NewSyncPool[http.Context](nworkers * 512)
+	ctxPool := alloc.NewSyncPool[http.Context](nworkers * cfg.ContextsPerWorker)
 	qs := make([]*event.Queue, nworkers)
+	var workers sync.WaitGroup
 	for i := 0; i < nworkers; i++ {
 		qs[i], err = event.NewQueue()
 		if err != nil {
 			log.Fatalf("Failed to create new client queue: %v", err)
 		}
-		go ServerWorker(qs[i])
+		workers.Add(1)
+		go ServerWorker(i, qs[i], &workers)
 	}
 	now := time.Unix()
 	UpdateDateHeader(now)
@@ -301,6 +545,8 @@ func main() {
 	events := make([]event.Event, 64)
 	var counter int
 
+	/* NOTE(anton2920): non-zero 'upgradePID' is new process data is handed over to on exit. */
+	var upgradePID int
 	var quit bool
 	for !quit {
 		n, err := q.GetEvents(events)
@@ -316,7 +562,7 @@ func main() {
 			default:
 				log.Panicf("Unhandled event: %#v", e)
 			case event.Read:
-				ctx, err := http.Accept(l, &ctxPool, 1024)
+				ctx, err := http.Accept(int32(e.Identifier), &ctxPool, 1024)
 				if err != nil {
 					if err == http.TooManyClients {
 						http1.FillError(ctx, err, GetDateHeader())
@@ -326,20 +572,70 @@ func main() {
 					log.Errorf("Failed to accept new HTTP connection: %v", err)
 					continue
 				}
-				_ = qs[counter%len(qs)].AddHTTP(ctx, event.RequestRead, event.TriggerEdge)
+				worker := counter % len(qs)
+				AddConnection(ctx, Connection{Listener: ListenerKindByFD(ls, int32(e.Identifier)), Worker: worker})
+				_ = qs[worker].AddHTTP(ctx, event.RequestRead, event.TriggerEdge)
 				counter++
 			case event.Timer:
 				now += e.Data
 				UpdateDateHeader(now)
 			case event.Signal:
-				log.Infof("Received signal %d, exitting...", e.Identifier)
-				quit = true
-				break
+				switch syscall.Signal(e.Identifier) {
+				default:
+					log.Infof("Received signal %d, exitting...", e.Identifier)
+					quit = true
+				case syscall.SIGHUP:
+					log.Infof("Received SIGHUP, reloading configuration...")
+					if err := ReloadConfig(args); err != nil {
+						log.Errorf("Failed to reload configuration, keeping previous one: %v", err)
+					} else {
+						log.Infof("Configuration is reloaded")
+					}
+				case syscall.SIGUSR2:
+					log.Infof("Received SIGUSR2, starting new binary...")
+					if upgradePID, err = StartUpgrade(ls, args); err != nil {
+						log.Errorf("Failed to start new binary, continuing to serve: %v", err)
+					} else {
+						log.Infof("New binary is ready to take over, exitting...")
+						quit = true
+					}
+				}
 			}
 		}
 	}
 
-	if err := StoreSessionsToFile(SessionsFile); err != nil {
-		log.Warnf("Failed to store sessions to file: %v", err)
+	/* NOTE(anton2920): stop accepting first, so every request that got in is answered before data is stored. */
+	ShuttingDown.Store(true)
+	deadline := stdtime.Now().Add(GetConfig().ShutdownTimeout)
+	log.Infof("Waiting for connections to finish...")
+	for i := 0; i < len(ls); i++ {
+		if ls[i].Kind != ListenerBackend {
+			syscall.Close(ls[i].FD)
+		}
+	}
+
+	/* NOTE(anton2920): requests coming over TLS are served by workers, so backend stays open until they are done. */
+	ShutdownTLSServers(tlsServers, deadline)
+	for i := 0; i < len(ls); i++ {
+		if ls[i].Kind == ListenerBackend {
+			syscall.Close(ls[i].FD)
+		}
+	}
+
+	for i := 0; i < len(qs); i++ {
+		_ = qs[i].AddTimer(1, 1, event.Seconds, nil)
+	}
+	if !DrainServer(&workers, stdtime.Until(deadline)) {
+		log.Warnf("Shutdown timeout has expired, dropping remaining connections")
+	}
+
+	if err := StoreDataToFiles(); err != nil {
+		/* NOTE(anton2920): new process, if any, refuses to start without handoff, so it does not serve outdated data. */
+		log.Fatalf("Failed to store data to files: %v", err)
+	}
+	if upgradePID != 0 {
+		if err := StoreUpgradeHandoff(upgradePID); err != nil {
+			log.Fatalf("Failed to hand data files over to new process: %v", err)
+		}
 	}
 }
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"html"
+	"io"
+	"mime"
+	"net"
+	stdhttp "net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	stdtime "time"
+
+	"github.com/anton2920/gofa/errors"
+	"github.com/anton2920/gofa/log"
+	"github.com/anton2920/gofa/net/http"
+	"github.com/anton2920/gofa/time"
+	"github.com/anton2920/gofa/trace"
+)
+
+/* URLMetadata is what destination page says about itself. */
+type URLMetadata struct {
+	Title       string
+	Description string
+	ImageURL    string
+
+	FetchedAt int64
+	Error     string
+}
+
+const (
+	MetadataTimeout      = 5 * stdtime.Second
+	MetadataMaxBodySize  = 256 * 1024
+	MetadataMaxRedirects = 3
+	MetadataMaxFetchers  = 4
+
+	MaxMetadataTitleLen       = 256
+	MaxMetadataDescriptionLen = 1024
+)
+
+var MetadataFetchers = make(chan struct{}, MetadataMaxFetchers)
+
+/* MetadataUpdates tracks updates in progress, so they are not lost on shutdown. */
+var MetadataUpdates sync.WaitGroup
+
+var ForbiddenAddress = errors.New("destination address is not allowed")
+
+/* MetadataForbiddenPrefixes are not private according to net/netip, but lead to carrier-grade NAT or reserved networks, or embed IPv4 addresses like NAT64, 6to4 and Teredo do. */
+var MetadataForbiddenPrefixes = [...]netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("::/96"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+	netip.MustParsePrefix("2001::/32"),
+	netip.MustParsePrefix("2002::/16"),
+}
+
+/* MetadataAddressAllowed protects against using fetcher to reach internal services. */
+func MetadataAddressAllowed(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if (!addr.IsValid()) || (!addr.IsGlobalUnicast()) || (addr.IsPrivate()) || (addr.IsLoopback()) || (addr.IsLinkLocalUnicast()) {
+		return false
+	}
+	for _, prefix := range MetadataForbiddenPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+var MetadataClient = &stdhttp.Client{
+	Timeout: MetadataTimeout,
+	Transport: &stdhttp.Transport{
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: MetadataTimeout,
+
+			/* NOTE(anton2920): checking address after DNS resolution also covers redirects and DNS rebinding. */
+			Control: func(network string, address string, _ syscall.RawConn) error {
+				/* NOTE(anton2920): allowing private networks is only useful for testing. */
+				if GetConfig().MetadataAllowPrivate {
+					return nil
+				}
+				ap, err := netip.ParseAddrPort(address)
+				if err != nil {
+					return err
+				}
+				if !MetadataAddressAllowed(ap.Addr()) {
+					return ForbiddenAddress
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout:    MetadataTimeout,
+		ResponseHeaderTimeout:  MetadataTimeout,
+		MaxResponseHeaderBytes: 16 * 1024,
+		DisableKeepAlives:      true,
+	},
+	CheckRedirect: func(r *stdhttp.Request, via []*stdhttp.Request) error {
+		if len(via) >= MetadataMaxRedirects {
+			return errors.New("too many redirects")
+		}
+		if (r.URL.Scheme != "http") && (r.URL.Scheme != "https") {
+			return errors.New("unsupported scheme " + r.URL.Scheme)
+		}
+		return nil
+	},
+}
+
+/* HTMLAttribute returns value of attribute 'name' from contents of a tag, like 'meta property="og:title" content="..."'. */
+func HTMLAttribute(tag string, name string) (string, bool) {
+	for len(tag) > 0 {
+		tag = strings.TrimLeft(tag, " \t\r\n/")
+
+		i := strings.IndexAny(tag, "= \t\r\n>")
+		if i == -1 {
+			return "", false
+		}
+		key := tag[:i]
+		tag = strings.TrimLeft(tag[i:], " \t\r\n")
+
+		var value string
+		if strings.HasPrefix(tag, "=") {
+			tag = strings.TrimLeft(tag[1:], " \t\r\n")
+			if (len(tag) > 0) && ((tag[0] == '"') || (tag[0] == '\'')) {
+				quote := tag[0]
+				end := strings.IndexByte(tag[1:], quote)
+				if end == -1 {
+					return "", false
+				}
+				value = tag[1 : end+1]
+				tag = tag[end+2:]
+			} else {
+				end := strings.IndexAny(tag, " \t\r\n>")
+				if end == -1 {
+					end = len(tag)
+				}
+				value = tag[:end]
+				tag = tag[end:]
+			}
+		}
+
+		if strings.EqualFold(key, name) {
+			return html.UnescapeString(value), true
+		}
+	}
+	return "", false
+}
+
+/* ParseHTMLMetadata extracts title, description and Open Graph image from the beginning of HTML document. */
+func ParseHTMLMetadata(document string, base *url.URL) URLMetadata {
+	defer trace.End(trace.Begin(""))
+
+	var md URLMetadata
+	var ogTitle, ogDescription, description string
+
+	lower := strings.ToLower(document)
+	for pos := 0; ; {
+		i := strings.IndexByte(lower[pos:], '<')
+		if i == -1 {
+			break
+		}
+		pos += i + 1
+
+		end := strings.IndexByte(lower[pos:], '>')
+		if end == -1 {
+			break
+		}
+		tag := document[pos : pos+end]
+		lowerTag := lower[pos : pos+end]
+		pos += end + 1
+
+		switch {
+		case (strings.HasPrefix(lowerTag, "title")) && (md.Title == ""):
+			if close := strings.Index(lower[pos:], "</title"); close != -1 {
+				md.Title = strings.TrimSpace(html.UnescapeString(document[pos : pos+close]))
+			}
+		case strings.HasPrefix(lowerTag, "meta "):
+			content, ok := HTMLAttribute(tag[len("meta "):], "content")
+			if !ok {
+				continue
+			}
+
+			property, _ := HTMLAttribute(tag[len("meta "):], "property")
+			if property == "" {
+				property, _ = HTMLAttribute(tag[len("meta "):], "name")
+			}
+
+			switch strings.ToLower(property) {
+			case "og:title", "twitter:title":
+				if ogTitle == "" {
+					ogTitle = content
+				}
+			case "og:description", "twitter:description":
+				if ogDescription == "" {
+					ogDescription = content
+				}
+			case "description":
+				description = content
+			case "og:image", "og:image:url", "twitter:image":
+				if md.ImageURL == "" {
+					if image, err := base.Parse(content); (err == nil) && ((image.Scheme == "http") || (image.Scheme == "https")) {
+						md.ImageURL = image.String()
+					}
+				}
+			}
+		case strings.HasPrefix(lowerTag, "/head"), strings.HasPrefix(lowerTag, "body"):
+			pos = len(lower)
+		}
+	}
+
+	if ogTitle != "" {
+		md.Title = ogTitle
+	}
+	md.Description = description
+	if ogDescription != "" {
+		md.Description = ogDescription
+	}
+
+	md.Title = TruncateString(strings.TrimSpace(md.Title), MaxMetadataTitleLen)
+	md.Description = TruncateString(strings.TrimSpace(md.Description), MaxMetadataDescriptionLen)
+	return md
+}
+
+/* FetchMetadata downloads beginning of the page at 'rawURL' and parses its metadata. */
+func FetchMetadata(ctx context.Context, rawURL string) (URLMetadata, error) {
+	defer trace.End(trace.Begin(""))
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return URLMetadata{}, err
+	}
+	if (u.Scheme != "http") && (u.Scheme != "https") {
+		return URLMetadata{}, errors.New("unsupported scheme " + u.Scheme)
+	}
+
+	req, err := stdhttp.NewRequestWithContext(ctx, stdhttp.MethodGet, u.String(), nil)
+	if err != nil {
+		return URLMetadata{}, err
+	}
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("User-Agent", "Shortener metadata fetcher")
+
+	resp, err := MetadataClient.Do(req)
+	if err != nil {
+		return URLMetadata{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != stdhttp.StatusOK {
+		return URLMetadata{}, errors.New("destination returned " + resp.Status)
+	}
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); (err != nil) || ((mediaType != "text/html") && (mediaType != "application/xhtml+xml")) {
+		return URLMetadata{}, errors.New("destination is not an HTML page")
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, MetadataMaxBodySize))
+	if err != nil {
+		return URLMetadata{}, err
+	}
+
+	return ParseHTMLMetadata(string(body), resp.Request.URL), nil
+}
+
+/* UpdateURLMetadata fetches metadata of the destination of link 'path' and stores it on the link. */
+func UpdateURLMetadata(path string, rawURL string) {
+	defer trace.End(trace.Begin(""))
+
+	MetadataFetchers <- struct{}{}
+	defer func() { <-MetadataFetchers }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), MetadataTimeout)
+	defer cancel()
+
+	md, err := FetchMetadata(ctx, rawURL)
+	if err != nil {
+		log.Logf(log.LevelDebug, "Failed to fetch metadata of %q: %v", rawURL, err)
+		md.Error = err.Error()
+	}
+	md.FetchedAt = int64(time.Unix())
+
+	if err := UpdateURL(path, func(u *URL) error {
+		/* NOTE(anton2920): destination might have changed while we were fetching. */
+		if u.RawURL == rawURL {
+			u.Metadata = md
+		}
+		return nil
+	}); err != nil {
+		log.Warnf("Failed to store metadata of %q: %v", path, err)
+	}
+}
+
+/* FetchURLMetadataAsync schedules update of link's metadata without blocking the request. */
+func FetchURLMetadataAsync(path string, rawURL string) {
+	if GetConfig().MetadataFetch {
+		MetadataUpdates.Add(1)
+		go func() {
+			defer MetadataUpdates.Done()
+			UpdateURLMetadata(path, rawURL)
+		}()
+	}
+}
+
+/* FetchURLsMetadataAsync schedules updates of metadata of many links at once. They are done by no more than MetadataMaxFetchers goroutines and stop on shutdown. */
+func FetchURLsMetadataAsync(paths []string, urls []URL) {
+	if !GetConfig().MetadataFetch {
+		return
+	}
+
+	var next atomic.Int64
+	for i := 0; (i < MetadataMaxFetchers) && (i < len(paths)); i++ {
+		MetadataUpdates.Add(1)
+		go func() {
+			defer MetadataUpdates.Done()
+			for !ShuttingDown.Load() {
+				j := int(next.Add(1) - 1)
+				if j >= len(paths) {
+					break
+				}
+				UpdateURLMetadata(paths[j], urls[j].RawURL)
+			}
+		}()
+	}
+}
+
+func DisplayURLMetadata(w *http.Response, url *URL) {
+	md := &url.Metadata
+
+	if md.FetchedAt == 0 {
+		w.WriteString(`<p>`)
+		w.WriteString(Ls(GL, "Information about destination has not been fetched yet"))
+		w.WriteString(`.</p>`)
+		return
+	}
+
+	if md.Error != "" {
+		w.WriteString(`<p>`)
+		w.WriteString(Ls(GL, "Failed to fetch information about destination"))
+		w.WriteString(`: `)
+		w.WriteHTMLString(md.Error)
+		w.WriteString(`.</p>`)
+		return
+	}
+
+	if md.ImageURL != "" {
+		w.WriteString(`<img src="`)
+		w.WriteHTMLString(md.ImageURL)
+		w.WriteString(`" alt="" height="64" referrerpolicy="no-referrer">`)
+	}
+	if md.Title != "" {
+		w.WriteString(`<p><b>`)
+		w.WriteHTMLString(md.Title)
+		w.WriteString(`</b></p>`)
+	}
+	if md.Description != "" {
+		w.WriteString(`<p>`)
+		w.WriteHTMLString(md.Description)
+		w.WriteString(`</p>`)
+	}
+}
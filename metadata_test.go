@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	stdhttp "net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+	stdtime "time"
+)
+
+func TestMetadataAddressAllowed(t *testing.T) {
+	tests := [...]struct {
+		Addr    string
+		Allowed bool
+	}{
+		{"93.184.215.14", true},
+		{"2606:2800:21f:cb07:6820:80da:af6b:8b2c", true},
+		{"8.8.8.8", true},
+
+		{"0.0.0.0", false},
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"100.127.255.254", false},
+		{"198.18.0.1", false},
+		{"224.0.0.1", false},
+		{"240.0.0.1", false},
+		{"255.255.255.255", false},
+
+		{"::", false},
+		{"::1", false},
+		{"fe80::1", false},
+		{"fc00::1", false},
+		{"fd12:3456::1", false},
+		{"ff02::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:10.0.0.1", false},
+		{"::ffff:100.64.0.1", false},
+		{"::ffff:93.184.215.14", true},
+		{"::10.0.0.1", false},
+		{"64:ff9b::a00:1", false},
+		{"64:ff9b:1::a00:1", false},
+		{"2001:0:4136:e378:8000:63bf:3fff:fdd2", false},
+		{"2002:a00:1::1", false},
+	}
+	for _, test := range tests {
+		if allowed := MetadataAddressAllowed(netip.MustParseAddr(test.Addr)); allowed != test.Allowed {
+			t.Errorf("MetadataAddressAllowed(%s) = %v, expected %v", test.Addr, allowed, test.Allowed)
+		}
+	}
+
+	if MetadataAddressAllowed(netip.Addr{}) {
+		t.Errorf("MetadataAddressAllowed() allows invalid address")
+	}
+}
+
+func TestParseHTMLMetadata(t *testing.T) {
+	base, _ := url.Parse("https://example.com/dir/page")
+
+	tests := [...]struct {
+		Name     string
+		Document string
+		Expected URLMetadata
+	}{
+		{"title", `<html><head><title> Example &amp; Co </title></head></html>`, URLMetadata{Title: "Example & Co"}},
+		{"first title", `<title>First</title><title>Second</title>`, URLMetadata{Title: "First"}},
+		{"Open Graph over title", `<title>Title</title><meta property="og:title" content="OG">`, URLMetadata{Title: "OG"}},
+		{"Twitter over title", `<TITLE>Title</TITLE><META name="twitter:title" content="Twitter">`, URLMetadata{Title: "Twitter"}},
+		{"Open Graph before Twitter", `<meta property="og:title" content="OG"><meta name="twitter:title" content="Twitter">`, URLMetadata{Title: "OG"}},
+		{"Twitter before Open Graph", `<meta name="twitter:title" content="Twitter"><meta property="og:title" content="OG">`, URLMetadata{Title: "Twitter"}},
+		{"description", `<meta name="description" content='Plain'>`, URLMetadata{Description: "Plain"}},
+		{"Open Graph over description", `<meta property="og:description" content="OG"><meta name="description" content="Plain">`, URLMetadata{Description: "OG"}},
+		{"relative image", `<meta property="og:image" content="../img/a.png">`, URLMetadata{ImageURL: "https://example.com/img/a.png"}},
+		{"image with other scheme", `<meta property="og:image" content="javascript:alert(1)">`, URLMetadata{}},
+		{"meta without content", `<meta property="og:title"><title>Title</title>`, URLMetadata{Title: "Title"}},
+		{"body is not read", `<head></head><body><title>Body</title><meta name="description" content="Body">`, URLMetadata{}},
+		{"long title", `<title>` + strings.Repeat("a", MaxMetadataTitleLen+10) + `</title>`, URLMetadata{Title: strings.Repeat("a", MaxMetadataTitleLen)}},
+		{"broken tags", `<title>Title`, URLMetadata{}},
+	}
+	for _, test := range tests {
+		if md := ParseHTMLMetadata(test.Document, base); md != test.Expected {
+			t.Errorf("%s: ParseHTMLMetadata() = %+v, expected %+v", test.Name, md, test.Expected)
+		}
+	}
+}
+
+/* AllowPrivateMetadata lets fetcher reach test servers on loopback until the end of the test. */
+func AllowPrivateMetadata(t *testing.T) {
+	prev := GetConfig()
+	cfg := *prev
+	cfg.MetadataAllowPrivate = true
+	CurrentConfig.Store(&cfg)
+	t.Cleanup(func() { CurrentConfig.Store(prev) })
+}
+
+func TestFetchMetadata(t *testing.T) {
+	AllowPrivateMetadata(t)
+
+	mux := stdhttp.NewServeMux()
+	page := func(contentType string, body string) stdhttp.HandlerFunc {
+		return func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+			w.Header().Set("Content-Type", contentType)
+			w.Write([]byte(body))
+		}
+	}
+	mux.Handle("/page", page("text/html; charset=utf-8", `<head><meta property="og:title" content="OG"><title>Title</title><meta property="og:image" content="/a.png">`))
+	mux.Handle("/xhtml", page("application/xhtml+xml", `<title>XHTML</title>`))
+	mux.Handle("/image", page("image/png", `<title>Image</title>`))
+	mux.Handle("/plain", page("text/plain", `<title>Plain</title>`))
+	mux.Handle("/untyped", page("", `<title>Untyped</title>`))
+	mux.Handle("/inside", page("text/html", `<head>`+strings.Repeat(" ", MetadataMaxBodySize-len(`<head><title>Inside</title>`))+`<title>Inside</title>`))
+	mux.Handle("/outside", page("text/html", `<head>`+strings.Repeat(" ", MetadataMaxBodySize)+`<title>Outside</title>`))
+	mux.Handle("/missing", stdhttp.NotFoundHandler())
+	mux.Handle("/redirect", stdhttp.RedirectHandler("/page", stdhttp.StatusFound))
+	mux.HandleFunc("/loop", func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+		stdhttp.Redirect(w, r, "/loop", stdhttp.StatusFound)
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	tests := [...]struct {
+		Path     string
+		Expected URLMetadata
+		Error    bool
+	}{
+		{"/page", URLMetadata{Title: "OG", ImageURL: srv.URL + "/a.png"}, false},
+		{"/redirect", URLMetadata{Title: "OG", ImageURL: srv.URL + "/a.png"}, false},
+		{"/xhtml", URLMetadata{Title: "XHTML"}, false},
+		{"/inside", URLMetadata{Title: "Inside"}, false},
+		{"/outside", URLMetadata{}, false},
+		{"/image", URLMetadata{}, true},
+		{"/plain", URLMetadata{}, true},
+		{"/untyped", URLMetadata{}, true},
+		{"/missing", URLMetadata{}, true},
+		{"/loop", URLMetadata{}, true},
+	}
+	for _, test := range tests {
+		md, err := FetchMetadata(context.Background(), srv.URL+test.Path)
+		if (err != nil) != test.Error {
+			t.Errorf("FetchMetadata(%s) returned error %v, expected error %v", test.Path, err, test.Error)
+		} else if md != test.Expected {
+			t.Errorf("FetchMetadata(%s) = %+v, expected %+v", test.Path, md, test.Expected)
+		}
+	}
+
+	if _, err := FetchMetadata(context.Background(), "ftp://example.com/"); err == nil {
+		t.Errorf("FetchMetadata() of FTP URL succeeded, expected error")
+	}
+}
+
+func TestFetchMetadataTimeout(t *testing.T) {
+	AllowPrivateMetadata(t)
+
+	srv := httptest.NewServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*stdtime.Millisecond)
+	defer cancel()
+
+	start := stdtime.Now()
+	if _, err := FetchMetadata(ctx, srv.URL); err == nil {
+		t.Errorf("FetchMetadata() of hanging page succeeded, expected error")
+	}
+	if elapsed := stdtime.Since(start); elapsed > MetadataTimeout/2 {
+		t.Errorf("FetchMetadata() returned after %v, expected to give up in time", elapsed)
+	}
+}
+
+func TestFetchMetadataForbidden(t *testing.T) {
+	srv := httptest.NewServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+		t.Errorf("Fetcher has reached loopback address")
+	}))
+	defer srv.Close()
+
+	if _, err := FetchMetadata(context.Background(), srv.URL); (err == nil) || (!strings.Contains(err.Error(), ForbiddenAddress.Error())) {
+		t.Errorf("FetchMetadata() of loopback address returned %v, expected %v", err, ForbiddenAddress)
+	}
+}
@@ -0,0 +1,479 @@
+package main
+
+import (
+	"github.com/anton2920/gofa/errors"
+)
+
+/* QRLevel is error correction level of QR code. */
+type QRLevel int
+
+const (
+	QRLevelL QRLevel = iota
+	QRLevelM
+	QRLevelQ
+	QRLevelH
+)
+
+/* QRCode is a square matrix of modules, 'true' means dark. */
+type QRCode struct {
+	Size    int
+	Modules []bool
+
+	function []bool
+}
+
+const (
+	QRMinVersion = 1
+	QRMaxVersion = 40
+)
+
+/* NOTE(anton2920): tables are indexed by level and version, see ISO/IEC 18004 Table 9. */
+var QREccCodewordsPerBlock = [4][QRMaxVersion + 1]int{
+	{-1, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+	{-1, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+}
+
+var QRErrorCorrectionBlocks = [4][QRMaxVersion + 1]int{
+	{-1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+	{-1, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+	{-1, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+	{-1, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+}
+
+/* QRLevelFormatBits are values of error correction level as they are written into format information. */
+var QRLevelFormatBits = [4]int{1, 0, 3, 2}
+
+var QRTooLong = errors.New("data is too long for QR code")
+
+/* QRRawDataModules returns number of modules available for data and error correction codewords. */
+func QRRawDataModules(version int) int {
+	result := (16*version+128)*version + 64
+	if version >= 2 {
+		nalign := version/7 + 2
+		result -= (25*nalign-10)*nalign - 55
+		if version >= 7 {
+			result -= 36
+		}
+	}
+	return result
+}
+
+func QRDataCodewords(version int, level QRLevel) int {
+	return QRRawDataModules(version)/8 - QREccCodewordsPerBlock[level][version]*QRErrorCorrectionBlocks[level][version]
+}
+
+func QRAlignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+
+	nalign := version/7 + 2
+	step := (version*8 + nalign*3 + 5) / (nalign*4 - 4) * 2
+
+	result := make([]int, nalign)
+	result[0] = 6
+	for i, pos := nalign-1, version*4+10; i >= 1; i, pos = i-1, pos-step {
+		result[i] = pos
+	}
+	return result
+}
+
+/* QRMultiply multiplies two elements of GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1. */
+func QRMultiply(x byte, y byte) byte {
+	var z int
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>i)&1) * int(x)
+	}
+	return byte(z)
+}
+
+func QRReedSolomonDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+
+	var root byte = 1
+	for i := 0; i < degree; i++ {
+		for j := 0; j < degree; j++ {
+			result[j] = QRMultiply(result[j], root)
+			if j+1 < degree {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = QRMultiply(root, 0x02)
+	}
+	return result
+}
+
+func QRReedSolomonRemainder(data []byte, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i := 0; i < len(result); i++ {
+			result[i] ^= QRMultiply(divisor[i], factor)
+		}
+	}
+	return result
+}
+
+/* QRAppendBits appends 'n' low bits of 'value' to the bit buffer, one bit per byte. */
+func QRAppendBits(bits []byte, value int, n int) []byte {
+	for i := n - 1; i >= 0; i-- {
+		bits = append(bits, byte((value>>i)&1))
+	}
+	return bits
+}
+
+/* QREncodeData returns data codewords of 'data' in byte mode, padded to the capacity of 'version'. */
+func QREncodeData(data []byte, version int, level QRLevel) []byte {
+	capacity := QRDataCodewords(version, level) * 8
+
+	countBits := 8
+	if version >= 10 {
+		countBits = 16
+	}
+
+	bits := make([]byte, 0, capacity)
+	bits = QRAppendBits(bits, 0x4, 4)
+	bits = QRAppendBits(bits, len(data), countBits)
+	for _, b := range data {
+		bits = QRAppendBits(bits, int(b), 8)
+	}
+	bits = QRAppendBits(bits, 0, min(4, capacity-len(bits)))
+	bits = QRAppendBits(bits, 0, (8-len(bits)%8)%8)
+
+	codewords := make([]byte, 0, capacity/8)
+	for i := 0; i < len(bits); i += 8 {
+		var b byte
+		for j := 0; j < 8; j++ {
+			b = (b << 1) | bits[i+j]
+		}
+		codewords = append(codewords, b)
+	}
+	for pad := byte(0xEC); len(codewords) < capacity/8; pad ^= 0xEC ^ 0x11 {
+		codewords = append(codewords, pad)
+	}
+	return codewords
+}
+
+/* QRAddErrorCorrection splits data into blocks, computes their error correction codewords and interleaves everything. */
+func QRAddErrorCorrection(data []byte, version int, level QRLevel) []byte {
+	nblocks := QRErrorCorrectionBlocks[level][version]
+	eccLen := QREccCodewordsPerBlock[level][version]
+	raw := QRRawDataModules(version) / 8
+	nshort := nblocks - raw%nblocks
+	shortLen := raw / nblocks
+
+	divisor := QRReedSolomonDivisor(eccLen)
+
+	blocks := make([][]byte, nblocks)
+	for i, k := 0, 0; i < nblocks; i++ {
+		n := shortLen - eccLen
+		if i >= nshort {
+			n++
+		}
+		block := make([]byte, 0, shortLen+1)
+		block = append(block, data[k:k+n]...)
+		k += n
+
+		ecc := QRReedSolomonRemainder(block, divisor)
+		if i < nshort {
+			block = append(block, 0)
+		}
+		blocks[i] = append(block, ecc...)
+	}
+
+	result := make([]byte, 0, raw)
+	for i := 0; i < len(blocks[0]); i++ {
+		for j := 0; j < len(blocks); j++ {
+			/* NOTE(anton2920): short blocks have placeholder where long ones have their last data codeword. */
+			if (i != shortLen-eccLen) || (j >= nshort) {
+				result = append(result, blocks[j][i])
+			}
+		}
+	}
+	return result
+}
+
+func (qr *QRCode) Get(x int, y int) bool {
+	return qr.Modules[y*qr.Size+x]
+}
+
+func (qr *QRCode) setFunction(x int, y int, dark bool) {
+	qr.Modules[y*qr.Size+x] = dark
+	qr.function[y*qr.Size+x] = true
+}
+
+func (qr *QRCode) drawFinder(x int, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if (xx < 0) || (xx >= qr.Size) || (yy < 0) || (yy >= qr.Size) {
+				continue
+			}
+			dist := max(abs(dx), abs(dy))
+			qr.setFunction(xx, yy, (dist != 2) && (dist != 4))
+		}
+	}
+}
+
+func (qr *QRCode) drawAlignment(x int, y int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			qr.setFunction(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+		}
+	}
+}
+
+func (qr *QRCode) drawFormat(level QRLevel, mask int) {
+	data := QRLevelFormatBits[level]<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+
+	bit := func(i int) bool { return (bits>>i)&1 != 0 }
+
+	for i := 0; i <= 5; i++ {
+		qr.setFunction(8, i, bit(i))
+	}
+	qr.setFunction(8, 7, bit(6))
+	qr.setFunction(8, 8, bit(7))
+	qr.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		qr.setFunction(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		qr.setFunction(qr.Size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		qr.setFunction(8, qr.Size-15+i, bit(i))
+	}
+	qr.setFunction(8, qr.Size-8, true)
+}
+
+func (qr *QRCode) drawVersion(version int) {
+	if version < 7 {
+		return
+	}
+
+	rem := version
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+	}
+	bits := version<<12 | rem
+
+	for i := 0; i < 18; i++ {
+		dark := (bits>>i)&1 != 0
+		a, b := qr.Size-11+i%3, i/3
+		qr.setFunction(a, b, dark)
+		qr.setFunction(b, a, dark)
+	}
+}
+
+func (qr *QRCode) drawFunctionPatterns(version int, level QRLevel) {
+	for i := 0; i < qr.Size; i++ {
+		qr.setFunction(6, i, i%2 == 0)
+		qr.setFunction(i, 6, i%2 == 0)
+	}
+
+	qr.drawFinder(3, 3)
+	qr.drawFinder(qr.Size-4, 3)
+	qr.drawFinder(3, qr.Size-4)
+
+	positions := QRAlignmentPositions(version)
+	n := len(positions)
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			if ((i == 0) && (j == 0)) || ((i == 0) && (j == n-1)) || ((i == n-1) && (j == 0)) {
+				continue
+			}
+			qr.drawAlignment(positions[i], positions[j])
+		}
+	}
+
+	/* NOTE(anton2920): format is drawn with dummy mask to reserve its modules, real one is written later. */
+	qr.drawFormat(level, 0)
+	qr.drawVersion(version)
+}
+
+/* drawCodewords places codewords in zigzag order, from bottom-right corner upwards in pairs of columns. */
+func (qr *QRCode) drawCodewords(data []byte) {
+	var i int
+	for right := qr.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < qr.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = qr.Size - 1 - vert
+				}
+				if (!qr.function[y*qr.Size+x]) && (i < len(data)*8) {
+					qr.Modules[y*qr.Size+x] = (data[i>>3]>>(7-(i&7)))&1 != 0
+					i++
+				}
+			}
+		}
+	}
+}
+
+func QRMaskBit(mask int, x int, y int) bool {
+	switch mask {
+	default:
+		return (x+y)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (x+y)%3 == 0
+	case 4:
+		return (x/3+y/2)%2 == 0
+	case 5:
+		return x*y%2+x*y%3 == 0
+	case 6:
+		return (x*y%2+x*y%3)%2 == 0
+	case 7:
+		return ((x+y)%2+x*y%3)%2 == 0
+	}
+}
+
+/* applyMask XORs data modules with 'mask'. Applying the same mask twice undoes it. */
+func (qr *QRCode) applyMask(mask int) {
+	for y := 0; y < qr.Size; y++ {
+		for x := 0; x < qr.Size; x++ {
+			if (!qr.function[y*qr.Size+x]) && (QRMaskBit(mask, x, y)) {
+				qr.Modules[y*qr.Size+x] = !qr.Modules[y*qr.Size+x]
+			}
+		}
+	}
+}
+
+/* penalty scores how hard the symbol is to read, lower is better. */
+func (qr *QRCode) penalty() int {
+	const (
+		N1 = 3
+		N2 = 3
+		N3 = 40
+		N4 = 10
+	)
+	var result, dark int
+
+	/* NOTE(anton2920): 1:1:3:1:1 finder-like pattern with four light modules on either side. */
+	finderBefore := [...]bool{false, false, false, false, true, false, true, true, true, false, true}
+	finderAfter := [...]bool{true, false, true, true, true, false, true, false, false, false, false}
+
+	line := make([]bool, qr.Size)
+	for pass := 0; pass < 2; pass++ {
+		for a := 0; a < qr.Size; a++ {
+			for b := 0; b < qr.Size; b++ {
+				if pass == 0 {
+					line[b] = qr.Get(b, a)
+				} else {
+					line[b] = qr.Get(a, b)
+				}
+			}
+
+			run := 1
+			for b := 1; b <= qr.Size; b++ {
+				if (b < qr.Size) && (line[b] == line[b-1]) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					result += N1 + run - 5
+				}
+				run = 1
+			}
+
+			for b := 0; b+len(finderBefore) <= qr.Size; b++ {
+				before, after := true, true
+				for k := 0; k < len(finderBefore); k++ {
+					before = before && (line[b+k] == finderBefore[k])
+					after = after && (line[b+k] == finderAfter[k])
+				}
+				if before {
+					result += N3
+				}
+				if after {
+					result += N3
+				}
+			}
+		}
+	}
+
+	for y := 0; y < qr.Size; y++ {
+		for x := 0; x < qr.Size; x++ {
+			c := qr.Get(x, y)
+			if c {
+				dark++
+			}
+			if (x+1 < qr.Size) && (y+1 < qr.Size) && (c == qr.Get(x+1, y)) && (c == qr.Get(x, y+1)) && (c == qr.Get(x+1, y+1)) {
+				result += N2
+			}
+		}
+	}
+
+	total := qr.Size * qr.Size
+	k := (abs(dark*20-total*10)+total-1)/total - 1
+	result += max(k, 0) * N4
+
+	return result
+}
+
+/* QREncode encodes 'data' in byte mode using the smallest version that fits and the best mask. */
+func QREncode(data []byte, level QRLevel) (*QRCode, error) {
+	version := QRMinVersion
+	for ; version <= QRMaxVersion; version++ {
+		countBits := 8
+		if version >= 10 {
+			countBits = 16
+		}
+		if 4+countBits+len(data)*8 <= QRDataCodewords(version, level)*8 {
+			break
+		}
+	}
+	if version > QRMaxVersion {
+		return nil, QRTooLong
+	}
+
+	codewords := QRAddErrorCorrection(QREncodeData(data, version, level), version, level)
+
+	qr := new(QRCode)
+	qr.Size = version*4 + 17
+	qr.Modules = make([]bool, qr.Size*qr.Size)
+	qr.function = make([]bool, qr.Size*qr.Size)
+
+	qr.drawFunctionPatterns(version, level)
+	qr.drawCodewords(codewords)
+
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		qr.applyMask(mask)
+		qr.drawFormat(level, mask)
+		if p := qr.penalty(); (bestPenalty < 0) || (p < bestPenalty) {
+			best, bestPenalty = mask, p
+		}
+		qr.applyMask(mask)
+	}
+	qr.applyMask(best)
+	qr.drawFormat(level, best)
+
+	return qr, nil
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestQRDataCodewords(t *testing.T) {
+	tests := [...]struct {
+		Version   int
+		Level     QRLevel
+		Codewords int
+	}{
+		{1, QRLevelL, 19},
+		{1, QRLevelM, 16},
+		{1, QRLevelQ, 13},
+		{1, QRLevelH, 9},
+		{2, QRLevelL, 34},
+		{5, QRLevelQ, 62},
+		{7, QRLevelM, 124},
+		{10, QRLevelH, 122},
+		{40, QRLevelL, 2956},
+		{40, QRLevelH, 1276},
+	}
+	for _, test := range tests {
+		if codewords := QRDataCodewords(test.Version, test.Level); codewords != test.Codewords {
+			t.Errorf("QRDataCodewords(%d, %d) = %d, expected %d", test.Version, test.Level, codewords, test.Codewords)
+		}
+	}
+}
+
+func TestQRAlignmentPositions(t *testing.T) {
+	tests := [...]struct {
+		Version   int
+		Positions []int
+	}{
+		{1, nil},
+		{2, []int{6, 18}},
+		{7, []int{6, 22, 38}},
+		{15, []int{6, 26, 48, 70}},
+		{32, []int{6, 34, 60, 86, 112, 138}},
+		{40, []int{6, 30, 58, 86, 114, 142, 170}},
+	}
+	for _, test := range tests {
+		if positions := QRAlignmentPositions(test.Version); !slices.Equal(positions, test.Positions) {
+			t.Errorf("QRAlignmentPositions(%d) = %v, expected %v", test.Version, positions, test.Positions)
+		}
+	}
+}
+
+func TestQRReedSolomonRemainder(t *testing.T) {
+	/* NOTE(anton2920): 'HELLO WORLD' as 1-M, see example in ISO/IEC 18004 Annex I. */
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	expected := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+
+	if ecc := QRReedSolomonRemainder(data, QRReedSolomonDivisor(len(expected))); !bytes.Equal(ecc, expected) {
+		t.Errorf("QRReedSolomonRemainder() = %v, expected %v", ecc, expected)
+	}
+}
+
+func TestQREncodeData(t *testing.T) {
+	/* Mode 0100, length 00000010, 'h' and 'i', terminator 0000, then alternating pad codewords. */
+	expected := []byte{0x40, 0x26, 0x86, 0x90}
+	for len(expected) < QRDataCodewords(1, QRLevelL) {
+		expected = append(expected, 0xEC, 0x11)
+	}
+	expected = expected[:QRDataCodewords(1, QRLevelL)]
+
+	if data := QREncodeData([]byte("hi"), 1, QRLevelL); !bytes.Equal(data, expected) {
+		t.Errorf("QREncodeData() = %x, expected %x", data, expected)
+	}
+}
+
+func TestQREncodeVersion(t *testing.T) {
+	tests := [...]struct {
+		Len   int
+		Level QRLevel
+		Size  int
+	}{
+		{0, QRLevelL, 21},
+		{17, QRLevelL, 21},
+		{18, QRLevelL, 25},
+		{14, QRLevelM, 21},
+		{15, QRLevelM, 25},
+		{7, QRLevelH, 21},
+		{8, QRLevelH, 25},
+		{230, QRLevelL, 53},
+		{231, QRLevelL, 57},
+		{2953, QRLevelL, 177},
+		{1273, QRLevelH, 177},
+	}
+	for _, test := range tests {
+		qr, err := QREncode(make([]byte, test.Len), test.Level)
+		if err != nil {
+			t.Errorf("QREncode(%d bytes, %d) failed: %v", test.Len, test.Level, err)
+			continue
+		}
+		if (qr.Size != test.Size) || (len(qr.Modules) != qr.Size*qr.Size) {
+			t.Errorf("QREncode(%d bytes, %d) has size %d, expected %d", test.Len, test.Level, qr.Size, test.Size)
+		}
+	}
+
+	if _, err := QREncode(make([]byte, 2954), QRLevelL); err != QRTooLong {
+		t.Errorf("QREncode() of too long data returned %v, expected %v", err, QRTooLong)
+	}
+	if _, err := QREncode(make([]byte, 1274), QRLevelH); err != QRTooLong {
+		t.Errorf("QREncode() of too long data returned %v, expected %v", err, QRTooLong)
+	}
+}
+
+/* QRReadFormat returns both copies of format information of 'qr' with mask pattern removed. */
+func QRReadFormat(qr *QRCode) (int, int) {
+	bit := func(x, y int, i int) int {
+		if qr.Get(x, y) {
+			return 1 << i
+		}
+		return 0
+	}
+
+	var first, second int
+	for i := 0; i <= 5; i++ {
+		first |= bit(8, i, i)
+	}
+	first |= bit(8, 7, 6) | bit(8, 8, 7) | bit(7, 8, 8)
+	for i := 9; i < 15; i++ {
+		first |= bit(14-i, 8, i)
+	}
+
+	for i := 0; i < 8; i++ {
+		second |= bit(qr.Size-1-i, 8, i)
+	}
+	for i := 8; i < 15; i++ {
+		second |= bit(8, qr.Size-15+i, i)
+	}
+
+	return first ^ 0x5412, second ^ 0x5412
+}
+
+func TestQREncodeFunctionPatterns(t *testing.T) {
+	finder := [...]string{
+		"#######",
+		"#.....#",
+		"#.###.#",
+		"#.###.#",
+		"#.###.#",
+		"#.....#",
+		"#######",
+	}
+
+	for level := QRLevelL; level <= QRLevelH; level++ {
+		qr, err := QREncode([]byte("https://example.com/abcdefghijklmnopqrstuvwxyz.qr"), level)
+		if err != nil {
+			t.Fatalf("QREncode() failed: %v", err)
+		}
+
+		for _, corner := range [...][2]int{{0, 0}, {qr.Size - 7, 0}, {0, qr.Size - 7}} {
+			for y := 0; y < len(finder); y++ {
+				for x := 0; x < len(finder[y]); x++ {
+					if qr.Get(corner[0]+x, corner[1]+y) != (finder[y][x] == '#') {
+						t.Fatalf("level %d: finder pattern at %v is broken at (%d, %d)", level, corner, x, y)
+					}
+				}
+			}
+		}
+		for i := 8; i < qr.Size-8; i++ {
+			if (qr.Get(i, 6) != (i%2 == 0)) || (qr.Get(6, i) != (i%2 == 0)) {
+				t.Fatalf("level %d: timing pattern is broken at %d", level, i)
+			}
+		}
+		if !qr.Get(8, qr.Size-8) {
+			t.Errorf("level %d: dark module is missing", level)
+		}
+
+		first, second := QRReadFormat(qr)
+		if first != second {
+			t.Errorf("level %d: copies of format information differ: %015b and %015b", level, first, second)
+		}
+		rem := first
+		for i := 14; i >= 10; i-- {
+			if (rem>>i)&1 != 0 {
+				rem ^= 0x537 << (i - 10)
+			}
+		}
+		if rem != 0 {
+			t.Errorf("level %d: format information %015b has invalid BCH code", level, first)
+		}
+		if first>>13 != QRLevelFormatBits[level] {
+			t.Errorf("level %d: format information %015b has level bits %02b", level, first, first>>13)
+		}
+	}
+}
+
+func TestQREncode(t *testing.T) {
+	/* NOTE(anton2920): symbol is the same as other encoders produce, it is decoded as 'https://example.com/abc.qr'. */
+	expected := [...]string{
+		"#######...#.##..#.#######",
+		"#.....#..#...####.#.....#",
+		"#.###.#.#####.#...#.###.#",
+		"#.###.#.#...####..#.###.#",
+		"#.###.#.#.#..#..#.#.###.#",
+		"#.....#.##..#.##..#.....#",
+		"#######.#.#.#.#.#.#######",
+		"........##.#..#.#........",
+		"#.#####..#.#.#....#####..",
+		"##.###..######...#.#...#.",
+		"..#...##..#..####..#.#.##",
+		"#.###..#.#.#..###.##....#",
+		"...#..#.########.##.#.###",
+		"###.....###.#...#..#.#.#.",
+		"#.########..#..#..####.##",
+		"#.#.##.######.#######...#",
+		"#.##.##..#...##.#####.#..",
+		"........####...##...##...",
+		"#######..##..#..#.#.#.###",
+		"#.....#.##..#...#...##...",
+		"#.###.#.###..########.#..",
+		"#.###.#.#..#...#.##.#####",
+		"#.###.#.#.#.##.#.....##.#",
+		"#.....#..###..#.##.###..#",
+		"#######.######...########",
+	}
+
+	qr, err := QREncode([]byte("https://example.com/abc.qr"), QRLevelM)
+	if err != nil {
+		t.Fatalf("QREncode() failed: %v", err)
+	}
+	if qr.Size != len(expected) {
+		t.Fatalf("QREncode() has size %d, expected %d", qr.Size, len(expected))
+	}
+
+	for y := 0; y < qr.Size; y++ {
+		var row strings.Builder
+		for x := 0; x < qr.Size; x++ {
+			if qr.Get(x, y) {
+				row.WriteByte('#')
+			} else {
+				row.WriteByte('.')
+			}
+		}
+		if row.String() != expected[y] {
+			t.Errorf("row %2d is %s, expected %s", y, row.String(), expected[y])
+		}
+	}
+}
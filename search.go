@@ -0,0 +1,354 @@
+package main
+
+import (
+	"math"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/anton2920/gofa/database"
+	"github.com/anton2920/gofa/net/http"
+	"github.com/anton2920/gofa/trace"
+)
+
+/* SearchIndex is an inverted index from words to links and their relevance. Postings are kept separately for each user, so search never walks links of others. */
+type SearchIndex struct {
+	sync.RWMutex
+
+	Postings map[database.ID]map[string]map[string]int32
+	Words    map[string]map[string]int32
+	Owners   map[string]database.ID
+}
+
+/* SearchResult is an element of search results in responses of the API. */
+type SearchResult struct {
+	URLListItem
+	Score int32 `json:"score"`
+
+	/* RecentRedirects are made during last StatsDays days. */
+	RecentRedirects int64 `json:"recent_redirects"`
+}
+
+type SearchResults struct {
+	Total   int            `json:"total"`
+	Page    int            `json:"page"`
+	Results []SearchResult `json:"results"`
+}
+
+/* Relevance of a word depends on the field it was found in. */
+const (
+	SearchWeightCode   = 8
+	SearchWeightTitle  = 4
+	SearchWeightTag    = 4
+	SearchWeightTarget = 2
+	SearchWeightNote   = 1
+)
+
+const (
+	SearchPerPage  = 20
+	MaxSearchWords = 8
+	MaxSearchLen   = 128
+)
+
+var URLIndex = SearchIndex{
+	Postings: make(map[database.ID]map[string]map[string]int32),
+	Words:    make(map[string]map[string]int32),
+	Owners:   make(map[string]database.ID),
+}
+
+/* SearchWords splits 's' into lowercase words consisting of letters and digits. */
+func SearchWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return (!unicode.IsLetter(r)) && (!unicode.IsDigit(r))
+	})
+}
+
+/* URLSearchWords returns words of the link with their relevance. */
+func URLSearchWords(path string, url *URL) map[string]int32 {
+	words := make(map[string]int32)
+
+	add := func(s string, weight int32) {
+		for _, word := range SearchWords(s) {
+			words[word] += weight
+		}
+	}
+
+	add(path, SearchWeightCode)
+	add(url.Title, SearchWeightTitle)
+	add(url.Metadata.Title, SearchWeightTitle)
+	for _, tag := range url.Tags {
+		add(tag, SearchWeightTag)
+	}
+	add(url.RawURL, SearchWeightTarget)
+	add(url.Note, SearchWeightNote)
+	add(url.Metadata.Description, SearchWeightNote)
+
+	return words
+}
+
+/* Update replaces words of document 'path' owned by user 'id' with 'words'. Empty 'words' remove document from the index. */
+func (index *SearchIndex) Update(id database.ID, path string, words map[string]int32) {
+	defer trace.End(trace.Begin(""))
+
+	index.Lock()
+	defer index.Unlock()
+
+	old := index.Words[path]
+	owner, indexed := index.Owners[path]
+	if (indexed) && (owner == id) && (len(old) == len(words)) && (func() bool {
+		for word, weight := range words {
+			if old[word] != weight {
+				return false
+			}
+		}
+		return true
+	}()) {
+		return
+	}
+
+	if indexed {
+		user := index.Postings[owner]
+		for word := range old {
+			postings := user[word]
+			delete(postings, path)
+			if len(postings) == 0 {
+				delete(user, word)
+			}
+		}
+		if len(user) == 0 {
+			delete(index.Postings, owner)
+		}
+		delete(index.Words, path)
+		delete(index.Owners, path)
+	}
+	if len(words) == 0 {
+		return
+	}
+
+	user := index.Postings[id]
+	if user == nil {
+		user = make(map[string]map[string]int32)
+		index.Postings[id] = user
+	}
+	for word, weight := range words {
+		postings := user[word]
+		if postings == nil {
+			postings = make(map[string]int32)
+			user[word] = postings
+		}
+		postings[path] = weight
+	}
+	index.Words[path] = words
+	index.Owners[path] = id
+}
+
+func (index *SearchIndex) Remove(path string) {
+	index.Update(0, path, nil)
+}
+
+/* Search returns documents of user 'id' that contain all words of the 'query' with their total relevance. */
+func (index *SearchIndex) Search(id database.ID, query string) map[string]int32 {
+	defer trace.End(trace.Begin(""))
+
+	words := SearchWords(query)
+	if len(words) > MaxSearchWords {
+		words = words[:MaxSearchWords]
+	}
+	if len(words) == 0 {
+		return nil
+	}
+
+	index.RLock()
+	defer index.RUnlock()
+
+	user := index.Postings[id]
+	results := make(map[string]int32)
+	for path, weight := range user[words[0]] {
+		results[path] = weight
+	}
+	for _, word := range words[1:] {
+		postings := user[word]
+		for path := range results {
+			weight, ok := postings[path]
+			if !ok {
+				delete(results, path)
+				continue
+			}
+			results[path] += weight
+		}
+	}
+
+	return results
+}
+
+func IndexURL(path string, url *URL) {
+	URLIndex.Update(url.UserID, path, URLSearchWords(path, url))
+}
+
+/* SearchUserURLs searches links of user 'id' and returns requested page of results, sorted by relevance or by number of recent redirects. */
+func SearchUserURLs(session *Session, query string, sortByClicks bool, page int) SearchResults {
+	defer trace.End(trace.Begin(""))
+
+	var results SearchResults
+	var url URL
+
+	for path, score := range URLIndex.Search(session.ID, query) {
+		if (GetURLByPath(path, &url) != nil) || (url.UserID != session.ID) {
+			continue
+		}
+		results.Results = append(results.Results, SearchResult{URLToListItem(path, &url), score, GetURLRecentRedirects(path)})
+	}
+
+	slices.SortFunc(results.Results, func(a, b SearchResult) int {
+		if sortByClicks {
+			if a.RecentRedirects != b.RecentRedirects {
+				return int(b.RecentRedirects - a.RecentRedirects)
+			}
+		}
+		if a.Score != b.Score {
+			return int(b.Score - a.Score)
+		}
+		return strings.Compare(a.Code, b.Code)
+	})
+
+	results.Total = len(results.Results)
+	results.Page = page
+	start := max(min((page-1)*SearchPerPage, len(results.Results)), 0)
+	end := min(start+SearchPerPage, len(results.Results))
+	results.Results = results.Results[start:end]
+
+	return results
+}
+
+func ParseSearchRequest(r *http.Request) (string, bool, int, error) {
+	query := r.Form.Get("Query")
+	if len(query) > MaxSearchLen {
+		return "", false, 0, http.BadRequest(Ls(GL, "length of the query must not exceed %d characters"), MaxSearchLen)
+	}
+
+	var sortByClicks bool
+	switch r.Form.Get("Sort") {
+	default:
+		return "", false, 0, http.BadRequest(Ls(GL, "results may be sorted only by 'relevance' or 'clicks'"))
+	case "", "relevance":
+	case "clicks":
+		sortByClicks = true
+	}
+
+	page := 1
+	if p := r.Form.Get("Page"); p != "" {
+		var err error
+		page, err = strconv.Atoi(p)
+		if (err != nil) || (page < 1) {
+			return "", false, 0, http.BadRequest(Ls(GL, "page must be a positive integer"))
+		}
+		/* NOTE(anton2920): offset of the page must fit into int. */
+		if page > (math.MaxInt-1)/SearchPerPage {
+			return "", false, 0, http.BadRequest(Ls(GL, "page must not exceed %d"), (math.MaxInt-1)/SearchPerPage)
+		}
+	}
+
+	return query, sortByClicks, page, nil
+}
+
+/* DisplayUserSearch displays search box and, if user searched for something, results. */
+func DisplayUserSearch(w *http.Response, r *http.Request, session *Session) {
+	defer trace.End(trace.Begin(""))
+
+	w.WriteString(`<form method="GET" action="/user/`)
+	w.WriteID(session.ID)
+	w.WriteString(`">`)
+	{
+		DisplayConstraintInput(w, "search", 0, MaxSearchLen, "Query", r.Form.Get("Query"), false)
+		w.WriteString(` <select name="Sort"><option value="relevance">`)
+		w.WriteString(Ls(GL, "By relevance"))
+		w.WriteString(`</option><option value="clicks"`)
+		if r.Form.Get("Sort") == "clicks" {
+			w.WriteString(` selected`)
+		}
+		w.WriteString(`>`)
+		w.WriteString(Ls(GL, "By clicks"))
+		w.WriteString(`</option></select> `)
+		DisplaySubmit(w, GL, "", "Search")
+	}
+	w.WriteString(`</form>`)
+
+	query, sortByClicks, page, err := ParseSearchRequest(r)
+	if err != nil {
+		DisplayError(w, GL, err)
+		return
+	}
+	if query == "" {
+		return
+	}
+	results := SearchUserURLs(session, query, sortByClicks, page)
+
+	w.WriteString(`<p>`)
+	w.WriteString(Ls(GL, "Found"))
+	w.WriteString(`: `)
+	w.WriteInt(results.Total)
+	w.WriteString(`</p>`)
+
+	w.WriteString(`<ol start="`)
+	w.WriteInt((page-1)*SearchPerPage + 1)
+	w.WriteString(`">`)
+	for i := 0; i < len(results.Results); i++ {
+		result := &results.Results[i]
+
+		w.WriteString(`<li><a href="/url/`)
+		w.WriteString(result.Code)
+		w.WriteString(`">`)
+		w.WriteString(result.Code)
+		w.WriteString(`</a> &rarr; `)
+		w.WriteHTMLString(result.Target)
+		if result.Title != "" {
+			w.WriteString(` &mdash; `)
+			w.WriteHTMLString(result.Title)
+		}
+		w.WriteString(`</li>`)
+	}
+	w.WriteString(`</ol>`)
+
+	for p := 1; (p-1)*SearchPerPage < results.Total; p++ {
+		if p == page {
+			w.WriteString(` `)
+			w.WriteInt(p)
+			continue
+		}
+		w.WriteString(` <a href="/user/`)
+		w.WriteID(session.ID)
+		w.WriteString(`?Query=`)
+		w.WriteHTMLString(url.QueryEscape(query))
+		w.WriteString(`&amp;Sort=`)
+		w.WriteHTMLString(r.Form.Get("Sort"))
+		w.WriteString(`&amp;Page=`)
+		w.WriteInt(p)
+		w.WriteString(`">`)
+		w.WriteInt(p)
+		w.WriteString(`</a>`)
+	}
+}
+
+/* URLSearchHandler returns JSON page of signed in user's links that match 'Query'. */
+func URLSearchHandler(w *http.Response, r *http.Request) error {
+	defer trace.End(trace.Begin(""))
+
+	session, err := GetSessionFromRequest(r)
+	if err != nil {
+		return http.UnauthorizedError
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return http.ClientError(err)
+	}
+
+	query, sortByClicks, page, err := ParseSearchRequest(r)
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, SearchUserURLs(session, query, sortByClicks, page))
+}
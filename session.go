@@ -2,9 +2,8 @@ package main
 
 import (
 	"encoding/base64"
-	"encoding/gob"
-	"os"
 	"sync"
+	stdtime "time"
 
 	"github.com/anton2920/gofa/database"
 	"github.com/anton2920/gofa/errors"
@@ -20,8 +19,6 @@ type Session struct {
 	Expiry int
 }
 
-const OneWeek = 60 * 60 * 24 * 7
-
 const SessionsFile = "sessions.gob"
 
 var (
@@ -29,6 +26,11 @@ var (
 	SessionsLock sync.RWMutex
 )
 
+/* SessionLifetime returns for how many seconds session stays valid since it was last used. */
+func SessionLifetime() int {
+	return int(GetConfig().SessionLifetime / stdtime.Second)
+}
+
 func GetSessionFromToken(token string) (*Session, error) {
 	defer trace.End(trace.Begin(""))
 
@@ -50,7 +52,7 @@ func GetSessionFromToken(token string) (*Session, error) {
 		return nil, errors.New("session for this token has expired")
 	}
 
-	session.Expiry = now + OneWeek
+	session.Expiry = now + SessionLifetime()
 	return session, nil
 }
 
@@ -81,36 +83,17 @@ func GenerateSessionToken() (string, error) {
 func StoreSessionsToFile(filename string) error {
 	defer trace.End(trace.Begin(""))
 
-	f, err := os.Create(filename)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	enc := gob.NewEncoder(f)
 	SessionsLock.Lock()
 	defer SessionsLock.Unlock()
 
-	if err := enc.Encode(Sessions); err != nil {
-		return err
-	}
-
-	return nil
+	return StoreGobToFile(filename, Sessions)
 }
 
 func RestoreSessionsFromFile(filename string) error {
 	defer trace.End(trace.Begin(""))
 
-	f, err := os.Open(filename)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	dec := gob.NewDecoder(f)
-	if err := dec.Decode(&Sessions); err != nil {
-		return err
-	}
+	SessionsLock.Lock()
+	defer SessionsLock.Unlock()
 
-	return nil
+	return RestoreGobFromFile(filename, &Sessions)
 }
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	stdhttp "net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	stdtime "time"
+
+	"github.com/anton2920/gofa/errors"
+	"github.com/anton2920/gofa/log"
+	"github.com/anton2920/gofa/trace"
+)
+
+/*
+ * TLS is terminated by standard library. Decrypted requests are passed to workers through Unix domain socket
+ * in data directory, with client address in 'X-Forwarded-For' and 'X-Forwarded-Proto: https'.
+ */
+const (
+	TLSBackendSocket = "tls-backend.sock"
+
+	/* TLSCheckInterval is how often certificate files are checked for changes. */
+	TLSCheckInterval = 10 * stdtime.Second
+
+	TLSReadHeaderTimeout = 10 * stdtime.Second
+	TLSIdleTimeout       = 2 * stdtime.Minute
+)
+
+/* CertificateStore holds certificates for all domains with modification times of files they were loaded from. */
+type CertificateStore struct {
+	Certificates []tls.Certificate
+	ModTimes     map[string]stdtime.Time
+}
+
+var CurrentCertificates atomic.Pointer[CertificateStore]
+
+var NoCertificates = errors.New("no TLS certificates are loaded")
+
+func LoadCertificatesFromFiles(certFiles []string, keyFiles []string) (*CertificateStore, error) {
+	defer trace.End(trace.Begin(""))
+
+	store := &CertificateStore{ModTimes: make(map[string]stdtime.Time)}
+	for i := 0; i < len(certFiles); i++ {
+		for _, filename := range [...]string{certFiles[i], keyFiles[i]} {
+			st, err := os.Stat(filename)
+			if err != nil {
+				return nil, err
+			}
+			store.ModTimes[filename] = st.ModTime()
+		}
+
+		cert, err := tls.LoadX509KeyPair(certFiles[i], keyFiles[i])
+		if err != nil {
+			return nil, err
+		}
+		if cert.Leaf == nil {
+			if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+				return nil, err
+			}
+		}
+		if stdtime.Now().After(cert.Leaf.NotAfter) {
+			log.Warnf("Certificate from %q has expired on %v", certFiles[i], cert.Leaf.NotAfter)
+		}
+		store.Certificates = append(store.Certificates, cert)
+	}
+
+	return store, nil
+}
+
+/* Changed reports whether any of the files certificates were loaded from has been modified. */
+func (store *CertificateStore) Changed() bool {
+	for filename, modTime := range store.ModTimes {
+		st, err := os.Stat(filename)
+		if (err != nil) || (!st.ModTime().Equal(modTime)) {
+			return true
+		}
+	}
+	return false
+}
+
+/* Certificate chooses certificate for the name client asks for with SNI. Clients without SNI get the first one. */
+func (store *CertificateStore) Certificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if (store == nil) || (len(store.Certificates) == 0) {
+		return nil, NoCertificates
+	}
+
+	for i := 0; i < len(store.Certificates); i++ {
+		if hello.SupportsCertificate(&store.Certificates[i]) == nil {
+			return &store.Certificates[i], nil
+		}
+	}
+	return &store.Certificates[0], nil
+}
+
+/* WatchCertificates reloads certificates when their files change, e.g. after renewal. */
+func WatchCertificates() {
+	for range stdtime.Tick(TLSCheckInterval) {
+		store := CurrentCertificates.Load()
+		if (store == nil) || (!store.Changed()) {
+			continue
+		}
+
+		cfg := GetConfig()
+		updated, err := LoadCertificatesFromFiles(cfg.TLSCertFiles, cfg.TLSKeyFiles)
+		if err != nil {
+			/* NOTE(anton2920): files may be caught in the middle of renewal, so it is tried again later. */
+			log.Errorf("Failed to reload TLS certificates, keeping previous ones: %v", err)
+			continue
+		}
+		CurrentCertificates.Store(updated)
+		log.Infof("TLS certificates are reloaded")
+	}
+}
+
+func NewTLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"http/1.1"},
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return CurrentCertificates.Load().Certificate(hello)
+		},
+	}
+}
+
+/* NewTLSProxy passes requests to workers through Unix domain socket 'backend'. */
+func NewTLSProxy(backend string) *httputil.ReverseProxy {
+	target := &url.URL{Scheme: "http", Host: "backend"}
+
+	return &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.Out.Host = pr.In.Host
+
+			/* NOTE(anton2920): terminator faces clients directly, so whatever they claim to forward from is discarded. */
+			pr.Out.Header.Del("X-Forwarded-For")
+			pr.SetXForwarded()
+		},
+		Transport: &stdhttp.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", backend)
+			},
+			MaxIdleConnsPerHost: 64,
+			IdleConnTimeout:     TLSIdleTimeout,
+		},
+		ErrorHandler: func(w stdhttp.ResponseWriter, r *stdhttp.Request, err error) {
+			log.Errorf("Failed to pass request %s %s to workers: %v", r.Method, r.URL.Path, err)
+			w.WriteHeader(stdhttp.StatusBadGateway)
+		},
+	}
+}
+
+/* HTTPSRedirectHandler sends clients to the same page over HTTPS. Unlike 301, 308 makes them repeat the method and body too. */
+func HTTPSRedirectHandler(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+	base := GetConfig().BaseURL
+	if !strings.HasPrefix(base, "https://") {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if host == "" {
+			stdhttp.Error(w, "Host header is required", stdhttp.StatusBadRequest)
+			return
+		}
+		if _, port, err := net.SplitHostPort(GetConfig().TLSAddress); (err == nil) && (port != "443") {
+			host = net.JoinHostPort(strings.Trim(host, "[]"), port)
+		}
+		base = "https://" + host
+	}
+	stdhttp.Redirect(w, r, base+r.URL.RequestURI(), stdhttp.StatusPermanentRedirect)
+}
+
+/* StartTLSServers serves HTTPS and redirect listeners among 'ls'. Requests from HTTPS are passed to 'backend'. */
+func StartTLSServers(ls []Listener, backend string) ([]*stdhttp.Server, error) {
+	defer trace.End(trace.Begin(""))
+
+	var servers []*stdhttp.Server
+	for i := 0; i < len(ls); i++ {
+		l := &ls[i]
+		if (l.Kind != ListenerHTTPS) && (l.Kind != ListenerRedirect) {
+			continue
+		}
+
+		/* NOTE(anton2920): net.FileListener makes its own copy, original descriptor is kept for upgrades. */
+		fd, err := syscall.Dup(int(l.FD))
+		if err != nil {
+			return servers, err
+		}
+		f := os.NewFile(uintptr(fd), l.Name)
+		nl, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return servers, err
+		}
+
+		srv := &stdhttp.Server{
+			ReadHeaderTimeout: TLSReadHeaderTimeout,
+			IdleTimeout:       TLSIdleTimeout,
+		}
+		if l.Kind == ListenerHTTPS {
+			srv.Handler = NewTLSProxy(backend)
+			nl = tls.NewListener(nl, NewTLSConfig())
+		} else {
+			srv.Handler = stdhttp.HandlerFunc(HTTPSRedirectHandler)
+		}
+		servers = append(servers, srv)
+
+		go func() {
+			if err := srv.Serve(nl); err != stdhttp.ErrServerClosed {
+				log.Errorf("Failed to serve %s listener %s: %v", l.Kind, l.Name, err)
+			}
+		}()
+	}
+
+	return servers, nil
+}
+
+/* ShutdownTLSServers stops accepting connections and waits for requests in progress until 'deadline'. */
+func ShutdownTLSServers(servers []*stdhttp.Server, deadline stdtime.Time) {
+	defer trace.End(trace.Begin(""))
+
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			srv.Close()
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	stdtime "time"
+
+	"github.com/anton2920/gofa/errors"
+	"github.com/anton2920/gofa/log"
+	"github.com/anton2920/gofa/trace"
+)
+
+/*
+ * Upgrade replaces running server with new binary without refusing connections:
+ *	1. Old process receives SIGUSR2 and starts new binary with its listening sockets as descriptors starting from 3 and pipe right after them.
+ *	2. New process loads configuration, checks that it can read data files and reports through the pipe that it is fine.
+ *	3. Old process stops accepting, drains connections, stores data, marks it as handed over to new process and releases lock of data files.
+ *	   Meanwhile new connections wait in listen queue. If data could not be stored, old process exits with error and leaves no mark.
+ *	4. New process takes the lock, checks the mark, restores data and starts accepting. Without the mark it refuses to start, because files are outdated.
+ */
+const (
+	/* UpgradeEnv is set for new process to comma-separated kinds of listeners it takes over. */
+	UpgradeEnv = "SHORTENER_UPGRADE"
+
+	/* UpgradeReadyTimeout is how long old process waits for new one to load configuration. */
+	UpgradeReadyTimeout = 30 * stdtime.Second
+
+	/* UpgradeStoreTimeout is how long old process may take to store data after connections are drained. */
+	UpgradeStoreTimeout = stdtime.Minute
+
+	/* UpgradeHandoffFile is written by old process once data is stored and contains PID of new process that may use it. */
+	UpgradeHandoffFile = "upgrade.handoff"
+)
+
+var (
+	UpgradeFailed    = errors.New("new process exited before it was ready")
+	UpgradeNoHandoff = errors.New("previous process has not stored data for this process")
+)
+
+/* StartUpgrade starts new binary with listening sockets 'ls' and returns its PID when it is ready to take over. */
+func StartUpgrade(ls []Listener, args []string) (int, error) {
+	defer trace.End(trace.Begin(""))
+
+	/* NOTE(anton2920): binary is looked up again, so the one installed in place of running one is started. */
+	path, err := exec.LookPath(os.Args[0])
+	if err != nil {
+		return 0, err
+	}
+
+	var kinds []string
+	files := make([]*os.File, 0, len(ls)+1)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for i := 0; i < len(ls); i++ {
+		/* NOTE(anton2920): backend socket belongs to this process only, new one creates its own. */
+		if ls[i].Kind == ListenerBackend {
+			continue
+		}
+		kinds = append(kinds, ls[i].Kind.String())
+
+		/* NOTE(anton2920): os.File closes descriptor when collected, so it gets its own copy of the listener. */
+		fd, err := syscall.Dup(int(ls[i].FD))
+		if err != nil {
+			return 0, err
+		}
+		files = append(files, os.NewFile(uintptr(fd), ls[i].Name))
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	cmd := exec.Command(path, append([]string{"serve"}, args...)...)
+	cmd.Env = append(os.Environ(), UpgradeEnv+"="+strings.Join(kinds, ","))
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = append(files, w)
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return 0, err
+	}
+	go cmd.Wait()
+
+	r.SetReadDeadline(stdtime.Now().Add(UpgradeReadyTimeout))
+	var buffer [1]byte
+	if _, err := r.Read(buffer[:]); err != nil {
+		cmd.Process.Kill()
+		if os.IsTimeout(err) {
+			return 0, err
+		}
+		return 0, UpgradeFailed
+	}
+
+	return cmd.Process.Pid, nil
+}
+
+/* UpgradeListeners returns listening sockets passed by previous process during upgrade. */
+func UpgradeListeners() ([]Listener, bool) {
+	value, ok := os.LookupEnv(UpgradeEnv)
+	if !ok {
+		return nil, false
+	}
+	os.Unsetenv(UpgradeEnv)
+
+	var kinds []ListenerKind
+	if value != "" {
+		for _, name := range strings.Split(value, ",") {
+			kinds = append(kinds, ParseListenerKind(name))
+		}
+	}
+	return InheritListeners(kinds), true
+}
+
+/* NotifyUpgradeReady tells previous process that it may stop serving. Its pipe follows 'nlisteners' inherited listeners. */
+func NotifyUpgradeReady(nlisteners int) {
+	defer trace.End(trace.Begin(""))
+
+	f := os.NewFile(uintptr(ListenFDsStart+nlisteners), "ready")
+	f.Write([]byte{1})
+	f.Close()
+}
+
+/*
+ * UpgradeLockTimeout is how long old process is expected to take to drain connections and store data.
+ * NOTE(anton2920): both of them read the same configuration, so old one is expected to have the same shutdown timeout.
+ */
+func UpgradeLockTimeout(cfg *Config) stdtime.Duration {
+	return cfg.ShutdownTimeout + UpgradeStoreTimeout
+}
+
+/*
+ * WaitUpgradeDataFiles takes exclusive lock of data files once previous process releases it. Previous process has stopped accepting by then,
+ * so giving up would leave nobody to serve. Instead it waits as long as previous process is alive, warning every UpgradeLockTimeout.
+ */
+func WaitUpgradeDataFiles(cfg *Config) (*os.File, error) {
+	defer trace.End(trace.Begin(""))
+
+	parent := os.Getppid()
+	warning := stdtime.Now().Add(UpgradeLockTimeout(cfg))
+	for {
+		f, err := LockDataFiles(true)
+		if err != DataFilesBusy {
+			return f, err
+		}
+		if os.Getppid() != parent {
+			/* NOTE(anton2920): previous process is gone, so lock is held by some command. */
+			return WaitDataFiles(DataLockTimeout)
+		}
+		if stdtime.Now().After(warning) {
+			log.Warnf("Previous process has not released data files for %v, still waiting...", UpgradeLockTimeout(cfg))
+			warning = warning.Add(UpgradeLockTimeout(cfg))
+		}
+		stdtime.Sleep(100 * stdtime.Millisecond)
+	}
+}
+
+/* StoreUpgradeHandoff marks data files as stored for new process 'pid'. Data files must be locked. */
+func StoreUpgradeHandoff(pid int) error {
+	defer trace.End(trace.Begin(""))
+
+	return os.WriteFile(DataFilePath(UpgradeHandoffFile), []byte(strconv.Itoa(pid)), 0644)
+}
+
+/* TakeUpgradeHandoff checks that previous process has stored data for this process and removes the mark. Data files must be locked. */
+func TakeUpgradeHandoff() error {
+	defer trace.End(trace.Begin(""))
+
+	filename := DataFilePath(UpgradeHandoffFile)
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return UpgradeNoHandoff
+		}
+		return err
+	}
+	if err := os.Remove(filename); err != nil {
+		return err
+	}
+
+	/* NOTE(anton2920): mark may be left by upgrade that has failed later, it is only valid for the process it was written for. */
+	if string(data) != strconv.Itoa(os.Getpid()) {
+		return UpgradeNoHandoff
+	}
+	return nil
+}
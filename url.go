@@ -2,34 +2,123 @@ package main
 
 import (
 	"net/url"
+	"os"
+	"slices"
+	"strconv"
 	"sync"
 	"unsafe"
 
 	"github.com/anton2920/gofa/database"
+	"github.com/anton2920/gofa/errors"
 	"github.com/anton2920/gofa/net/http"
+	"github.com/anton2920/gofa/strings"
 	"github.com/anton2920/gofa/time"
+	"github.com/anton2920/gofa/trace"
 )
 
 type URL struct {
-	ID    database.ID
-	Flags int32
+	ID        database.ID
+	UserID    database.ID
+	CreatedAt int64
+	Flags     int32
 
 	RawURL    string
 	ExpiresAt int64
 
+	/* Campaign is 'utm_campaign' added to RawURL on creation, used to group statistics. */
+	Campaign string
+
+	/* Link redirects to RawURL only inside [NotBefore, NotAfter]; zero means no bound. */
+	NotBefore       int64
+	NotAfter        int64
+	ScheduleURL     string
+	ScheduleMessage string
+
+	/* FallbackURL is used instead of RawURL when link is unavailable. */
+	FallbackURL string
+	Fallbacks   [ReasonCount]int64
+
+	/* Targets, if present, split traffic between several destinations by weight. */
+	Targets []URLTarget
+
+	/* DeviceRules send visitors of specific platforms to their own destinations. */
+	DeviceRules []URLDeviceRule
+
+	/* GeoRules send visitors to regional destinations, they are evaluated after DeviceRules. */
+	GeoRules []URLGeoRule
+
+	QueryPolicy QueryPolicy
+
+	/* StatusCode of zero means configured default. */
+	StatusCode http.Status
+
+	Revisions []URLRevision
+
+	Title string
+	Note  string
+
+	/* Metadata is fetched from destination page in background. */
+	Metadata URLMetadata
+
+	/* Preview is shown to bots of chat apps and social networks instead of redirect. */
+	Preview URLPreview
+
+	/* Folder is a '/'-separated path like 'marketing/2026'. */
+	Tags   []string
+	Folder string
+
+	PasswordHash []byte
+	PasswordSalt []byte
+
+	/* MaxRedirects of zero means link may be followed any number of times. */
+	MaxRedirects int64
+	Redirects    int64
+
+	/* ImportedRedirects were made before link was imported from another shortener, they are counted in Redirects too. */
+	ImportedRedirects int64
+
 	RedirectCounts map[int64]int64
 	RedirectFrom   map[string]int64
+
+	/* Scans are redirects that came from QR code, they are counted in Redirects too. */
+	Scans      int64
+	ScanCounts map[int64]int64
 }
 
 const (
 	FlagActive  int32 = 0
 	FlagDeleted       = 1
 	FlagPrivate       = 2
+
+	/* FlagStickyVariant keeps visitor on the same target of a split link. */
+	FlagStickyVariant = 4
+
+	/* FlagPassthrough allows '/<code>/extra/path?query' to be appended to the target. */
+	FlagPassthrough = 8
+
+	/* FlagServedPermanent is set once link has been served with permanent redirect. */
+	FlagServedPermanent = 16
 )
 
+const URLsFile = "urls.gob"
+
 var (
 	URLs     = make(map[string]URL)
 	URLsLock sync.RWMutex
+
+	/* URLsLastID is the ID given to the latest link. It is stored, so IDs of purged links are never given again. */
+	URLsLastID database.ID
+)
+
+/* URLsData is contents of URLsFile. */
+type URLsData struct {
+	LastID database.ID
+	URLs   map[string]URL
+}
+
+var (
+	URLExhausted = errors.New("URL has reached its limit of redirects")
+	URLPathTaken = errors.New("shortened URL is already taken")
 )
 
 func GetURLByID(id database.ID, url *URL) error {
@@ -61,34 +150,375 @@ func GetURLByPath(path string, url *URL) error {
 func CreateURL(path string, url *URL) error {
 	URLsLock.Lock()
 
-	url.ID = database.ID(len(URLs) + 1)
+	URLsLastID++
+	url.ID = URLsLastID
+	if url.CreatedAt == 0 {
+		url.CreatedAt = int64(time.Unix())
+	}
 	URLs[path] = *url
+	IndexURL(path, url)
 
 	URLsLock.Unlock()
 	return nil
 }
 
+/* CreateURLs creates either all links or none of them. Empty paths are replaced with random codes. */
+func CreateURLs(paths []string, urls []URL) error {
+	URLsLock.Lock()
+	defer URLsLock.Unlock()
+
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		if _, ok := URLs[path]; ok {
+			return URLPathTaken
+		}
+	}
+
+	/* NOTE(anton2920): imports may create lots of links, so generated codes are checked against the batch without scanning it. */
+	batch := make(map[string]struct{}, len(paths))
+	for _, path := range paths {
+		if path != "" {
+			batch[path] = struct{}{}
+		}
+	}
+	for i := 0; i < len(paths); i++ {
+		for paths[i] == "" {
+			path := string(RandomURLPath())
+			if _, ok := URLs[path]; ok {
+				continue
+			}
+			if _, ok := batch[path]; !ok {
+				paths[i] = path
+				batch[path] = struct{}{}
+			}
+		}
+	}
+
+	now := int64(time.Unix())
+	for i := 0; i < len(urls); i++ {
+		url := &urls[i]
+
+		URLsLastID++
+		url.ID = URLsLastID
+		if url.CreatedAt == 0 {
+			url.CreatedAt = now
+		}
+		URLs[paths[i]] = *url
+		IndexURL(paths[i], url)
+	}
+
+	return nil
+}
+
 func SaveURL(path string, url *URL) error {
 	URLsLock.Lock()
 
 	URLs[path] = *url
+	IndexURL(path, url)
 
 	URLsLock.Unlock()
 	return nil
 }
 
+/* DeleteURL removes link 'path' for good. Unlike setting FlagDeleted, this frees its code. */
+func DeleteURL(path string) error {
+	URLsLock.Lock()
+	defer URLsLock.Unlock()
+
+	if _, ok := URLs[path]; !ok {
+		return database.NotFound
+	}
+	delete(URLs, path)
+	URLIndex.Remove(path)
+
+	return nil
+}
+
+/* UpdateURL applies 'update' to link 'path' without losing concurrent changes of its statistics. */
+func UpdateURL(path string, update func(url *URL) error) error {
+	URLsLock.Lock()
+	defer URLsLock.Unlock()
+
+	url, ok := URLs[path]
+	if !ok {
+		return database.NotFound
+	}
+	if err := update(&url); err != nil {
+		return err
+	}
+
+	URLs[path] = url
+	IndexURL(path, &url)
+	return nil
+}
+
+/* UpdateURLs applies 'update' to all links 'paths' at once. Either all of them are changed or, if 'update' fails for any of them, none. */
+func UpdateURLs(paths []string, update func(url *URL) error) error {
+	URLsLock.Lock()
+	defer URLsLock.Unlock()
+
+	urls := make([]URL, len(paths))
+	for i, path := range paths {
+		url, ok := URLs[path]
+		if !ok {
+			return database.NotFound
+		}
+		if err := update(&url); err != nil {
+			return err
+		}
+		urls[i] = url
+	}
+
+	for i, path := range paths {
+		URLs[path] = urls[i]
+		IndexURL(path, &urls[i])
+	}
+	return nil
+}
+
+func StoreURLsToFile(filename string) error {
+	defer trace.End(trace.Begin(""))
+
+	URLsLock.RLock()
+	defer URLsLock.RUnlock()
+
+	return StoreGobToFile(filename, URLsData{LastID: URLsLastID, URLs: URLs})
+}
+
+/* DecodeURLsFromFile reads 'filename' written either by StoreURLsToFile or by versions that stored bare map of links. */
+func DecodeURLsFromFile(filename string) (URLsData, error) {
+	defer trace.End(trace.Begin(""))
+
+	var data URLsData
+	if err := RestoreGobFromFile(filename, &data); err != nil {
+		if os.IsNotExist(err) {
+			return data, err
+		}
+		data = URLsData{}
+		if err := RestoreGobFromFile(filename, &data.URLs); err != nil {
+			return data, err
+		}
+	}
+	if data.URLs == nil {
+		data.URLs = make(map[string]URL)
+	}
+
+	/* NOTE(anton2920): old files have no counter, so it continues from the largest ID. */
+	for _, url := range data.URLs {
+		data.LastID = max(data.LastID, url.ID)
+	}
+	return data, nil
+}
+
+/* RestoreURLsFromFile replaces all links with the ones from 'filename' and rebuilds search index. */
+func RestoreURLsFromFile(filename string) error {
+	defer trace.End(trace.Begin(""))
+
+	data, err := DecodeURLsFromFile(filename)
+	if err != nil {
+		return err
+	}
+
+	urls := data.URLs
+	for path, url := range urls {
+		/* NOTE(anton2920): 'encoding/gob' does not transmit empty maps, so they come back as nil. */
+		if url.RedirectCounts == nil {
+			url.RedirectCounts = make(map[int64]int64)
+		}
+		if url.RedirectFrom == nil {
+			url.RedirectFrom = make(map[string]int64)
+		}
+		/* NOTE(anton2920): revisions stored before they had numbers are numbered in order. */
+		for i := 0; i < len(url.Revisions); i++ {
+			if url.Revisions[i].Number == 0 {
+				url.Revisions[i].Number = int64(i + 1)
+			}
+		}
+		urls[path] = url
+	}
+
+	URLsLock.Lock()
+	defer URLsLock.Unlock()
+
+	URLs = urls
+	URLsLastID = data.LastID
+	for path, url := range URLs {
+		IndexURL(path, &url)
+	}
+	return nil
+}
+
+/* GetURLPathsByUserID returns paths of all links owned by user 'id' in order of their creation. */
+func GetURLPathsByUserID(id database.ID) []string {
+	URLsLock.RLock()
+	defer URLsLock.RUnlock()
+
+	var paths []string
+	for path, url := range URLs {
+		if url.UserID == id {
+			paths = append(paths, path)
+		}
+	}
+	slices.SortFunc(paths, func(a, b string) int {
+		return int(URLs[a].ID - URLs[b].ID)
+	})
+
+	return paths
+}
+
+/* GetOwnedURLFromRequest returns link 'path' if it is owned by the signed in user. */
+func GetOwnedURLFromRequest(r *http.Request, path string, url *URL) (*Session, error) {
+	defer trace.End(trace.Begin(""))
+
+	session, err := GetSessionFromRequest(r)
+	if err != nil {
+		return nil, http.UnauthorizedError
+	}
+
+	if err := GetURLByPath(path, url); err != nil {
+		if err == database.NotFound {
+			return nil, http.NotFound(Ls(GL, "shortened URL does not exist"))
+		}
+		return nil, http.ServerError(err)
+	}
+	if url.UserID != session.ID {
+		return nil, http.NotFound(Ls(GL, "shortened URL does not exist"))
+	}
+
+	return session, nil
+}
+
+func URLIsExhausted(url *URL) bool {
+	return (url.MaxRedirects > 0) && (url.Redirects >= url.MaxRedirects)
+}
+
+/* RegisterRedirect atomically checks limit of redirects for 'path' and updates its statistics. */
+func RegisterRedirect(path string, referer string, variant int, status http.Status, scan bool) error {
+	URLsLock.Lock()
+	defer URLsLock.Unlock()
+
+	url, ok := URLs[path]
+	if !ok {
+		return database.NotFound
+	}
+	if URLIsExhausted(&url) {
+		return URLExhausted
+	}
+
+	day := UnixDay(int64(time.Unix()))
+
+	url.RedirectCounts[day]++
+	url.RedirectFrom[referer]++
+	url.Redirects++
+	if scan {
+		if url.ScanCounts == nil {
+			url.ScanCounts = make(map[int64]int64)
+		}
+		url.ScanCounts[day]++
+		url.Scans++
+	}
+	if (variant >= 0) && (variant < len(url.Targets)) {
+		/* NOTE(anton2920): copies returned by GetURLByPath share the array and are read without lock, so it is replaced instead of changed. */
+		url.Targets = slices.Clone(url.Targets)
+		url.Targets[variant].Redirects++
+	}
+	if RedirectStatusPermanent(status) {
+		url.Flags |= FlagServedPermanent
+	}
+
+	URLs[path] = url
+	return nil
+}
+
+/* RandomURLPath returns new random code for the link. It may be taken already. */
+func RandomURLPath() []byte {
+	var buffer []byte
+
+	if false {
+		const shortenedLen = 7
+		buffer = make([]byte, shortenedLen)
+		SlicePutRandomBase52(buffer)
+		buffer[shortenedLen/2] = '-'
+	} else {
+		const shortenedLen = 12
+		buffer = make([]byte, shortenedLen)
+		SlicePutRandomBase26(buffer)
+		buffer[3] = '-'
+		buffer[8] = '-'
+	}
+
+	return buffer
+}
+
+/* URLTargetValid checks destination of the link provided by user. */
+func URLTargetValid(l Language, rawURL string) error {
+	if (len(rawURL) < MinURLLen) || (len(rawURL) > GetConfig().MaxURLLen) {
+		return http.BadRequest(Ls(l, "length of the URL must be between %d and %d characters"), MinURLLen, GetConfig().MaxURLLen)
+	}
+	if _, err := url.Parse(rawURL); err != nil {
+		return http.BadRequest(Ls(l, "provided URL is incorrect: %v"), err)
+	}
+	if URLTargetBlocked(rawURL) {
+		return http.BadRequest(Ls(l, "destination of the link is blocked"))
+	}
+	return nil
+}
+
 func URLCreateHandler(w *http.Response, r *http.Request) error {
 	if err := r.ParseForm(); err != nil {
 		return http.ClientError(err)
 	}
 
 	rawURL := r.Form.Get("URL")
-	if len(rawURL) == 0 {
-		return IndexPage(w, r, "", http.BadRequest("provided URL is empty"))
-	}
-	_, err := url.Parse(rawURL)
+	err := URLTargetValid(GL, rawURL)
 	if err != nil {
-		return IndexPage(w, r, "", http.BadRequest("provided URL is incorrect: %v", err))
+		return IndexPage(w, r, "", err)
+	}
+
+	password := r.Form.Get("Password")
+	if (len(password) > 0) && (!strings.LengthInRange(password, GetConfig().MinPasswordLen, GetConfig().MaxPasswordLen)) {
+		return IndexPage(w, r, "", http.BadRequest(Ls(GL, "password length must be between %d and %d characters long"), GetConfig().MinPasswordLen, GetConfig().MaxPasswordLen))
+	}
+
+	var maxRedirects int
+	if r.Form.Get("OneTime") != "" {
+		maxRedirects = 1
+	} else if m := r.Form.Get("MaxRedirects"); len(m) > 0 {
+		maxRedirects, err = strconv.Atoi(m)
+		if (err != nil) || (maxRedirects < 0) || (maxRedirects > MaxRedirectsLimit) {
+			return IndexPage(w, r, "", http.BadRequest(Ls(GL, "maximum number of redirects must be between %d and %d"), 0, MaxRedirectsLimit))
+		}
+	}
+
+	session, _ := GetSessionFromRequest(r)
+
+	utm := UTMFromForm(r)
+	if presetName := r.Form.Get("Preset"); (presetName != "") && (session != nil) {
+		var user User
+		if err := GetUserByID(session.ID, &user); err != nil {
+			return http.ServerError(err)
+		}
+		preset := FindCampaignPreset(&user, presetName)
+		if preset == nil {
+			return IndexPage(w, r, "", http.NotFound(Ls(GL, "campaign preset with this name does not exist")))
+		}
+		utm.Fill(&preset.UTM)
+	}
+	if err := UTMValid(GL, &utm); err != nil {
+		return IndexPage(w, r, "", err)
+	}
+	if !utm.Empty() {
+		rawURL, err = URLAddUTM(rawURL, &utm)
+		if err != nil {
+			return IndexPage(w, r, "", http.BadRequest("provided URL is incorrect: %v", err))
+		}
+	}
+	if presetName := r.Form.Get("PresetName"); (presetName != "") && (session != nil) {
+		if err := SaveCampaignPreset(GL, session.ID, presetName, &utm); err != nil {
+			return IndexPage(w, r, "", err)
+		}
 	}
 
 	/* TODO(anton2920): verify URL is not shortened by our system before. */
@@ -98,18 +528,7 @@ func URLCreateHandler(w *http.Response, r *http.Request) error {
 	rawURL = string(buffer)
 
 	for {
-		if false {
-			const shortenedLen = 7
-			buffer = make([]byte, shortenedLen)
-			SlicePutRandomBase52(buffer)
-			buffer[shortenedLen/2] = '-'
-		} else {
-			const shortenedLen = 12
-			buffer = make([]byte, shortenedLen)
-			SlicePutRandomBase26(buffer)
-			buffer[3] = '-'
-			buffer[8] = '-'
-		}
+		buffer = RandomURLPath()
 
 		URLsLock.RLock()
 		_, ok := URLs[unsafe.String(unsafe.SliceData(buffer), len(buffer))]
@@ -125,29 +544,231 @@ func URLCreateHandler(w *http.Response, r *http.Request) error {
 	url.RawURL = rawURL
 	url.RedirectCounts = make(map[int64]int64)
 	url.RedirectFrom = make(map[string]int64)
+	url.MaxRedirects = int64(maxRedirects)
+	url.Campaign = CloneString(utm.Campaign)
+
+	if session != nil {
+		url.UserID = session.ID
+	}
+
+	if len(password) > 0 {
+		if err := URLSetPassword(&url, password); err != nil {
+			return http.ServerError(err)
+		}
+	}
 
 	if err := CreateURL(shortened, &url); err != nil {
 		return http.ServerError(err)
 	}
+	FetchURLMetadataAsync(shortened, url.RawURL)
 
 	return IndexPage(w, r, shortened, nil)
 }
 
-func URLRedirectHandler(w *http.Response, r *http.Request, path string) error {
+/* URLChooseTarget returns destination for the visitor and index of the split variant, if any. */
+func URLChooseTarget(w *http.Response, r *http.Request, path string, url *URL, v *Visitor) (string, int) {
+	defer trace.End(trace.Begin(""))
+
+	if rule := URLMatchDeviceRule(url, v.Platform); rule >= 0 {
+		return url.DeviceRules[rule].RawURL, -1
+	}
+
+	if rule := URLMatchGeoRule(url, v); rule >= 0 {
+		return url.GeoRules[rule].RawURL, -1
+	}
+
+	if variant := URLChooseVariant(w, r, path, url); variant >= 0 {
+		return url.Targets[variant].RawURL, variant
+	}
+
+	return url.RawURL, -1
+}
+
+func URLRedirectHandler(w *http.Response, r *http.Request, path string, addr string) error {
 	var url URL
+	var extra string
 
+	path, scan := SplitScanPath(path)
 	if err := GetURLByPath(path, &url); err != nil {
-		if err == database.NotFound {
+		if err != database.NotFound {
+			return http.ServerError(err)
+		}
+
+		/* NOTE(anton2920): '/<code>/extra/path' is allowed only for links with passthrough. */
+		path, extra = SplitPassthroughPath(path)
+		if (extra == "") || (GetURLByPath(path, &url) != nil) || (url.Flags&FlagPassthrough == 0) {
+			return http.NotFound("shortened URL does not exist")
+		}
+	}
+
+	now := int64(time.Unix())
+	if reason := URLUnavailable(&url, now); reason != ReasonNone {
+		return URLUnavailableHandler(w, r, path, &url, reason, now)
+	}
+
+	if URLHasPreview(&url) {
+		/* NOTE(anton2920): the same location answers bots and people differently, so shared caches must not mix them up. */
+		w.Headers.Set("Vary", "User-Agent")
+		if IsPreviewBot(r.Headers.Get("User-Agent")) {
+			return URLPreviewPage(w, r, &url)
+		}
+	}
+
+	if len(url.PasswordHash) > 0 {
+		ok, err := URLPasswordHandler(w, r, path, addr, &url)
+		if !ok {
+			return err
+		}
+	}
+
+	visitor := VisitorFromRequest(r, addr)
+	target, variant := URLChooseTarget(w, r, path, &url, &visitor)
+	if url.Flags&FlagPassthrough == FlagPassthrough {
+		var err error
+		if target, err = URLPassthrough(target, extra, r.URL.Query, url.QueryPolicy); err != nil {
+			return http.BadRequest(Ls(GL, "failed to pass path and query to the target: %v"), err)
+		}
+	}
+	/* NOTE(anton2920): hosts may be blocked after links to them are created. */
+	if URLTargetBlocked(target) {
+		return URLBlockedPage(w, r)
+	}
+	status := URLRedirectStatus(&url)
+	if err := RegisterRedirect(path, r.Headers.Get("Referer"), variant, status, scan); err != nil {
+		switch err {
+		case URLExhausted:
+			return URLUnavailableHandler(w, r, path, &url, ReasonExhausted, now)
+		case database.NotFound:
 			return http.NotFound("shortened URL does not exist")
 		}
 		return http.ServerError(err)
 	}
-	defer SaveURL(path, &url)
 
-	now := int64(time.Unix() / 60 * 60 * 24)
-	url.RedirectCounts[now]++
-	url.RedirectFrom[r.Headers.Get("Referer")]++
+	URLRedirect(w, target, status, URLCacheable(&url))
+	return nil
+}
+
+func URLUnavailablePage(w *http.Response, r *http.Request, title string, message string) error {
+	defer trace.End(trace.Begin(""))
+
+	DisplayHTMLStart(w)
+
+	DisplayHeadStart(w)
+	{
+		w.WriteString(`<title>`)
+		w.WriteString(Ls(GL, title))
+		w.WriteString(`</title>`)
+	}
+	DisplayHeadEnd(w)
+
+	DisplayBodyStart(w)
+	{
+		w.WriteString(`<h2>`)
+		w.WriteString(Ls(GL, title))
+		w.WriteString(`</h2>`)
+
+		w.WriteString(`<p>`)
+		w.WriteString(Ls(GL, message))
+		w.WriteString(`.</p>`)
+	}
+	DisplayBodyEnd(w)
+
+	DisplayHTMLEnd(w)
+	return nil
+}
+
+func URLExhaustedPage(w *http.Response, r *http.Request) error {
+	w.StatusCode = http.StatusGone
+	return URLUnavailablePage(w, r, "Link is exhausted", "This link has reached its limit of redirects and can no longer be followed")
+}
+
+func URLPage(w *http.Response, r *http.Request, path string, ierr error) error {
+	defer trace.End(trace.Begin(""))
+
+	var url URL
+	if _, err := GetOwnedURLFromRequest(r, path, &url); err != nil {
+		return err
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return http.ClientError(err)
+	}
+
+	DisplayHTMLStart(w)
+
+	DisplayHeadStart(w)
+	{
+		w.WriteString(`<title>`)
+		w.WriteString(Ls(GL, "Link"))
+		w.WriteString(` `)
+		w.WriteString(path)
+		w.WriteString(`</title>`)
+	}
+	DisplayHeadEnd(w)
+
+	DisplayBodyStart(w)
+	{
+		w.WriteString(`<h2>`)
+		w.WriteString(Ls(GL, "Link"))
+		w.WriteString(` <a href="/`)
+		w.WriteString(path)
+		w.WriteString(`">`)
+		w.WriteString(path)
+		w.WriteString(`</a></h2>`)
+
+		DisplayError(w, GL, ierr)
+
+		w.WriteString(`<p>`)
+		w.WriteString(Ls(GL, "Target"))
+		w.WriteString(`: <a href="`)
+		w.WriteHTMLString(url.RawURL)
+		w.WriteString(`">`)
+		w.WriteHTMLString(url.RawURL)
+		w.WriteString(`</a></p>`)
+
+		DisplayURLMetadata(w, &url)
+		DisplayURLHistory(w, path, &url)
+		DisplayURLOrganizeForm(w, path, &url)
+
+		w.WriteString(`<p>`)
+		w.WriteString(Ls(GL, "Redirects"))
+		w.WriteString(`: `)
+		w.WriteInt(int(url.Redirects))
+		if url.MaxRedirects > 0 {
+			w.WriteString(` / `)
+			w.WriteInt(int(url.MaxRedirects))
+		}
+		if url.ImportedRedirects > 0 {
+			w.WriteString(` (`)
+			w.WriteString(Ls(GL, "imported"))
+			w.WriteString(`: `)
+			w.WriteInt(int(url.ImportedRedirects))
+			w.WriteString(`)`)
+		}
+		w.WriteString(`</p>`)
+
+		w.WriteString(`<p>`)
+		w.WriteString(Ls(GL, "QR code scans"))
+		w.WriteString(`: `)
+		w.WriteInt(int(url.Scans))
+		w.WriteString(`</p>`)
+		DisplayURLQR(w, path)
+
+		DisplayURLFallbacks(w, &url)
+		DisplayURLDailyStats(w, path, &url)
+
+		DisplayURLScheduleForm(w, path, &url)
+		DisplayURLFallbackForm(w, path, &url)
+		DisplayURLTargetsForm(w, path, &url)
+		DisplayURLDeviceRulesForm(w, path, &url)
+		DisplayURLGeoRulesForm(w, path, &url)
+		DisplayURLPassthroughForm(w, path, &url)
+		DisplayURLStatusForm(w, path, &url)
+		DisplayURLPreviewForm(w, path, &url)
+		DisplayURLSimulation(w, r, path, &url)
+	}
+	DisplayBodyEnd(w)
 
-	w.Redirect(url.RawURL, http.StatusSeeOther)
+	DisplayHTMLEnd(w)
 	return nil
 }
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+	stdtime "time"
+
+	"github.com/anton2920/gofa/net/http"
+	"github.com/anton2920/gofa/time"
+	"github.com/anton2920/gofa/trace"
+)
+
+/* URLBulkRow is a single line of CSV uploaded for bulk shortening: 'target,alias,tags,expiry'. */
+type URLBulkRow struct {
+	Line      int
+	RawURL    string
+	Alias     string
+	Tags      []string
+	ExpiresAt int64
+
+	Path  string
+	Error string
+}
+
+/* URLExportItem is how link is represented in exports, both CSV and JSON. */
+type URLExportItem struct {
+	Code         string   `json:"code"`
+	Target       string   `json:"target"`
+	Title        string   `json:"title"`
+	Note         string   `json:"note"`
+	Tags         []string `json:"tags"`
+	Folder       string   `json:"folder"`
+	CreatedAt    int64    `json:"created_at"`
+	ExpiresAt    int64    `json:"expires_at"`
+	MaxRedirects int64    `json:"max_redirects"`
+	Redirects    int64    `json:"redirects"`
+	Scans        int64    `json:"scans"`
+	Deleted      bool     `json:"deleted"`
+}
+
+const (
+	MaxBulkRows = 1000
+	MaxBulkLen  = MaxBulkRows * 256
+
+	MinAliasLen = 3
+	MaxAliasLen = 64
+)
+
+/* ReservedPathPrefixes are taken by pages of the service, links starting with them would never be reached. */
+var ReservedPathPrefixes = [...]string{
+	APIPrefix[1:],
+	FSPrefix[1:],
+	"error",
+	"panic",
+	"url",
+	"user",
+}
+
+/* ExpiryLayouts are formats accepted for expiry of links in uploaded files. */
+var ExpiryLayouts = [...]string{
+	stdtime.RFC3339,
+	DateTimeInputLayout,
+	"2006-01-02",
+}
+
+/* URLAliasValid checks alias chosen by user. Short aliases are easy to guess, so they are not allowed. */
+func URLAliasValid(l Language, alias string) error {
+	if (len(alias) < MinAliasLen) || (len(alias) > MaxAliasLen) {
+		return http.BadRequest(Ls(l, "length of the alias must be between %d and %d characters"), MinAliasLen, MaxAliasLen)
+	}
+	return URLPathValid(l, alias)
+}
+
+/* URLPathValid checks that link 'path' could be reached. */
+func URLPathValid(l Language, alias string) error {
+	if (len(alias) == 0) || (len(alias) > MaxAliasLen) {
+		return http.BadRequest(Ls(l, "length of the alias must be between %d and %d characters"), 1, MaxAliasLen)
+	}
+	for i := 0; i < len(alias); i++ {
+		c := alias[i]
+		if ((c < 'a') || (c > 'z')) && ((c < 'A') || (c > 'Z')) && ((c < '0') || (c > '9')) && (c != '-') && (c != '_') {
+			return http.BadRequest(Ls(l, "alias may contain only latin letters, digits, '-' and '_'"))
+		}
+	}
+	for _, prefix := range ReservedPathPrefixes {
+		if strings.HasPrefix(alias, prefix) {
+			return http.BadRequest(Ls(l, "alias must not start with '%s'"), prefix)
+		}
+	}
+	return nil
+}
+
+/* ParseExpiry parses time in one of ExpiryLayouts. Empty value results in zero. */
+func ParseExpiry(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	var err error
+	for _, layout := range ExpiryLayouts {
+		var t stdtime.Time
+		if t, err = stdtime.ParseInLocation(layout, value, stdtime.Local); err == nil {
+			return t.Unix(), nil
+		}
+	}
+	return 0, err
+}
+
+func URLBulkRowValid(l Language, row *URLBulkRow) error {
+	if err := URLTargetValid(l, row.RawURL); err != nil {
+		return err
+	}
+	if row.Alias != "" {
+		if err := URLAliasValid(l, row.Alias); err != nil {
+			return err
+		}
+		var u URL
+		if GetURLByPath(row.Alias, &u) == nil {
+			return http.Conflict(Ls(l, "alias is already taken"))
+		}
+	}
+	return nil
+}
+
+/* ParseURLBulkCSV reads rows of uploaded file. Errors of individual rows are stored in them, returned error means the file itself is broken. */
+func ParseURLBulkCSV(l Language, data string) ([]URLBulkRow, bool, error) {
+	defer trace.End(trace.Begin(""))
+
+	if len(data) > MaxBulkLen {
+		return nil, false, http.BadRequest(Ls(l, "file must not exceed %d bytes"), MaxBulkLen)
+	}
+
+	reader := csv.NewReader(strings.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var rows []URLBulkRow
+	var failed bool
+	aliases := make(map[string]int)
+	now := int64(time.Unix())
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, false, http.BadRequest(Ls(l, "failed to parse CSV: %v"), err)
+		}
+		line, _ := reader.FieldPos(0)
+
+		/* NOTE(anton2920): header is optional, it is recognized by its first column. */
+		if (len(rows) == 0) && (strings.EqualFold(strings.TrimSpace(record[0]), "target")) {
+			continue
+		}
+		if len(rows) == MaxBulkRows {
+			return nil, false, http.BadRequest(Ls(l, "number of rows must not exceed %d"), MaxBulkRows)
+		}
+
+		var fields [4]string
+		for i := 0; (i < len(record)) && (i < len(fields)); i++ {
+			fields[i] = strings.TrimSpace(record[i])
+		}
+
+		row := URLBulkRow{Line: line, RawURL: fields[0], Alias: fields[1]}
+		err = URLBulkRowValid(l, &row)
+		if err == nil {
+			row.Tags, err = ParseTags(l, fields[2])
+		}
+		if err == nil {
+			if row.ExpiresAt, err = ParseExpiry(fields[3]); err != nil {
+				err = http.BadRequest(Ls(l, "expiry must be in format YYYY-MM-DD, YYYY-MM-DDTHH:MM or RFC 3339"))
+			} else if (row.ExpiresAt != 0) && (row.ExpiresAt <= now) {
+				err = http.BadRequest(Ls(l, "expiry must be in the future"))
+			}
+		}
+		if (err == nil) && (row.Alias != "") {
+			if prev, ok := aliases[row.Alias]; ok {
+				err = http.Conflict(Ls(l, "alias is used on line %d already"), prev)
+			} else {
+				aliases[row.Alias] = line
+			}
+		}
+		if err != nil {
+			row.Error = ErrorMessage(err)
+			failed = true
+		}
+
+		rows = append(rows, row)
+	}
+	if len(rows) == 0 {
+		return nil, false, http.BadRequest(Ls(l, "file does not contain any links"))
+	}
+
+	return rows, failed, nil
+}
+
+func WriteURLBulkResults(w *http.Response, r *http.Request, rows []URLBulkRow) error {
+	var buf bytes.Buffer
+
+	base, _, err := URLBaseFromRequest(r)
+	if err != nil {
+		return err
+	}
+
+	writer := csv.NewWriter(&buf)
+	writer.Write([]string{"line", "target", "alias", "code", "short_url", "error"})
+	for i := 0; i < len(rows); i++ {
+		row := &rows[i]
+
+		var short string
+		if row.Path != "" {
+			short = base + "/" + row.Path
+		}
+		writer.Write([]string{strconv.Itoa(row.Line), row.RawURL, row.Alias, row.Path, short, row.Error})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return http.ServerError(err)
+	}
+
+	w.Headers.Set("Content-Type", "text/csv; charset=utf-8")
+	w.Headers.Set("Content-Disposition", `attachment; filename="links.csv"`)
+	w.Write(buf.Bytes())
+	return nil
+}
+
+func URLBulkPage(w *http.Response, r *http.Request, ierr error) error {
+	defer trace.End(trace.Begin(""))
+
+	const title = "Bulk shortening"
+
+	session, err := GetSessionFromRequest(r)
+	if err != nil {
+		return http.UnauthorizedError
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return http.ClientError(err)
+	}
+
+	DisplayHTMLStart(w)
+
+	DisplayHeadStart(w)
+	{
+		w.WriteString(`<title>`)
+		w.WriteString(Ls(GL, title))
+		w.WriteString(`</title>`)
+	}
+	DisplayHeadEnd(w)
+
+	DisplayBodyStart(w)
+	{
+		w.WriteString(`<h2>`)
+		w.WriteString(Ls(GL, title))
+		w.WriteString(`</h2>`)
+
+		w.WriteString(`<a href="/user/`)
+		w.WriteID(session.ID)
+		w.WriteString(`">`)
+		w.WriteString(Ls(GL, "Back"))
+		w.WriteString(`</a>`)
+		w.WriteString(`<br><br>`)
+
+		DisplayError(w, GL, ierr)
+
+		w.WriteString(`<p>`)
+		w.WriteString(Ls(GL, "One link per line as 'target,alias,tags,expiry'. Only target is required, tags are comma-separated inside quotes, expiry is YYYY-MM-DD. Either all links are created or none of them, results are returned as CSV"))
+		w.WriteString(`.</p>`)
+
+		w.WriteString(`<pre>target,alias,tags,expiry` + "\n" + `https://example.com/sale,sale-2026,"promo, winter",2026-12-31` + "\n" + `https://example.com/about,,,</pre>`)
+
+		w.WriteString(`<form method="POST" action="` + APIPrefix + `/url/bulk">`)
+		{
+			DisplayTextarea(w, "CSV", 16, r.Form.Get("CSV"))
+			w.WriteString(`<br><br>`)
+
+			DisplaySubmit(w, GL, "", "Shorten!")
+		}
+		w.WriteString(`</form>`)
+	}
+	DisplayBodyEnd(w)
+
+	DisplayHTMLEnd(w)
+	return nil
+}
+
+func URLBulkHandler(w *http.Response, r *http.Request) error {
+	defer trace.End(trace.Begin(""))
+
+	session, err := GetSessionFromRequest(r)
+	if err != nil {
+		return http.UnauthorizedError
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return http.ClientError(err)
+	}
+
+	rows, failed, err := ParseURLBulkCSV(GL, r.Form.Get("CSV"))
+	if err != nil {
+		return URLBulkPage(w, r, err)
+	}
+	if failed {
+		w.StatusCode = http.StatusBadRequest
+		return WriteURLBulkResults(w, r, rows)
+	}
+
+	paths := make([]string, len(rows))
+	urls := make([]URL, len(rows))
+	for i := 0; i < len(rows); i++ {
+		row := &rows[i]
+		url := &urls[i]
+
+		paths[i] = row.Alias
+		url.UserID = session.ID
+		url.RawURL = row.RawURL
+		url.ExpiresAt = row.ExpiresAt
+		url.Tags = row.Tags
+		url.RedirectCounts = make(map[int64]int64)
+		url.RedirectFrom = make(map[string]int64)
+	}
+
+	if err := CreateURLs(paths, urls); err != nil {
+		if err == URLPathTaken {
+			return URLBulkPage(w, r, http.Conflict(Ls(GL, "some of the aliases have been taken while links were created, try again")))
+		}
+		return http.ServerError(err)
+	}
+	for i := 0; i < len(rows); i++ {
+		rows[i].Path = paths[i]
+	}
+	FetchURLsMetadataAsync(paths, urls)
+
+	return WriteURLBulkResults(w, r, rows)
+}
+
+func URLToExportItem(path string, url *URL) URLExportItem {
+	return URLExportItem{
+		Code:         path,
+		Target:       url.RawURL,
+		Title:        url.Title,
+		Note:         url.Note,
+		Tags:         url.Tags,
+		Folder:       url.Folder,
+		CreatedAt:    url.CreatedAt,
+		ExpiresAt:    url.ExpiresAt,
+		MaxRedirects: url.MaxRedirects,
+		Redirects:    url.Redirects,
+		Scans:        url.Scans,
+		Deleted:      url.Flags&FlagDeleted == FlagDeleted,
+	}
+}
+
+func EncodeURLExportCSV(w io.Writer, items []URLExportItem) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"code", "target", "title", "note", "tags", "folder", "created_at", "expires_at", "max_redirects", "redirects", "scans", "deleted"})
+	for i := 0; i < len(items); i++ {
+		item := &items[i]
+		writer.Write([]string{
+			item.Code,
+			item.Target,
+			item.Title,
+			item.Note,
+			strings.Join(item.Tags, ","),
+			item.Folder,
+			strconv.FormatInt(item.CreatedAt, 10),
+			strconv.FormatInt(item.ExpiresAt, 10),
+			strconv.FormatInt(item.MaxRedirects, 10),
+			strconv.FormatInt(item.Redirects, 10),
+			strconv.FormatInt(item.Scans, 10),
+			strconv.FormatBool(item.Deleted),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func WriteURLExportCSV(w *http.Response, items []URLExportItem) error {
+	var buf bytes.Buffer
+
+	if err := EncodeURLExportCSV(&buf, items); err != nil {
+		return http.ServerError(err)
+	}
+
+	w.Headers.Set("Content-Type", "text/csv; charset=utf-8")
+	w.Headers.Set("Content-Disposition", `attachment; filename="links.csv"`)
+	w.Write(buf.Bytes())
+	return nil
+}
+
+/* URLExportHandler returns all links of the signed in user with their statistics. 'Format' is either 'csv' or 'json'. */
+func URLExportHandler(w *http.Response, r *http.Request) error {
+	defer trace.End(trace.Begin(""))
+
+	session, err := GetSessionFromRequest(r)
+	if err != nil {
+		return http.UnauthorizedError
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return http.ClientError(err)
+	}
+
+	items := make([]URLExportItem, 0)
+	var url URL
+	for _, path := range GetURLPathsByUserID(session.ID) {
+		if GetURLByPath(path, &url) == nil {
+			items = append(items, URLToExportItem(path, &url))
+		}
+	}
+
+	switch r.Form.Get("Format") {
+	default:
+		return http.BadRequest(Ls(GL, "format must be either 'csv' or 'json'"))
+	case "", "csv":
+		return WriteURLExportCSV(w, items)
+	case "json":
+		w.Headers.Set("Content-Disposition", `attachment; filename="links.json"`)
+		return WriteJSON(w, items)
+	}
+}
+
+func DisplayUserBulkLinks(w *http.Response) {
+	w.WriteString(`<p><a href="/url/bulk">`)
+	w.WriteString(Ls(GL, "Bulk shortening"))
+	w.WriteString(`</a> <a href="/url/import">`)
+	w.WriteString(Ls(GL, "Import links"))
+	w.WriteString(`</a> `)
+	w.WriteString(Ls(GL, "Export"))
+	w.WriteString(`: <a href="` + APIPrefix + `/url/export?Format=csv">CSV</a> <a href="` + APIPrefix + `/url/export?Format=json">JSON</a></p>`)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseURLBulkCSV(t *testing.T) {
+	CurrentBlocklist.Store(&Blocklist{Hosts: map[string]struct{}{"blocked.example": {}}})
+	defer CurrentBlocklist.Store(nil)
+
+	URLsLock.Lock()
+	URLs["taken"] = URL{RawURL: "https://example.com"}
+	URLsLock.Unlock()
+	defer func() {
+		URLsLock.Lock()
+		delete(URLs, "taken")
+		URLsLock.Unlock()
+	}()
+
+	tests := [...]struct {
+		Name  string
+		Data  string
+		Lines []int
+		Error []bool
+	}{
+		{"header", "target,alias,tags,expiry\nhttps://example.com,,,\n", []int{2}, []bool{false}},
+		{"header in other case", " Target ,alias\nhttps://example.com\n", []int{2}, []bool{false}},
+		{"no header", "https://example.com\nhttps://example.org,my-alias,a,2999-01-01\n", []int{1, 2}, []bool{false, false}},
+		{"invalid URL", "://example.com\n", []int{1}, []bool{true}},
+		{"blocked URL", "https://www.blocked.example/path\n", []int{1}, []bool{true}},
+		{"long URL", "https://example.com/" + strings.Repeat("a", GetConfig().MaxURLLen) + "\n", []int{1}, []bool{true}},
+		{"short alias", "https://example.com,ab\n", []int{1}, []bool{true}},
+		{"bad alias", "https://example.com,my alias\n", []int{1}, []bool{true}},
+		{"reserved alias", "https://example.com,user-page\n", []int{1}, []bool{true}},
+		{"taken alias", "https://example.com,taken\n", []int{1}, []bool{true}},
+		{"duplicate alias", "https://example.com,abc\nhttps://example.org,abc\nhttps://example.net,abd\n", []int{1, 2, 3}, []bool{false, true, false}},
+		{"long tag", "https://example.com,," + strings.Repeat("t", MaxTagLen+1) + "\n", []int{1}, []bool{true}},
+		{"bad expiry", "https://example.com,,,tomorrow\n", []int{1}, []bool{true}},
+		{"past expiry", "https://example.com,,,2000-01-01\n", []int{1}, []bool{true}},
+		{"future expiry", "https://example.com,,,2999-01-01T10:00\n", []int{1}, []bool{false}},
+	}
+	for _, test := range tests {
+		rows, failed, err := ParseURLBulkCSV(GL, test.Data)
+		if err != nil {
+			t.Errorf("%s: ParseURLBulkCSV() failed: %v", test.Name, err)
+			continue
+		}
+		if len(rows) != len(test.Lines) {
+			t.Errorf("%s: ParseURLBulkCSV() returned %d rows, expected %d", test.Name, len(rows), len(test.Lines))
+			continue
+		}
+
+		var expectedFailed bool
+		for i := 0; i < len(rows); i++ {
+			if rows[i].Line != test.Lines[i] {
+				t.Errorf("%s: row %d has line %d, expected %d", test.Name, i, rows[i].Line, test.Lines[i])
+			}
+			if (rows[i].Error != "") != test.Error[i] {
+				t.Errorf("%s: row %d has error %q, expected error %v", test.Name, i, rows[i].Error, test.Error[i])
+			}
+			expectedFailed = expectedFailed || test.Error[i]
+		}
+		if failed != expectedFailed {
+			t.Errorf("%s: ParseURLBulkCSV() reported failure %v, expected %v", test.Name, failed, expectedFailed)
+		}
+	}
+}
+
+func TestParseURLBulkCSVRow(t *testing.T) {
+	rows, _, err := ParseURLBulkCSV(GL, "https://example.com/path , My_Alias-1 , \"b,A,a\", 2999-01-01,extra\n")
+	if err != nil {
+		t.Fatalf("ParseURLBulkCSV() failed: %v", err)
+	}
+	row := rows[0]
+	if (row.RawURL != "https://example.com/path") || (row.Alias != "My_Alias-1") || (strings.Join(row.Tags, ",") != "a,b") || (row.ExpiresAt == 0) || (row.Error != "") {
+		t.Errorf("ParseURLBulkCSV() = %+v", row)
+	}
+}
+
+func TestParseURLBulkCSVFile(t *testing.T) {
+	tests := [...]struct {
+		Name string
+		Data string
+	}{
+		{"empty", ""},
+		{"only header", "target,alias,tags,expiry\n"},
+		{"broken quotes", "\"https://example.com\n"},
+		{"too many rows", strings.Repeat("https://example.com\n", MaxBulkRows+1)},
+		{"too long", strings.Repeat("a", MaxBulkLen+1)},
+	}
+	for _, test := range tests {
+		if _, _, err := ParseURLBulkCSV(GL, test.Data); err == nil {
+			t.Errorf("%s: ParseURLBulkCSV() succeeded, expected error", test.Name)
+		}
+	}
+
+	rows, _, err := ParseURLBulkCSV(GL, "target\n"+strings.Repeat("https://example.com\n", MaxBulkRows))
+	if err != nil {
+		t.Errorf("ParseURLBulkCSV() of %d rows failed: %v", MaxBulkRows, err)
+	} else if len(rows) != MaxBulkRows {
+		t.Errorf("ParseURLBulkCSV() returned %d rows, expected %d", len(rows), MaxBulkRows)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"net/url"
+	"strings"
+
+	"github.com/anton2920/gofa/net/http"
+	"github.com/anton2920/gofa/trace"
+)
+
+/* Platform is the operating system of a visitor or a group of them, as used by routing rules. */
+type Platform int32
+
+const (
+	PlatformUnknown Platform = iota
+	PlatformAndroid
+	PlatformIOS
+	PlatformLinux
+	PlatformMacOS
+	PlatformWindows
+
+	/* Groups of platforms. */
+	PlatformDesktop
+	PlatformMobile
+	PlatformCount
+)
+
+var Platform2String = [...]string{
+	PlatformUnknown: "unknown",
+	PlatformAndroid: "android",
+	PlatformIOS:     "ios",
+	PlatformLinux:   "linux",
+	PlatformMacOS:   "macos",
+	PlatformWindows: "windows",
+	PlatformDesktop: "desktop",
+	PlatformMobile:  "mobile",
+}
+
+/* URLDeviceRule redirects visitors using platform to RawURL. */
+type URLDeviceRule struct {
+	Platform Platform
+	RawURL   string
+}
+
+const MaxDeviceRules = 16
+
+func (p Platform) String() string {
+	return Platform2String[p]
+}
+
+/* Matches returns true if platform of a visitor 'v' belongs to 'p'. */
+func (p Platform) Matches(v Platform) bool {
+	switch p {
+	case PlatformDesktop:
+		return (v == PlatformLinux) || (v == PlatformMacOS) || (v == PlatformWindows)
+	case PlatformMobile:
+		return (v == PlatformAndroid) || (v == PlatformIOS)
+	}
+	return p == v
+}
+
+func ParsePlatform(s string) (Platform, bool) {
+	for p := PlatformUnknown + 1; p < PlatformCount; p++ {
+		if strings.EqualFold(s, Platform2String[p]) {
+			return p, true
+		}
+	}
+	return PlatformUnknown, false
+}
+
+/* PlatformFromUserAgent makes best guess about visitor's platform from 'User-Agent' header. */
+func PlatformFromUserAgent(ua string) Platform {
+	defer trace.End(trace.Begin(""))
+
+	/* NOTE(anton2920): order matters, iOS user agents say 'like Mac OS X' and Android ones say 'Linux'. */
+	switch {
+	case strings.Contains(ua, "iPhone"), strings.Contains(ua, "iPad"), strings.Contains(ua, "iPod"):
+		return PlatformIOS
+	case strings.Contains(ua, "Android"):
+		return PlatformAndroid
+	case strings.Contains(ua, "Windows"):
+		return PlatformWindows
+	case strings.Contains(ua, "Macintosh"), strings.Contains(ua, "Mac OS X"):
+		return PlatformMacOS
+	case strings.Contains(ua, "Linux"), strings.Contains(ua, "X11"), strings.Contains(ua, "CrOS"):
+		return PlatformLinux
+	}
+	return PlatformUnknown
+}
+
+/* URLMatchDeviceRule returns index of the first rule that matches 'platform' or -1. */
+func URLMatchDeviceRule(url *URL, platform Platform) int {
+	for i := 0; i < len(url.DeviceRules); i++ {
+		if url.DeviceRules[i].Platform.Matches(platform) {
+			return i
+		}
+	}
+	return -1
+}
+
+/* ParseURLDeviceRules parses lines of the form '<platform> <URL>'. */
+func ParseURLDeviceRules(l Language, s string) ([]URLDeviceRule, error) {
+	var rules []URLDeviceRule
+
+	sc := bufio.NewScanner(strings.NewReader(s))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+
+		platformString, rawURL, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, http.BadRequest(Ls(l, "rule must be specified as platform followed by URL: %q"), line)
+		}
+		rawURL = strings.TrimSpace(rawURL)
+
+		platform, ok := ParsePlatform(platformString)
+		if !ok {
+			return nil, http.BadRequest(Ls(l, "unknown platform %q"), platformString)
+		}
+		if (len(rawURL) == 0) || (len(rawURL) > GetConfig().MaxURLLen) {
+			return nil, http.BadRequest(Ls(l, "length of the URL must be between %d and %d characters"), MinURLLen, GetConfig().MaxURLLen)
+		}
+		if _, err := url.Parse(rawURL); err != nil {
+			return nil, http.BadRequest(Ls(l, "provided URL is incorrect: %v"), err)
+		}
+
+		if len(rules) == MaxDeviceRules {
+			return nil, http.BadRequest(Ls(l, "number of rules must not exceed %d"), MaxDeviceRules)
+		}
+		rules = append(rules, URLDeviceRule{Platform: platform, RawURL: CloneString(rawURL)})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, http.ClientError(err)
+	}
+
+	return rules, nil
+}
+
+func DisplayURLDeviceRulesForm(w *http.Response, path string, url *URL) {
+	w.WriteString(`<h3>`)
+	w.WriteString(Ls(GL, "Device routing"))
+	w.WriteString(`</h3>`)
+
+	w.WriteString(`<form method="POST" action="` + APIPrefix + `/url/devices">`)
+	{
+		DisplayHiddenInput(w, "Path", path)
+
+		var buf strings.Builder
+		for i := 0; i < len(url.DeviceRules); i++ {
+			buf.WriteString(url.DeviceRules[i].Platform.String())
+			buf.WriteString(" ")
+			buf.WriteString(url.DeviceRules[i].RawURL)
+			buf.WriteString("\n")
+		}
+
+		DisplayLabel(w, GL, "Rules, one per line as 'platform URL'")
+		DisplayTextarea(w, "DeviceRules", MaxDeviceRules/2, buf.String())
+		w.WriteString(`<br>`)
+
+		w.WriteString(Ls(GL, "Platforms"))
+		w.WriteString(`:`)
+		for p := PlatformUnknown + 1; p < PlatformCount; p++ {
+			w.WriteString(` <code>`)
+			w.WriteString(p.String())
+			w.WriteString(`</code>`)
+		}
+		w.WriteString(`. `)
+		w.WriteString(Ls(GL, "Visitors that match no rule follow the default target"))
+		w.WriteString(`.<br><br>`)
+
+		DisplaySubmit(w, GL, "", "Save")
+	}
+	w.WriteString(`</form>`)
+}
+
+func URLDeviceRulesHandler(w *http.Response, r *http.Request) error {
+	defer trace.End(trace.Begin(""))
+
+	if err := r.ParseForm(); err != nil {
+		return http.ClientError(err)
+	}
+
+	path := r.Form.Get("Path")
+
+	var u URL
+	if _, err := GetOwnedURLFromRequest(r, path, &u); err != nil {
+		return err
+	}
+
+	rules, err := ParseURLDeviceRules(GL, r.Form.Get("DeviceRules"))
+	if err != nil {
+		return URLPage(w, r, path, err)
+	}
+
+	if err := UpdateURL(path, func(u *URL) error {
+		u.DeviceRules = rules
+		return nil
+	}); err != nil {
+		return http.ServerError(err)
+	}
+
+	w.Redirect("/url/"+path, http.StatusSeeOther)
+	return nil
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+
+	"github.com/anton2920/gofa/database"
+	"github.com/anton2920/gofa/net/http"
+	"github.com/anton2920/gofa/trace"
+)
+
+/* URLUnavailableReason explains why link cannot redirect to its primary destination. */
+type URLUnavailableReason int32
+
+const (
+	ReasonNone URLUnavailableReason = iota
+	ReasonDeleted
+	ReasonExpired
+	ReasonExhausted
+	ReasonScheduled
+	ReasonCount
+)
+
+var URLUnavailableReason2String = [...]string{
+	ReasonNone:      "None",
+	ReasonDeleted:   "Deleted",
+	ReasonExpired:   "Expired",
+	ReasonExhausted: "Exhausted",
+	ReasonScheduled: "Outside of schedule",
+}
+
+func (reason URLUnavailableReason) String() string {
+	return URLUnavailableReason2String[reason]
+}
+
+/* URLUnavailable returns the reason link cannot be followed at time 'now' or 'ReasonNone'. */
+func URLUnavailable(url *URL, now int64) URLUnavailableReason {
+	switch {
+	case url.Flags&FlagDeleted == FlagDeleted:
+		return ReasonDeleted
+	case (url.ExpiresAt != 0) && (now > url.ExpiresAt):
+		return ReasonExpired
+	case URLIsExhausted(url):
+		return ReasonExhausted
+	case !URLIsScheduled(url, now):
+		return ReasonScheduled
+	}
+	return ReasonNone
+}
+
+/* GetURLFallback returns destination for unavailable link: its own fallback, then owner's default, then server's default. */
+func GetURLFallback(url *URL) string {
+	defer trace.End(trace.Begin(""))
+
+	if url.FallbackURL != "" {
+		return url.FallbackURL
+	}
+
+	var user User
+	if (url.UserID != 0) && (GetUserByID(url.UserID, &user) == nil) && (user.FallbackURL != "") {
+		return user.FallbackURL
+	}
+
+	return GetConfig().DefaultFallbackURL
+}
+
+func RegisterFallback(path string, reason URLUnavailableReason) error {
+	return UpdateURL(path, func(url *URL) error {
+		url.Fallbacks[reason]++
+		return nil
+	})
+}
+
+func URLUnavailableHandler(w *http.Response, r *http.Request, path string, url *URL, reason URLUnavailableReason, now int64) error {
+	defer trace.End(trace.Begin(""))
+
+	if err := RegisterFallback(path, reason); err != nil {
+		return http.ServerError(err)
+	}
+
+	target := GetURLFallback(url)
+	if (reason == ReasonScheduled) && (url.ScheduleURL != "") {
+		target = url.ScheduleURL
+	}
+	if target != "" {
+		/* NOTE(anton2920): hosts may be blocked after fallbacks to them are saved. */
+		if URLTargetBlocked(target) {
+			return URLBlockedPage(w, r)
+		}
+		w.Redirect(target, http.StatusSeeOther)
+		return nil
+	}
+
+	switch reason {
+	case ReasonExhausted:
+		return URLExhaustedPage(w, r)
+	case ReasonScheduled:
+		return URLComingSoonPage(w, r, url, now)
+	}
+
+	w.StatusCode = http.StatusGone
+	return URLUnavailablePage(w, r, "Link is no longer available", "This link has expired or was deleted by its owner")
+}
+
+func DisplayURLFallbackForm(w *http.Response, path string, url *URL) {
+	w.WriteString(`<h3>`)
+	w.WriteString(Ls(GL, "Availability"))
+	w.WriteString(`</h3>`)
+
+	w.WriteString(`<form method="POST" action="` + APIPrefix + `/url/fallback">`)
+	{
+		DisplayHiddenInput(w, "Path", path)
+
+		DisplayLabel(w, GL, "Expires at")
+		DisplayDateTimeInput(w, "ExpiresAt", url.ExpiresAt, false)
+		w.WriteString(`<br><br>`)
+
+		DisplayLabel(w, GL, "Fallback URL (optional)")
+		DisplayConstraintInput(w, "text", 0, GetConfig().MaxURLLen, "FallbackURL", url.FallbackURL, false)
+		w.WriteString(`<br><br>`)
+
+		DisplaySubmit(w, GL, "", "Save")
+	}
+	w.WriteString(`</form>`)
+
+	w.WriteString(`<form method="POST" action="` + APIPrefix + `/url/delete">`)
+	{
+		DisplayHiddenInput(w, "Path", path)
+		DisplaySubmit(w, GL, "", "Delete link")
+	}
+	w.WriteString(`</form>`)
+}
+
+func DisplayURLFallbacks(w *http.Response, url *URL) {
+	w.WriteString(`<p>`)
+	w.WriteString(Ls(GL, "Unavailable"))
+	w.WriteString(`: `)
+	for reason := ReasonNone + 1; reason < ReasonCount; reason++ {
+		if reason > ReasonNone+1 {
+			w.WriteString(`, `)
+		}
+		w.WriteString(Ls(GL, reason.String()))
+		w.WriteString(` &mdash; `)
+		w.WriteInt(int(url.Fallbacks[reason]))
+	}
+	w.WriteString(`</p>`)
+}
+
+/* URLFallbackValid checks fallback URL provided by user. Empty URL is valid and means 'no fallback'. */
+func URLFallbackValid(l Language, fallback string) error {
+	if len(fallback) > GetConfig().MaxURLLen {
+		return http.BadRequest(Ls(l, "length of the URL must not exceed %d characters"), GetConfig().MaxURLLen)
+	}
+	if len(fallback) > 0 {
+		if _, err := url.Parse(fallback); err != nil {
+			return http.BadRequest(Ls(l, "provided URL is incorrect: %v"), err)
+		}
+		if URLTargetBlocked(fallback) {
+			return http.BadRequest(Ls(l, "destination of the link is blocked"))
+		}
+	}
+	return nil
+}
+
+func URLFallbackHandler(w *http.Response, r *http.Request) error {
+	defer trace.End(trace.Begin(""))
+
+	if err := r.ParseForm(); err != nil {
+		return http.ClientError(err)
+	}
+
+	path := r.Form.Get("Path")
+
+	var u URL
+	if _, err := GetOwnedURLFromRequest(r, path, &u); err != nil {
+		return err
+	}
+
+	expiresAt, err := ParseDateTimeInput(r.Form.Get("ExpiresAt"))
+	if err != nil {
+		return URLPage(w, r, path, http.BadRequest(Ls(GL, "provided expiration time is incorrect")))
+	}
+
+	fallback := r.Form.Get("FallbackURL")
+	if err := URLFallbackValid(GL, fallback); err != nil {
+		return URLPage(w, r, path, err)
+	}
+
+	if err := UpdateURL(path, func(u *URL) error {
+		u.ExpiresAt = expiresAt
+		u.FallbackURL = CloneString(fallback)
+		return nil
+	}); err != nil {
+		return http.ServerError(err)
+	}
+
+	w.Redirect("/url/"+path, http.StatusSeeOther)
+	return nil
+}
+
+func URLDeleteHandler(w *http.Response, r *http.Request) error {
+	defer trace.End(trace.Begin(""))
+
+	if err := r.ParseForm(); err != nil {
+		return http.ClientError(err)
+	}
+
+	path := r.Form.Get("Path")
+
+	var u URL
+	session, err := GetOwnedURLFromRequest(r, path, &u)
+	if err != nil {
+		return err
+	}
+
+	if err := UpdateURL(path, func(u *URL) error {
+		u.Flags |= FlagDeleted
+		return nil
+	}); err != nil {
+		return http.ServerError(err)
+	}
+
+	w.Redirect("/user/"+strconv.Itoa(int(session.ID)), http.StatusSeeOther)
+	return nil
+}
+
+func UserFallbackHandler(w *http.Response, r *http.Request) error {
+	defer trace.End(trace.Begin(""))
+
+	session, err := GetSessionFromRequest(r)
+	if err != nil {
+		return http.UnauthorizedError
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return http.ClientError(err)
+	}
+
+	fallback := r.Form.Get("FallbackURL")
+	if err := URLFallbackValid(GL, fallback); err != nil {
+		return err
+	}
+
+	var user User
+	if err := GetUserByID(session.ID, &user); err != nil {
+		if err == database.NotFound {
+			return http.NotFound(Ls(GL, "user with this ID does not exist"))
+		}
+		return http.ServerError(err)
+	}
+
+	user.FallbackURL = CloneString(fallback)
+	if err := SaveUser(&user); err != nil {
+		return http.ServerError(err)
+	}
+
+	w.Redirect("/user/"+strconv.Itoa(int(session.ID)), http.StatusSeeOther)
+	return nil
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bufio"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/anton2920/gofa/net/http"
+	"github.com/anton2920/gofa/trace"
+)
+
+type GeoRuleKind int32
+
+const (
+	GeoRuleCountry GeoRuleKind = iota
+	GeoRuleLanguage
+	GeoRuleDefault
+	GeoRuleCount
+)
+
+var GeoRuleKind2String = [...]string{
+	GeoRuleCountry:  "country",
+	GeoRuleLanguage: "language",
+	GeoRuleDefault:  "default",
+}
+
+/* URLGeoRule redirects visitors from one of the countries or speaking one of the languages in Values to RawURL. */
+type URLGeoRule struct {
+	Kind   GeoRuleKind
+	Values []string
+	RawURL string
+}
+
+const (
+	MaxGeoRules      = 32
+	MaxGeoRuleValues = 64
+)
+
+func (kind GeoRuleKind) String() string {
+	return GeoRuleKind2String[kind]
+}
+
+/* Matches returns true if visitor 'v' satisfies the rule. */
+func (rule *URLGeoRule) Matches(v *Visitor) bool {
+	switch rule.Kind {
+	case GeoRuleCountry:
+		return (v.Country != "") && (slices.Contains(rule.Values, v.Country))
+	case GeoRuleLanguage:
+		if len(v.Languages) == 0 {
+			return false
+		}
+
+		/* NOTE(anton2920): only the most preferred language is considered, otherwise almost everyone matches 'en'. */
+		language := v.Languages[0]
+		for _, prefix := range rule.Values {
+			if (language == prefix) || ((strings.HasPrefix(language, prefix)) && (language[len(prefix)] == '-')) {
+				return true
+			}
+		}
+		return false
+	case GeoRuleDefault:
+		return true
+	}
+	return false
+}
+
+/* URLMatchGeoRule returns index of the first rule that matches visitor 'v' or -1. */
+func URLMatchGeoRule(url *URL, v *Visitor) int {
+	for i := 0; i < len(url.GeoRules); i++ {
+		if url.GeoRules[i].Matches(v) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (rule *URLGeoRule) String() string {
+	var buf strings.Builder
+
+	buf.WriteString(rule.Kind.String())
+	buf.WriteString(" ")
+	if rule.Kind != GeoRuleDefault {
+		buf.WriteString(strings.Join(rule.Values, ","))
+		buf.WriteString(" ")
+	}
+	buf.WriteString(rule.RawURL)
+
+	return buf.String()
+}
+
+/* ParseURLGeoRules parses lines of the form 'country <CC,...> <URL>', 'language <prefix,...> <URL>' or 'default <URL>'. */
+func ParseURLGeoRules(l Language, s string) ([]URLGeoRule, error) {
+	var rules []URLGeoRule
+
+	sc := bufio.NewScanner(strings.NewReader(s))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		var rule URLGeoRule
+		switch strings.ToLower(fields[0]) {
+		default:
+			return nil, http.BadRequest(Ls(l, "unknown kind of rule %q"), fields[0])
+		case "country", "language":
+			if len(fields) != 3 {
+				return nil, http.BadRequest(Ls(l, "rule must be specified as kind, comma-separated values and URL: %q"), sc.Text())
+			}
+			values := strings.Split(fields[1], ",")
+			if len(values) > MaxGeoRuleValues {
+				return nil, http.BadRequest(Ls(l, "number of values in rule must not exceed %d"), MaxGeoRuleValues)
+			}
+
+			if strings.ToLower(fields[0]) == "country" {
+				rule.Kind = GeoRuleCountry
+				for i := 0; i < len(values); i++ {
+					values[i] = strings.ToUpper(values[i])
+					if len(values[i]) != 2 {
+						return nil, http.BadRequest(Ls(l, "country must be specified as two-letter code: %q"), values[i])
+					}
+				}
+			} else {
+				rule.Kind = GeoRuleLanguage
+				for i := 0; i < len(values); i++ {
+					values[i] = strings.ToLower(values[i])
+					if len(values[i]) == 0 {
+						return nil, http.BadRequest(Ls(l, "language must not be empty"))
+					}
+				}
+			}
+			rule.Values = values
+			rule.RawURL = fields[2]
+		case "default":
+			if len(fields) != 2 {
+				return nil, http.BadRequest(Ls(l, "default rule must be specified as 'default URL': %q"), sc.Text())
+			}
+			rule.Kind = GeoRuleDefault
+			rule.RawURL = fields[1]
+		}
+
+		if len(rule.RawURL) > GetConfig().MaxURLLen {
+			return nil, http.BadRequest(Ls(l, "length of the URL must be between %d and %d characters"), MinURLLen, GetConfig().MaxURLLen)
+		}
+		if _, err := url.Parse(rule.RawURL); err != nil {
+			return nil, http.BadRequest(Ls(l, "provided URL is incorrect: %v"), err)
+		}
+
+		if len(rules) == MaxGeoRules {
+			return nil, http.BadRequest(Ls(l, "number of rules must not exceed %d"), MaxGeoRules)
+		}
+		for i := 0; i < len(rule.Values); i++ {
+			rule.Values[i] = CloneString(rule.Values[i])
+		}
+		rule.RawURL = CloneString(rule.RawURL)
+		rules = append(rules, rule)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, http.ClientError(err)
+	}
+
+	return rules, nil
+}
+
+func DisplayURLGeoRulesForm(w *http.Response, path string, url *URL) {
+	w.WriteString(`<h3>`)
+	w.WriteString(Ls(GL, "Country and language routing"))
+	w.WriteString(`</h3>`)
+
+	w.WriteString(`<form method="POST" action="` + APIPrefix + `/url/geo">`)
+	{
+		DisplayHiddenInput(w, "Path", path)
+
+		var buf strings.Builder
+		for i := 0; i < len(url.GeoRules); i++ {
+			buf.WriteString(url.GeoRules[i].String())
+			buf.WriteString("\n")
+		}
+
+		DisplayLabel(w, GL, "Rules, evaluated in order")
+		DisplayTextarea(w, "GeoRules", MaxGeoRules/4, buf.String())
+		w.WriteString(`<br>`)
+		w.WriteString(Ls(GL, "Examples"))
+		w.WriteString(`: <code>country DE,AT,CH https://example.de</code>, <code>language pt https://example.pt</code>, <code>default https://example.com</code>.<br><br>`)
+
+		DisplaySubmit(w, GL, "", "Save")
+	}
+	w.WriteString(`</form>`)
+}
+
+/* DisplayURLSimulation shows which rules would fire for visitor described by 'Simulate*' form values. */
+func DisplayURLSimulation(w *http.Response, r *http.Request, path string, url *URL) {
+	defer trace.End(trace.Begin(""))
+
+	w.WriteString(`<h3>`)
+	w.WriteString(Ls(GL, "Simulate visitor"))
+	w.WriteString(`</h3>`)
+
+	w.WriteString(`<form method="GET" action="/url/`)
+	w.WriteString(path)
+	w.WriteString(`">`)
+	{
+		DisplayLabel(w, GL, "IP address")
+		DisplayConstraintInput(w, "text", 0, 64, "SimulateIP", r.Form.Get("SimulateIP"), false)
+		w.WriteString(`<br><br>`)
+
+		DisplayLabel(w, GL, "Accept-Language")
+		DisplayConstraintInput(w, "text", 0, 256, "SimulateLanguage", r.Form.Get("SimulateLanguage"), false)
+		w.WriteString(`<br><br>`)
+
+		DisplayLabel(w, GL, "User-Agent")
+		DisplayConstraintInput(w, "text", 0, 512, "SimulateUserAgent", r.Form.Get("SimulateUserAgent"), false)
+		w.WriteString(`<br><br>`)
+
+		DisplaySubmit(w, GL, "Simulate", "Simulate")
+	}
+	w.WriteString(`</form>`)
+
+	if r.Form.Get("Simulate") == "" {
+		return
+	}
+
+	v := NewVisitor(r.Form.Get("SimulateIP"), r.Form.Get("SimulateLanguage"), r.Form.Get("SimulateUserAgent"))
+
+	w.WriteString(`<p>`)
+	w.WriteString(Ls(GL, "Country"))
+	w.WriteString(`: `)
+	if v.Country != "" {
+		w.WriteString(v.Country)
+	} else {
+		w.WriteString(Ls(GL, "unknown"))
+	}
+	w.WriteString(`, `)
+	w.WriteString(Ls(GL, "language"))
+	w.WriteString(`: `)
+	if len(v.Languages) > 0 {
+		w.WriteHTMLString(v.Languages[0])
+	} else {
+		w.WriteString(Ls(GL, "unknown"))
+	}
+	w.WriteString(`, `)
+	w.WriteString(Ls(GL, "platform"))
+	w.WriteString(`: `)
+	w.WriteString(v.Platform.String())
+	w.WriteString(`.</p>`)
+
+	w.WriteString(`<p>`)
+	if rule := URLMatchDeviceRule(url, v.Platform); rule >= 0 {
+		w.WriteString(Ls(GL, "Device rule fires"))
+		w.WriteString(`: <code>`)
+		w.WriteString(url.DeviceRules[rule].Platform.String())
+		w.WriteString(` `)
+		w.WriteHTMLString(url.DeviceRules[rule].RawURL)
+		w.WriteString(`</code>`)
+	} else if rule := URLMatchGeoRule(url, &v); rule >= 0 {
+		w.WriteString(Ls(GL, "Rule"))
+		w.WriteString(` #`)
+		w.WriteInt(rule + 1)
+		w.WriteString(` `)
+		w.WriteString(Ls(GL, "fires"))
+		w.WriteString(`: <code>`)
+		w.WriteHTMLString(url.GeoRules[rule].String())
+		w.WriteString(`</code>`)
+	} else if len(url.Targets) > 0 {
+		w.WriteString(Ls(GL, "No rule fires, visitor is split between variants"))
+	} else {
+		w.WriteString(Ls(GL, "No rule fires, visitor follows the default target"))
+	}
+	w.WriteString(`.</p>`)
+}
+
+func URLGeoRulesHandler(w *http.Response, r *http.Request) error {
+	defer trace.End(trace.Begin(""))
+
+	if err := r.ParseForm(); err != nil {
+		return http.ClientError(err)
+	}
+
+	path := r.Form.Get("Path")
+
+	var u URL
+	if _, err := GetOwnedURLFromRequest(r, path, &u); err != nil {
+		return err
+	}
+
+	rules, err := ParseURLGeoRules(GL, r.Form.Get("GeoRules"))
+	if err != nil {
+		return URLPage(w, r, path, err)
+	}
+
+	if err := UpdateURL(path, func(u *URL) error {
+		u.GeoRules = rules
+		return nil
+	}); err != nil {
+		return http.ServerError(err)
+	}
+
+	w.Redirect("/url/"+path, http.StatusSeeOther)
+	return nil
+}
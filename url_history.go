@@ -0,0 +1,294 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/anton2920/gofa/database"
+	"github.com/anton2920/gofa/net/http"
+	"github.com/anton2920/gofa/time"
+	"github.com/anton2920/gofa/trace"
+)
+
+/* URLRevision records change of link's destination. */
+type URLRevision struct {
+	/* Number identifies revision among revisions of the link, it stays the same when older ones are dropped. */
+	Number int64
+
+	OldURL string
+	NewURL string
+	UserID database.ID
+	Time   int64
+}
+
+const OneDay = 60 * 60 * 24
+
+/* StatsDays is how many recent days are shown in statistics of the link. */
+const StatsDays = 30
+
+const MaxURLRevisions = 128
+
+func UnixDay(t int64) int64 {
+	return t / OneDay
+}
+
+/* GetURLDailyCounts returns copies of per-day redirects and QR code scans, so they could be read while redirects happen. */
+func GetURLDailyCounts(path string) (map[int64]int64, map[int64]int64) {
+	URLsLock.RLock()
+	defer URLsLock.RUnlock()
+
+	url := URLs[path]
+
+	redirects := make(map[int64]int64)
+	for day, count := range url.RedirectCounts {
+		redirects[day] = count
+	}
+	scans := make(map[int64]int64)
+	for day, count := range url.ScanCounts {
+		scans[day] = count
+	}
+	return redirects, scans
+}
+
+/* GetURLRecentRedirects returns number of redirects made to link 'path' during last StatsDays days. */
+func GetURLRecentRedirects(path string) int64 {
+	URLsLock.RLock()
+	defer URLsLock.RUnlock()
+
+	oldest := UnixDay(int64(time.Unix())) - StatsDays
+
+	var redirects int64
+	for day, count := range URLs[path].RedirectCounts {
+		if day > oldest {
+			redirects += count
+		}
+	}
+	return redirects
+}
+
+func FindURLRevision(u *URL, number int64) *URLRevision {
+	for i := 0; i < len(u.Revisions); i++ {
+		if u.Revisions[i].Number == number {
+			return &u.Revisions[i]
+		}
+	}
+	return nil
+}
+
+/* URLRetarget changes destination of link 'path' and records this change. */
+func URLRetarget(path string, rawURL string, userID database.ID) error {
+	defer trace.End(trace.Begin(""))
+
+	var changed bool
+	if err := UpdateURL(path, func(u *URL) error {
+		if u.RawURL == rawURL {
+			return nil
+		}
+		changed = true
+
+		var number int64 = 1
+		if len(u.Revisions) > 0 {
+			number = u.Revisions[len(u.Revisions)-1].Number + 1
+		}
+		revision := URLRevision{
+			Number: number,
+			OldURL: u.RawURL,
+			NewURL: CloneString(rawURL),
+			UserID: userID,
+			Time:   int64(time.Unix()),
+		}
+
+		/* NOTE(anton2920): revisions are shared with copies of the link, so new slice is needed. */
+		revisions := u.Revisions
+		if len(revisions) == MaxURLRevisions {
+			revisions = revisions[1:]
+		}
+		u.Revisions = append(append(make([]URLRevision, 0, len(revisions)+1), revisions...), revision)
+		u.RawURL = revision.NewURL
+		u.Metadata = URLMetadata{}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if changed {
+		FetchURLMetadataAsync(path, rawURL)
+	}
+	return nil
+}
+
+/* DisplayURLDailyStats displays recent redirects per day, annotated with changes of destination. */
+func DisplayURLDailyStats(w *http.Response, path string, u *URL) {
+	defer trace.End(trace.Begin(""))
+
+	counts, scans := GetURLDailyCounts(path)
+	today := UnixDay(int64(time.Unix()))
+
+	w.WriteString(`<h3>`)
+	w.WriteString(Ls(GL, "Redirects per day"))
+	w.WriteString(`</h3>`)
+
+	w.WriteString(`<table><tr><th>`)
+	w.WriteString(Ls(GL, "Day"))
+	w.WriteString(`</th><th>`)
+	w.WriteString(Ls(GL, "Redirects"))
+	w.WriteString(`</th><th>`)
+	w.WriteString(Ls(GL, "QR code scans"))
+	w.WriteString(`</th><th>`)
+	w.WriteString(Ls(GL, "Destination changes"))
+	w.WriteString(`</th></tr>`)
+	for day := today; day > today-StatsDays; day-- {
+		var changed bool
+		for i := 0; i < len(u.Revisions); i++ {
+			if UnixDay(u.Revisions[i].Time) == day {
+				changed = true
+				break
+			}
+		}
+		if (counts[day] == 0) && (!changed) {
+			continue
+		}
+
+		w.WriteString(`<tr><td>`)
+		DisplayFormattedDate(w, day*OneDay)
+		w.WriteString(`</td><td>`)
+		w.WriteInt(int(counts[day]))
+		w.WriteString(`</td><td>`)
+		w.WriteInt(int(scans[day]))
+		w.WriteString(`</td><td>`)
+		for i := 0; i < len(u.Revisions); i++ {
+			if UnixDay(u.Revisions[i].Time) == day {
+				w.WriteString(`&rarr; `)
+				w.WriteHTMLString(u.Revisions[i].NewURL)
+				w.WriteString(`<br>`)
+			}
+		}
+		w.WriteString(`</td></tr>`)
+	}
+	w.WriteString(`</table>`)
+}
+
+func DisplayURLHistory(w *http.Response, path string, u *URL) {
+	w.WriteString(`<h3>`)
+	w.WriteString(Ls(GL, "Destination"))
+	w.WriteString(`</h3>`)
+
+	w.WriteString(`<form method="POST" action="` + APIPrefix + `/url/retarget">`)
+	{
+		DisplayHiddenInput(w, "Path", path)
+
+		DisplayLabel(w, GL, "URL")
+		DisplayConstraintInput(w, "text", MinURLLen, GetConfig().MaxURLLen, "URL", u.RawURL, true)
+		w.WriteString(`<br><br>`)
+
+		DisplaySubmit(w, GL, "", "Change destination")
+	}
+	w.WriteString(`</form>`)
+
+	if len(u.Revisions) == 0 {
+		return
+	}
+
+	w.WriteString(`<h3>`)
+	w.WriteString(Ls(GL, "History"))
+	w.WriteString(`</h3>`)
+
+	var user User
+
+	w.WriteString(`<table><tr><th>`)
+	w.WriteString(Ls(GL, "Time"))
+	w.WriteString(`</th><th>`)
+	w.WriteString(Ls(GL, "Old target"))
+	w.WriteString(`</th><th>`)
+	w.WriteString(Ls(GL, "New target"))
+	w.WriteString(`</th><th>`)
+	w.WriteString(Ls(GL, "Changed by"))
+	w.WriteString(`</th><th></th></tr>`)
+	for i := len(u.Revisions) - 1; i >= 0; i-- {
+		revision := &u.Revisions[i]
+
+		w.WriteString(`<tr><td>`)
+		DisplayFormattedTime(w, revision.Time)
+		w.WriteString(`</td><td>`)
+		w.WriteHTMLString(revision.OldURL)
+		w.WriteString(`</td><td>`)
+		w.WriteHTMLString(revision.NewURL)
+		w.WriteString(`</td><td>`)
+		if GetUserByID(revision.UserID, &user) == nil {
+			DisplayUserTitle(w, &user)
+		}
+		w.WriteString(`</td><td>`)
+		w.WriteString(`<form method="POST" action="` + APIPrefix + `/url/rollback">`)
+		DisplayHiddenInput(w, "Path", path)
+		DisplayHiddenInput(w, "Revision", strconv.FormatInt(revision.Number, 10))
+		DisplaySubmit(w, GL, "", "Roll back")
+		w.WriteString(`</form>`)
+		w.WriteString(`</td></tr>`)
+	}
+	w.WriteString(`</table>`)
+}
+
+func URLRetargetHandler(w *http.Response, r *http.Request) error {
+	defer trace.End(trace.Begin(""))
+
+	if err := r.ParseForm(); err != nil {
+		return http.ClientError(err)
+	}
+
+	path := r.Form.Get("Path")
+
+	var u URL
+	session, err := GetOwnedURLFromRequest(r, path, &u)
+	if err != nil {
+		return err
+	}
+
+	rawURL := r.Form.Get("URL")
+	if err := URLTargetValid(GL, rawURL); err != nil {
+		return URLPage(w, r, path, err)
+	}
+
+	if err := URLRetarget(path, rawURL, session.ID); err != nil {
+		return http.ServerError(err)
+	}
+
+	w.Redirect("/url/"+path, http.StatusSeeOther)
+	return nil
+}
+
+/* URLRollbackHandler restores destination that link had before specified revision. */
+func URLRollbackHandler(w *http.Response, r *http.Request) error {
+	defer trace.End(trace.Begin(""))
+
+	if err := r.ParseForm(); err != nil {
+		return http.ClientError(err)
+	}
+
+	path := r.Form.Get("Path")
+
+	var u URL
+	session, err := GetOwnedURLFromRequest(r, path, &u)
+	if err != nil {
+		return err
+	}
+
+	number, err := strconv.ParseInt(r.Form.Get("Revision"), 10, 64)
+	if err != nil {
+		return URLPage(w, r, path, http.BadRequest(Ls(GL, "revision with this number does not exist")))
+	}
+	revision := FindURLRevision(&u, number)
+	if revision == nil {
+		return URLPage(w, r, path, http.NotFound(Ls(GL, "revision with this number does not exist")))
+	}
+
+	/* NOTE(anton2920): old destination may have been blocked since. */
+	if err := URLTargetValid(GL, revision.OldURL); err != nil {
+		return URLPage(w, r, path, err)
+	}
+
+	if err := URLRetarget(path, revision.OldURL, session.ID); err != nil {
+		return http.ServerError(err)
+	}
+
+	w.Redirect("/url/"+path, http.StatusSeeOther)
+	return nil
+}
@@ -0,0 +1,499 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	stdtime "time"
+
+	"github.com/anton2920/gofa/database"
+	"github.com/anton2920/gofa/net/http"
+	"github.com/anton2920/gofa/trace"
+)
+
+type ImportFormat int
+
+const (
+	ImportFormatCSV ImportFormat = iota
+	ImportFormatJSON
+)
+
+/* URLImportRow is a single link from export of another shortener. */
+type URLImportRow struct {
+	Line      int
+	Code      string
+	RawURL    string
+	CreatedAt int64
+	Clicks    int64
+
+	/* Path is empty when new code must be assigned. */
+	Path  string
+	Error string
+}
+
+const (
+	MaxImportRows = 100000
+	MaxImportLen  = MaxImportRows * 256
+)
+
+/* ImportColumns are names different shorteners use for the same things in their exports. */
+var ImportColumns = map[string][]string{
+	"code":    {"code", "short", "short_code", "shortcode", "slug", "alias", "key", "keyword", "hash", "back_half"},
+	"target":  {"target", "url", "long_url", "longurl", "original_url", "destination", "long"},
+	"created": {"created", "created_at", "createdat", "date", "timestamp", "created_on"},
+	"clicks":  {"clicks", "click_count", "visits", "hits", "total_clicks", "redirects"},
+}
+
+/* ImportTimeLayouts are formats of creation time in exports, Unix time is accepted too. */
+var ImportTimeLayouts = [...]string{
+	stdtime.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+func ParseImportFormat(s string) (ImportFormat, bool) {
+	switch strings.ToLower(s) {
+	case "csv":
+		return ImportFormatCSV, true
+	case "json":
+		return ImportFormatJSON, true
+	}
+	return 0, false
+}
+
+/* ImportColumn returns canonical name of the column or empty string if it is unknown. */
+func ImportColumn(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for column, names := range ImportColumns {
+		for _, n := range names {
+			if name == n {
+				return column
+			}
+		}
+	}
+	return ""
+}
+
+func ParseImportTime(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	if t, err := strconv.ParseInt(value, 10, 64); err == nil {
+		/* NOTE(anton2920): some shorteners export milliseconds. */
+		if t > 1e12 {
+			t /= 1000
+		}
+		return t, nil
+	}
+
+	var err error
+	for _, layout := range ImportTimeLayouts {
+		var t stdtime.Time
+		if t, err = stdtime.ParseInLocation(layout, value, stdtime.UTC); err == nil {
+			return t.Unix(), nil
+		}
+	}
+	return 0, err
+}
+
+/* URLImportRowFromFields fills row from columns of the export, errors are stored in the row. */
+func URLImportRowFromFields(l Language, line int, fields map[string]string) URLImportRow {
+	row := URLImportRow{Line: line, Code: fields["code"], RawURL: fields["target"]}
+	err := URLTargetValid(l, row.RawURL)
+	if err == nil {
+		if row.CreatedAt, err = ParseImportTime(fields["created"]); err != nil {
+			err = http.BadRequest(Ls(l, "creation time must be Unix time, YYYY-MM-DD or RFC 3339"))
+		} else if clicks := fields["clicks"]; clicks != "" {
+			if row.Clicks, err = strconv.ParseInt(clicks, 10, 64); (err != nil) || (row.Clicks < 0) {
+				err = http.BadRequest(Ls(l, "number of clicks must be a non-negative integer"))
+			}
+		}
+	}
+	if err != nil {
+		row.Error = ErrorMessage(err)
+	}
+
+	return row
+}
+
+func ParseImportCSV(l Language, data string) ([]URLImportRow, error) {
+	reader := csv.NewReader(strings.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, http.BadRequest(Ls(l, "failed to parse CSV: %v"), err)
+	}
+	columns := make([]string, len(header))
+	var hasTarget bool
+	for i, name := range header {
+		columns[i] = ImportColumn(name)
+		hasTarget = hasTarget || (columns[i] == "target")
+	}
+	if !hasTarget {
+		return nil, http.BadRequest(Ls(l, "header of CSV must contain column with target URL"))
+	}
+
+	var rows []URLImportRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, http.BadRequest(Ls(l, "failed to parse CSV: %v"), err)
+		}
+		if len(rows) == MaxImportRows {
+			return nil, http.BadRequest(Ls(l, "number of rows must not exceed %d"), MaxImportRows)
+		}
+		line, _ := reader.FieldPos(0)
+
+		fields := make(map[string]string)
+		for i := 0; (i < len(record)) && (i < len(columns)); i++ {
+			if columns[i] != "" {
+				fields[columns[i]] = strings.TrimSpace(record[i])
+			}
+		}
+		rows = append(rows, URLImportRowFromFields(l, line, fields))
+	}
+
+	return rows, nil
+}
+
+/* ParseImportJSON accepts either array of objects or object with such array in 'links', 'urls' or 'data'. */
+func ParseImportJSON(l Language, data string) ([]URLImportRow, error) {
+	var objects []map[string]interface{}
+
+	array := strings.TrimSpace(data)
+	if strings.HasPrefix(array, "{") {
+		var wrapper map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(array), &wrapper); err != nil {
+			return nil, http.BadRequest(Ls(l, "failed to parse JSON: %v"), err)
+		}
+		array = ""
+		for _, key := range [...]string{"links", "urls", "data"} {
+			if v, ok := wrapper[key]; ok {
+				array = string(v)
+				break
+			}
+		}
+		if array == "" {
+			return nil, http.BadRequest(Ls(l, "JSON object must contain array of links in 'links', 'urls' or 'data'"))
+		}
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(array))
+	decoder.UseNumber()
+	if err := decoder.Decode(&objects); err != nil {
+		return nil, http.BadRequest(Ls(l, "failed to parse JSON: %v"), err)
+	}
+	if len(objects) > MaxImportRows {
+		return nil, http.BadRequest(Ls(l, "number of rows must not exceed %d"), MaxImportRows)
+	}
+
+	rows := make([]URLImportRow, 0, len(objects))
+	for i, object := range objects {
+		fields := make(map[string]string)
+		for name, value := range object {
+			column := ImportColumn(name)
+			if column == "" {
+				continue
+			}
+			switch value := value.(type) {
+			case string:
+				fields[column] = strings.TrimSpace(value)
+			case json.Number:
+				fields[column] = value.String()
+			}
+		}
+		rows = append(rows, URLImportRowFromFields(l, i+1, fields))
+	}
+
+	return rows, nil
+}
+
+func ParseImport(l Language, format ImportFormat, data string) ([]URLImportRow, error) {
+	defer trace.End(trace.Begin(""))
+
+	if len(data) > MaxImportLen {
+		return nil, http.BadRequest(Ls(l, "file must not exceed %d bytes"), MaxImportLen)
+	}
+
+	var rows []URLImportRow
+	var err error
+
+	switch format {
+	case ImportFormatCSV:
+		rows, err = ParseImportCSV(l, data)
+	case ImportFormatJSON:
+		rows, err = ParseImportJSON(l, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, http.BadRequest(Ls(l, "file does not contain any links"))
+	}
+
+	return rows, nil
+}
+
+/* PlanImport decides which codes could be preserved. Rows with unreachable or taken codes get new ones. */
+func PlanImport(rows []URLImportRow) {
+	defer trace.End(trace.Begin(""))
+
+	seen := make(map[string]struct{})
+	for i := 0; i < len(rows); i++ {
+		row := &rows[i]
+
+		row.Path = ""
+		if (row.Error != "") || (row.Code == "") || (URLPathValid(GL, row.Code) != nil) {
+			continue
+		}
+		if _, ok := seen[row.Code]; ok {
+			continue
+		}
+		var u URL
+		if GetURLByPath(row.Code, &u) == nil {
+			continue
+		}
+
+		row.Path = row.Code
+		seen[row.Code] = struct{}{}
+	}
+}
+
+/* CommitImport creates links for all valid rows at once and stores their codes in rows. */
+func CommitImport(userID database.ID, rows []URLImportRow) error {
+	defer trace.End(trace.Begin(""))
+
+	var paths []string
+	var urls []URL
+	var indices []int
+
+	for i := 0; i < len(rows); i++ {
+		row := &rows[i]
+		if row.Error != "" {
+			continue
+		}
+
+		var url URL
+		url.UserID = userID
+		url.RawURL = row.RawURL
+		url.CreatedAt = row.CreatedAt
+		url.Redirects = row.Clicks
+		url.ImportedRedirects = row.Clicks
+		url.RedirectCounts = make(map[int64]int64)
+		url.RedirectFrom = make(map[string]int64)
+
+		paths = append(paths, row.Path)
+		urls = append(urls, url)
+		indices = append(indices, i)
+	}
+	if len(urls) == 0 {
+		return nil
+	}
+
+	if err := CreateURLs(paths, urls); err != nil {
+		return err
+	}
+	for i, index := range indices {
+		rows[index].Path = paths[i]
+	}
+	FetchURLsMetadataAsync(paths, urls)
+
+	return nil
+}
+
+/* WriteImportReport writes table of what import did or, if 'dryRun' is set, what it is going to do. */
+func WriteImportReport(w *http.Response, rows []URLImportRow, dryRun bool) {
+	var created, renamed, failed int
+
+	w.WriteString(`<table><tr><th>#</th><th>`)
+	w.WriteString(Ls(GL, "Code"))
+	w.WriteString(`</th><th>`)
+	w.WriteString(Ls(GL, "Target"))
+	w.WriteString(`</th><th>`)
+	w.WriteString(Ls(GL, "Created"))
+	w.WriteString(`</th><th>`)
+	w.WriteString(Ls(GL, "Redirects"))
+	w.WriteString(`</th><th>`)
+	w.WriteString(Ls(GL, "Result"))
+	w.WriteString(`</th></tr>`)
+	for i := 0; i < len(rows); i++ {
+		row := &rows[i]
+
+		w.WriteString(`<tr><td>`)
+		w.WriteInt(row.Line)
+		w.WriteString(`</td><td>`)
+		w.WriteHTMLString(row.Code)
+		w.WriteString(`</td><td>`)
+		w.WriteHTMLString(row.RawURL)
+		w.WriteString(`</td><td>`)
+		if row.CreatedAt != 0 {
+			DisplayFormattedDate(w, row.CreatedAt)
+		}
+		w.WriteString(`</td><td>`)
+		w.WriteInt(int(row.Clicks))
+		w.WriteString(`</td><td>`)
+		switch {
+		case row.Error != "":
+			failed++
+			w.WriteString(Ls(GL, "Error"))
+			w.WriteString(`: `)
+			w.WriteHTMLString(row.Error)
+		case (row.Path != "") && (row.Path == row.Code):
+			created++
+			w.WriteString(Ls(GL, "Code is preserved"))
+		case row.Path != "":
+			renamed++
+			w.WriteString(Ls(GL, "New code"))
+			w.WriteString(`: <a href="/url/`)
+			w.WriteString(row.Path)
+			w.WriteString(`">`)
+			w.WriteString(row.Path)
+			w.WriteString(`</a>`)
+		default:
+			renamed++
+			w.WriteString(Ls(GL, "New code will be assigned"))
+		}
+		w.WriteString(`</td></tr>`)
+	}
+	w.WriteString(`</table>`)
+
+	w.WriteString(`<p>`)
+	if dryRun {
+		w.WriteString(Ls(GL, "Links to be imported with their codes"))
+	} else {
+		w.WriteString(Ls(GL, "Links imported with their codes"))
+	}
+	w.WriteString(`: `)
+	w.WriteInt(created)
+	w.WriteString(`. `)
+	w.WriteString(Ls(GL, "With new codes"))
+	w.WriteString(`: `)
+	w.WriteInt(renamed)
+	w.WriteString(`. `)
+	w.WriteString(Ls(GL, "Skipped because of errors"))
+	w.WriteString(`: `)
+	w.WriteInt(failed)
+	w.WriteString(`.</p>`)
+}
+
+/* URLImportPage shows form for import and, if 'rows' are given, report of the dry run or of the import itself. */
+func URLImportPage(w *http.Response, r *http.Request, rows []URLImportRow, dryRun bool, ierr error) error {
+	defer trace.End(trace.Begin(""))
+
+	const title = "Import links"
+
+	session, err := GetSessionFromRequest(r)
+	if err != nil {
+		return http.UnauthorizedError
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return http.ClientError(err)
+	}
+
+	DisplayHTMLStart(w)
+
+	DisplayHeadStart(w)
+	{
+		w.WriteString(`<title>`)
+		w.WriteString(Ls(GL, title))
+		w.WriteString(`</title>`)
+	}
+	DisplayHeadEnd(w)
+
+	DisplayBodyStart(w)
+	{
+		w.WriteString(`<h2>`)
+		w.WriteString(Ls(GL, title))
+		w.WriteString(`</h2>`)
+
+		w.WriteString(`<a href="/user/`)
+		w.WriteID(session.ID)
+		w.WriteString(`">`)
+		w.WriteString(Ls(GL, "Back"))
+		w.WriteString(`</a>`)
+		w.WriteString(`<br><br>`)
+
+		DisplayError(w, GL, ierr)
+
+		if rows != nil {
+			WriteImportReport(w, rows, dryRun)
+		}
+
+		if (rows == nil) || (dryRun) {
+			w.WriteString(`<p>`)
+			w.WriteString(Ls(GL, "CSV must have header with columns 'code', 'target', 'created' and 'clicks'. JSON must be an array of objects with the same keys. Common names used by other shorteners are recognized too"))
+			w.WriteString(`.</p>`)
+
+			w.WriteString(`<form method="POST" action="` + APIPrefix + `/url/import">`)
+			{
+				format := r.Form.Get("Format")
+
+				w.WriteString(`<select name="Format"><option value="csv">CSV</option><option value="json"`)
+				if format == "json" {
+					w.WriteString(` selected`)
+				}
+				w.WriteString(`>JSON</option></select>`)
+				w.WriteString(`<br><br>`)
+
+				DisplayTextarea(w, "Data", 16, r.Form.Get("Data"))
+				w.WriteString(`<br><br>`)
+
+				DisplaySubmit(w, GL, "", "Check")
+				if (rows != nil) && (dryRun) {
+					w.WriteString(` `)
+					DisplaySubmit(w, GL, "Commit", "Import")
+				}
+			}
+			w.WriteString(`</form>`)
+		}
+	}
+	DisplayBodyEnd(w)
+
+	DisplayHTMLEnd(w)
+	return nil
+}
+
+/* URLImportHandler reports what is going to be imported unless 'Commit' is set. */
+func URLImportHandler(w *http.Response, r *http.Request) error {
+	defer trace.End(trace.Begin(""))
+
+	session, err := GetSessionFromRequest(r)
+	if err != nil {
+		return http.UnauthorizedError
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return http.ClientError(err)
+	}
+
+	format, ok := ParseImportFormat(r.Form.Get("Format"))
+	if !ok {
+		return URLImportPage(w, r, nil, true, http.BadRequest(Ls(GL, "format must be either 'csv' or 'json'")))
+	}
+	rows, err := ParseImport(GL, format, r.Form.Get("Data"))
+	if err != nil {
+		return URLImportPage(w, r, nil, true, err)
+	}
+	PlanImport(rows)
+
+	if r.Form.Get("Commit") == "" {
+		return URLImportPage(w, r, rows, true, nil)
+	}
+
+	if err := CommitImport(session.ID, rows); err != nil {
+		if err == URLPathTaken {
+			return URLImportPage(w, r, rows, true, http.Conflict(Ls(GL, "some of the codes have been taken while links were imported, check again")))
+		}
+		return http.ServerError(err)
+	}
+
+	return URLImportPage(w, r, rows, false, nil)
+}
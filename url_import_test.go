@@ -0,0 +1,172 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseImportTime(t *testing.T) {
+	tests := [...]struct {
+		Value string
+		Time  int64
+		Error bool
+	}{
+		{"", 0, false},
+		{"1700000000", 1700000000, false},
+		{"1700000000123", 1700000000, false},
+		{"2023-11-14T22:13:20Z", 1700000000, false},
+		{"2023-11-15T01:13:20+03:00", 1700000000, false},
+		{"2023-11-14 22:13:20", 1700000000, false},
+		{"2023-11-14T22:13:20", 1700000000, false},
+		{"2023-11-14", 1699920000, false},
+		{"14.11.2023", 0, true},
+		{"yesterday", 0, true},
+	}
+	for _, test := range tests {
+		tm, err := ParseImportTime(test.Value)
+		if (err != nil) != test.Error {
+			t.Errorf("ParseImportTime(%q) returned error %v, expected error %v", test.Value, err, test.Error)
+		} else if tm != test.Time {
+			t.Errorf("ParseImportTime(%q) = %d, expected %d", test.Value, tm, test.Time)
+		}
+	}
+}
+
+func TestImportColumn(t *testing.T) {
+	tests := [...]struct {
+		Name   string
+		Column string
+	}{
+		{"slug", "code"},
+		{" Back_Half ", "code"},
+		{"long_url", "target"},
+		{"URL", "target"},
+		{"created_at", "created"},
+		{"total_clicks", "clicks"},
+		{"title", ""},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if column := ImportColumn(test.Name); column != test.Column {
+			t.Errorf("ImportColumn(%q) = %q, expected %q", test.Name, column, test.Column)
+		}
+	}
+}
+
+func TestParseImport(t *testing.T) {
+	type row struct {
+		Line      int
+		Code      string
+		RawURL    string
+		CreatedAt int64
+		Clicks    int64
+		Error     bool
+	}
+
+	tests := [...]struct {
+		Name   string
+		Format ImportFormat
+		Data   string
+		Rows   []row
+	}{
+		{
+			"CSV", ImportFormatCSV,
+			"slug,title,long_url,created_at,clicks\nabc,Example,https://example.com,2023-11-14,5\n,,https://example.org\n",
+			[]row{{2, "abc", "https://example.com", 1699920000, 5, false}, {3, "", "https://example.org", 0, 0, false}},
+		},
+		{
+			"CSV with invalid rows", ImportFormatCSV,
+			"url,clicks,date\nhttps://example.com,-1\nhttps://example.com,many\nhttps://example.com,,never\n,1\n",
+			[]row{{2, "", "https://example.com", 0, -1, true}, {3, "", "https://example.com", 0, 0, true}, {4, "", "https://example.com", 0, 0, true}, {5, "", "", 0, 0, true}},
+		},
+		{
+			"JSON array", ImportFormatJSON,
+			`[{"keyword": "abc", "url": "https://example.com", "timestamp": 1700000000, "hits": 7, "title": "Example"}, {"long": " https://example.org "}]`,
+			[]row{{1, "abc", "https://example.com", 1700000000, 7, false}, {2, "", "https://example.org", 0, 0, false}},
+		},
+		{
+			"JSON object", ImportFormatJSON,
+			`{"total": 1, "links": [{"short": "abc", "destination": "https://example.com", "clicks": 1.5}]}`,
+			[]row{{1, "abc", "https://example.com", 0, 0, true}},
+		},
+		{
+			"JSON object with data", ImportFormatJSON,
+			`{"data": [{"hash": "xyz", "original_url": "https://example.com", "created_on": "2023-11-14T22:13:20Z"}]}`,
+			[]row{{1, "xyz", "https://example.com", 1700000000, 0, false}},
+		},
+	}
+	for _, test := range tests {
+		rows, err := ParseImport(GL, test.Format, test.Data)
+		if err != nil {
+			t.Errorf("%s: ParseImport() failed: %v", test.Name, err)
+			continue
+		}
+		if len(rows) != len(test.Rows) {
+			t.Errorf("%s: ParseImport() returned %d rows, expected %d", test.Name, len(rows), len(test.Rows))
+			continue
+		}
+		for i := 0; i < len(rows); i++ {
+			got := row{rows[i].Line, rows[i].Code, rows[i].RawURL, rows[i].CreatedAt, rows[i].Clicks, rows[i].Error != ""}
+			if got != test.Rows[i] {
+				t.Errorf("%s: row %d is %+v, expected %+v", test.Name, i, got, test.Rows[i])
+			}
+		}
+	}
+}
+
+func TestParseImportFile(t *testing.T) {
+	tests := [...]struct {
+		Name   string
+		Format ImportFormat
+		Data   string
+	}{
+		{"empty CSV", ImportFormatCSV, ""},
+		{"CSV without target", ImportFormatCSV, "slug,title\nabc,Example\n"},
+		{"CSV with only header", ImportFormatCSV, "slug,url\n"},
+		{"broken CSV", ImportFormatCSV, "url\n\"https://example.com\n"},
+		{"empty JSON", ImportFormatJSON, ""},
+		{"empty JSON array", ImportFormatJSON, "[]"},
+		{"JSON object without links", ImportFormatJSON, `{"items": []}`},
+		{"broken JSON", ImportFormatJSON, `[{"url": "https://example.com"}`},
+		{"JSON of wrong type", ImportFormatJSON, `["https://example.com"]`},
+		{"too many rows", ImportFormatCSV, "url\n" + strings.Repeat("https://example.com\n", MaxImportRows+1)},
+		{"too long", ImportFormatJSON, strings.Repeat(" ", MaxImportLen+1)},
+	}
+	for _, test := range tests {
+		if _, err := ParseImport(GL, test.Format, test.Data); err == nil {
+			t.Errorf("%s: ParseImport() succeeded, expected error", test.Name)
+		}
+	}
+}
+
+func TestPlanImport(t *testing.T) {
+	URLsLock.Lock()
+	URLs["taken"] = URL{RawURL: "https://example.com"}
+	URLsLock.Unlock()
+	defer func() {
+		URLsLock.Lock()
+		delete(URLs, "taken")
+		URLsLock.Unlock()
+	}()
+
+	rows := []URLImportRow{
+		{Code: "abc"},
+		{Code: ""},
+		{Code: "taken"},
+		{Code: "abc"},
+		{Code: "has space"},
+		{Code: "user-page"},
+		{Code: "x"},
+		{Code: "bad", Error: "invalid"},
+		{Code: "bad"},
+		{Code: "stale", Path: "stale-path"},
+	}
+	expected := [...]string{"abc", "", "", "", "", "", "x", "", "bad", "stale"}
+
+	PlanImport(rows)
+	for i := 0; i < len(rows); i++ {
+		if rows[i].Path != expected[i] {
+			t.Errorf("code %q of row %d is planned as %q, expected %q", rows[i].Code, i, rows[i].Path, expected[i])
+		}
+	}
+}
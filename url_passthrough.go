@@ -0,0 +1,167 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/anton2920/gofa/errors"
+	"github.com/anton2920/gofa/net/http"
+	"github.com/anton2920/gofa/trace"
+)
+
+/* QueryPolicy decides what to do when target URL and request have the same query parameter. */
+type QueryPolicy int32
+
+const (
+	QueryTargetWins QueryPolicy = iota
+	QueryRequestWins
+	QueryAppend
+	QueryPolicyCount
+)
+
+var QueryPolicy2String = [...]string{
+	QueryTargetWins:  "Keep target's value",
+	QueryRequestWins: "Use request's value",
+	QueryAppend:      "Keep both values",
+}
+
+func (policy QueryPolicy) String() string {
+	return QueryPolicy2String[policy]
+}
+
+var PassthroughDotSegment = errors.New("path must not contain '.' or '..' segments")
+
+/* SplitPassthroughPath splits 'code/extra/path' into 'code' and '/extra/path'. */
+func SplitPassthroughPath(path string) (string, string) {
+	if i := strings.IndexByte(path, '/'); i >= 0 {
+		return path[:i], path[i:]
+	}
+	return path, ""
+}
+
+/* URLPassthrough appends 'extra' path to 'target' and merges 'query' into its query according to 'policy'. */
+func URLPassthrough(target string, extra string, query string, policy QueryPolicy) (string, error) {
+	defer trace.End(trace.Begin(""))
+
+	if (extra == "") && (query == "") {
+		return target, nil
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", err
+	}
+
+	if extra != "" {
+		extra, err = url.PathUnescape(extra)
+		if err != nil {
+			return "", err
+		}
+		/* NOTE(anton2920): otherwise extra path could climb above target's path. Checked after unescaping, so '%2e%2e' is caught too. */
+		for _, segment := range strings.Split(extra, "/") {
+			if (segment == ".") || (segment == "..") {
+				return "", PassthroughDotSegment
+			}
+		}
+		u.Path = strings.TrimSuffix(u.Path, "/") + extra
+		u.RawPath = ""
+	}
+
+	if query != "" {
+		rq, err := url.ParseQuery(query)
+		if err != nil {
+			return "", err
+		}
+
+		tq := u.Query()
+		for key, values := range rq {
+			switch policy {
+			case QueryTargetWins:
+				if !tq.Has(key) {
+					tq[key] = values
+				}
+			case QueryRequestWins:
+				tq[key] = values
+			case QueryAppend:
+				tq[key] = append(tq[key], values...)
+			}
+		}
+		u.RawQuery = tq.Encode()
+	}
+
+	return u.String(), nil
+}
+
+func DisplayURLPassthroughForm(w *http.Response, path string, url *URL) {
+	w.WriteString(`<h3>`)
+	w.WriteString(Ls(GL, "Passthrough"))
+	w.WriteString(`</h3>`)
+
+	w.WriteString(`<form method="POST" action="` + APIPrefix + `/url/passthrough">`)
+	{
+		DisplayHiddenInput(w, "Path", path)
+
+		w.WriteString(`<label>`)
+		DisplayCheckbox(w, "Passthrough", url.Flags&FlagPassthrough == FlagPassthrough)
+		w.WriteString(` `)
+		w.WriteString(Ls(GL, "Append extra path and query of the short link to the target"))
+		w.WriteString(`</label>`)
+		w.WriteString(`<br><br>`)
+
+		DisplayLabel(w, GL, "When parameter is present in both")
+		w.WriteString(`<select name="QueryPolicy">`)
+		for policy := QueryPolicy(0); policy < QueryPolicyCount; policy++ {
+			w.WriteString(`<option value="`)
+			w.WriteInt(int(policy))
+			w.WriteString(`"`)
+			if policy == url.QueryPolicy {
+				w.WriteString(` selected`)
+			}
+			w.WriteString(`>`)
+			w.WriteString(Ls(GL, policy.String()))
+			w.WriteString(`</option>`)
+		}
+		w.WriteString(`</select>`)
+		w.WriteString(`<br><br>`)
+
+		DisplaySubmit(w, GL, "", "Save")
+	}
+	w.WriteString(`</form>`)
+}
+
+func URLPassthroughHandler(w *http.Response, r *http.Request) error {
+	defer trace.End(trace.Begin(""))
+
+	if err := r.ParseForm(); err != nil {
+		return http.ClientError(err)
+	}
+
+	path := r.Form.Get("Path")
+
+	var u URL
+	if _, err := GetOwnedURLFromRequest(r, path, &u); err != nil {
+		return err
+	}
+
+	passthrough := r.Form.Get("Passthrough") != ""
+	policy, err := strconv.Atoi(r.Form.Get("QueryPolicy"))
+	if (err != nil) || (policy < 0) || (policy >= int(QueryPolicyCount)) {
+		return URLPage(w, r, path, http.BadRequest(Ls(GL, "unknown query policy")))
+	}
+
+	if err := UpdateURL(path, func(u *URL) error {
+		if passthrough {
+			u.Flags |= FlagPassthrough
+		} else {
+			u.Flags &^= FlagPassthrough
+		}
+		u.QueryPolicy = QueryPolicy(policy)
+		return nil
+	}); err != nil {
+		return http.ServerError(err)
+	}
+
+	w.Redirect("/url/"+path, http.StatusSeeOther)
+	return nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestURLPassthrough(t *testing.T) {
+	tests := [...]struct {
+		Target   string
+		Extra    string
+		Query    string
+		Policy   QueryPolicy
+		Expected string
+		Error    bool
+	}{
+		{"https://example.com/docs", "", "", QueryTargetWins, "https://example.com/docs", false},
+		{"https://example.com/docs/", "/a/b", "", QueryTargetWins, "https://example.com/docs/a/b", false},
+		{"https://example.com/docs", "/a%20b", "", QueryTargetWins, "https://example.com/docs/a%20b", false},
+		{"https://example.com/docs", "/a..b/.c", "", QueryTargetWins, "https://example.com/docs/a..b/.c", false},
+		{"https://example.com/docs", "/../../admin", "", QueryTargetWins, "", true},
+		{"https://example.com/docs", "/a/./b", "", QueryTargetWins, "", true},
+		{"https://example.com/docs", "/a/..", "", QueryTargetWins, "", true},
+		{"https://example.com/docs", "/%2e%2e/admin", "", QueryTargetWins, "", true},
+		{"https://example.com/docs", "/%zz", "", QueryTargetWins, "", true},
+		{"https://example.com/?a=1", "", "a=2&b=3", QueryTargetWins, "https://example.com/?a=1&b=3", false},
+		{"https://example.com/?a=1", "", "a=2", QueryRequestWins, "https://example.com/?a=2", false},
+		{"https://example.com/?a=1", "", "a=2", QueryAppend, "https://example.com/?a=1&a=2", false},
+	}
+	for _, test := range tests {
+		target, err := URLPassthrough(test.Target, test.Extra, test.Query, test.Policy)
+		if (err != nil) != test.Error {
+			t.Errorf("URLPassthrough(%q, %q, %q) returned error %v, expected error %v", test.Target, test.Extra, test.Query, err, test.Error)
+		} else if target != test.Expected {
+			t.Errorf("URLPassthrough(%q, %q, %q) = %q, expected %q", test.Target, test.Extra, test.Query, target, test.Expected)
+		}
+	}
+}
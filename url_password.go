@@ -0,0 +1,312 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/anton2920/gofa/errors"
+	"github.com/anton2920/gofa/net/http"
+	"github.com/anton2920/gofa/syscall"
+	"github.com/anton2920/gofa/time"
+	"github.com/anton2920/gofa/trace"
+)
+
+/* URLPasswordAttempts tracks wrong guesses of a single client for a single link. */
+type URLPasswordAttempts struct {
+	Count int
+	Since int
+}
+
+const (
+	URLPasswordSaltLen   = 16
+	URLPasswordHashLen   = 32
+	URLPasswordHashIters = 4096
+
+	/* Client may try URLPasswordMaxAttempts times every URLPasswordWindow seconds. */
+	URLPasswordMaxAttempts = 5
+	URLPasswordWindow      = 60 * 5
+
+	/* URLPasswordMaxClients limits number of tracked clients, so guessing from many addresses can't exhaust memory. */
+	URLPasswordMaxClients = 1 << 16
+
+	URLAccessLifetime = 60 * 60
+)
+
+var (
+	URLPasswordFailures     = make(map[string]URLPasswordAttempts)
+	URLPasswordFailuresLock sync.Mutex
+)
+
+/* URLAccessKeyFile keeps URLAccessKey between restarts and upgrades, so cookies granting access stay valid. */
+const URLAccessKeyFile = "access.key"
+
+/* URLAccessKey signs cookies granting access to protected links, server loads it with LoadURLAccessKey. */
+var URLAccessKey []byte
+
+/* LoadURLAccessKey reads key from 'filename', creating it with random key first if it does not exist. */
+func LoadURLAccessKey(filename string) ([]byte, error) {
+	defer trace.End(trace.Begin(""))
+
+	key, err := os.ReadFile(filename)
+	if err == nil {
+		if len(key) != sha256.Size {
+			return nil, errors.New("access key in " + filename + " is corrupted")
+		}
+		return key, nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key = make([]byte, sha256.Size)
+	if _, err := syscall.Getrandom(key, 0); err != nil {
+		return nil, err
+	}
+	tmp := filename + ".tmp"
+	if err := os.WriteFile(tmp, key, 0600); err != nil {
+		return nil, err
+	}
+	return key, os.Rename(tmp, filename)
+}
+
+func URLPasswordHash(password string, salt []byte) ([]byte, error) {
+	defer trace.End(trace.Begin(""))
+
+	return pbkdf2.Key(sha256.New, password, salt, URLPasswordHashIters, URLPasswordHashLen)
+}
+
+func URLSetPassword(url *URL, password string) error {
+	defer trace.End(trace.Begin(""))
+
+	salt := make([]byte, URLPasswordSaltLen)
+	if _, err := syscall.Getrandom(salt, 0); err != nil {
+		return err
+	}
+
+	hash, err := URLPasswordHash(password, salt)
+	if err != nil {
+		return err
+	}
+
+	url.PasswordSalt = salt
+	url.PasswordHash = hash
+	return nil
+}
+
+func URLPasswordCorrect(url *URL, password string) bool {
+	defer trace.End(trace.Begin(""))
+
+	hash, err := URLPasswordHash(password, url.PasswordSalt)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(hash, url.PasswordHash)
+}
+
+/* URLPasswordKey identifies client guessing password of link 'path'. Port changes with every connection and single host usually gets whole IPv6 /64, so neither of them counts. */
+func URLPasswordKey(addr string, path string) string {
+	client := ParseClientAddress(addr)
+	if client.Is6() {
+		client = netip.PrefixFrom(client, 64).Masked().Addr()
+	}
+	return client.String() + "/" + path
+}
+
+/* URLPasswordEvict makes room for new client by removing expired entries, or the oldest one if none have expired. URLPasswordFailuresLock must be held. */
+func URLPasswordEvict(now int) {
+	defer trace.End(trace.Begin(""))
+
+	oldest := ""
+	for key, attempts := range URLPasswordFailures {
+		if now-attempts.Since > URLPasswordWindow {
+			delete(URLPasswordFailures, key)
+		} else if (oldest == "") || (attempts.Since < URLPasswordFailures[oldest].Since) {
+			oldest = key
+		}
+	}
+	if (len(URLPasswordFailures) >= URLPasswordMaxClients) && (oldest != "") {
+		delete(URLPasswordFailures, oldest)
+	}
+}
+
+/*
+ * URLPasswordAttempt reserves attempt to guess password for client 'key', returning false if it has run out of them.
+ * NOTE(anton2920): attempt is counted before password is checked, so parallel requests can't make more than URLPasswordMaxAttempts guesses.
+ */
+func URLPasswordAttempt(key string) bool {
+	defer trace.End(trace.Begin(""))
+
+	now := time.Unix()
+
+	URLPasswordFailuresLock.Lock()
+	defer URLPasswordFailuresLock.Unlock()
+
+	attempts, ok := URLPasswordFailures[key]
+	if (!ok) && (len(URLPasswordFailures) >= URLPasswordMaxClients) {
+		URLPasswordEvict(now)
+	}
+	if now-attempts.Since > URLPasswordWindow {
+		attempts = URLPasswordAttempts{Since: now}
+	}
+	if attempts.Count >= URLPasswordMaxAttempts {
+		return false
+	}
+	attempts.Count++
+	URLPasswordFailures[key] = attempts
+	return true
+}
+
+func URLPasswordSucceeded(key string) {
+	defer trace.End(trace.Begin(""))
+
+	URLPasswordFailuresLock.Lock()
+	delete(URLPasswordFailures, key)
+	URLPasswordFailuresLock.Unlock()
+}
+
+/* URLAccessCookieName returns name of the cookie that grants access to protected link 'path'. */
+func URLAccessCookieName(path string) string {
+	return "Access-" + path
+}
+
+/* URLAccessSignature binds cookie to the link and its current password, so changing the password revokes all cookies. */
+func URLAccessSignature(path string, url *URL, expiry int) string {
+	defer trace.End(trace.Begin(""))
+
+	mac := hmac.New(sha256.New, URLAccessKey)
+	mac.Write([]byte(path))
+	mac.Write([]byte{0})
+	mac.Write([]byte(strconv.Itoa(expiry)))
+	mac.Write([]byte{0})
+	mac.Write(url.PasswordHash)
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func URLAccessGranted(r *http.Request, path string, url *URL) bool {
+	defer trace.End(trace.Begin(""))
+
+	value := r.Cookie(URLAccessCookieName(path))
+	if value == "" {
+		return false
+	}
+
+	expiryString, signature, ok := strings.Cut(value, ".")
+	if !ok {
+		return false
+	}
+	expiry, err := strconv.Atoi(expiryString)
+	if (err != nil) || (time.Unix() > expiry) {
+		return false
+	}
+
+	return hmac.Equal([]byte(signature), []byte(URLAccessSignature(path, url, expiry)))
+}
+
+func URLGrantAccess(w *http.Response, path string, url *URL) {
+	defer trace.End(trace.Begin(""))
+
+	expiry := time.Unix() + URLAccessLifetime
+	value := strconv.Itoa(expiry) + "." + URLAccessSignature(path, url, expiry)
+
+	SetCookie(w, URLAccessCookieName(path), value, expiry)
+}
+
+func URLPasswordPage(w *http.Response, r *http.Request, path string, ierr error) error {
+	defer trace.End(trace.Begin(""))
+
+	const title = "Protected link"
+
+	DisplayHTMLStart(w)
+
+	DisplayHeadStart(w)
+	{
+		w.WriteString(`<title>`)
+		w.WriteString(Ls(GL, title))
+		w.WriteString(`</title>`)
+	}
+	DisplayHeadEnd(w)
+
+	DisplayBodyStart(w)
+	{
+		w.WriteString(`<h2>`)
+		w.WriteString(Ls(GL, title))
+		w.WriteString(`</h2>`)
+
+		w.WriteString(`<p>`)
+		w.WriteString(Ls(GL, "Enter password to follow this link"))
+		w.WriteString(`.</p>`)
+
+		DisplayError(w, GL, ierr)
+
+		/* NOTE(anton2920): posting back to the same location keeps extra path and query of passthrough links. */
+		w.WriteString(`<form method="POST" action="`)
+		w.WriteHTMLString(URLPasswordLocation(r))
+		w.WriteString(`">`)
+		{
+			DisplayLabel(w, GL, "Password")
+			DisplayConstraintInput(w, "password", GetConfig().MinPasswordLen, GetConfig().MaxPasswordLen, "Password", "", true)
+			w.WriteString(`<br><br>`)
+
+			DisplaySubmit(w, GL, "", "Continue")
+		}
+		w.WriteString(`</form>`)
+	}
+	DisplayBodyEnd(w)
+
+	DisplayHTMLEnd(w)
+	return nil
+}
+
+/* URLPasswordLocation returns location password form is posted to, which is the link itself. */
+func URLPasswordLocation(r *http.Request) string {
+	if r.URL.Query == "" {
+		return r.URL.Path
+	}
+	return r.URL.Path + "?" + r.URL.Query
+}
+
+/*
+ * URLPasswordHandler returns true if client is allowed to follow protected link. Otherwise it renders password prompt
+ * or, once password is accepted, sends client back to the link with GET.
+ */
+func URLPasswordHandler(w *http.Response, r *http.Request, path string, addr string, url *URL) (bool, error) {
+	defer trace.End(trace.Begin(""))
+
+	/* NOTE(anton2920): with 307 and 308 browsers would repeat POST with password to the target, so form is never answered with redirect there. */
+	if URLAccessGranted(r, path, url) {
+		if r.Method == "POST" {
+			URLRedirect(w, URLPasswordLocation(r), http.StatusSeeOther, false)
+			return false, nil
+		}
+		return true, nil
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return false, http.ClientError(err)
+	}
+
+	password := r.Form.Get("Password")
+	if password == "" {
+		return false, URLPasswordPage(w, r, path, nil)
+	}
+
+	key := URLPasswordKey(addr, path)
+	if !URLPasswordAttempt(key) {
+		return false, URLPasswordPage(w, r, path, http.Error{StatusCode: http.StatusTooManyRequests, DisplayMessage: Ls(GL, "too many incorrect attempts, try again later")})
+	}
+	if !URLPasswordCorrect(url, password) {
+		return false, URLPasswordPage(w, r, path, http.Conflict(Ls(GL, "provided password is incorrect")))
+	}
+	URLPasswordSucceeded(key)
+
+	URLGrantAccess(w, path, url)
+	URLRedirect(w, URLPasswordLocation(r), http.StatusSeeOther, false)
+	return false, nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/anton2920/gofa/net/http"
+	"github.com/anton2920/gofa/trace"
+)
+
+/* URLPreview is what owner wants chat apps and social networks to show instead of destination's card. */
+type URLPreview struct {
+	Title       string
+	Description string
+	ImageURL    string
+}
+
+const (
+	MaxPreviewTitleLen       = 128
+	MaxPreviewDescriptionLen = 512
+	MaxPreviewImageURLLen    = 512
+)
+
+/* PreviewBots are substrings of User-Agent headers sent by crawlers that build link previews. */
+var PreviewBots = [...]string{
+	"applebot",
+	"discordbot",
+	"embedly",
+	"facebookexternalhit",
+	"facebot",
+	"iframely",
+	"linkedinbot",
+	"mastodon",
+	"mattermost",
+	"pinterest",
+	"redditbot",
+	"skypeuripreview",
+	"slack-imgproxy",
+	"slackbot",
+	"telegrambot",
+	"twitterbot",
+	"viber",
+	"vkshare",
+	"whatsapp",
+}
+
+func IsPreviewBot(userAgent string) bool {
+	userAgent = strings.ToLower(userAgent)
+	for _, bot := range PreviewBots {
+		if strings.Contains(userAgent, bot) {
+			return true
+		}
+	}
+	return false
+}
+
+func URLHasPreview(url *URL) bool {
+	return (url.Preview.Title != "") || (url.Preview.Description != "") || (url.Preview.ImageURL != "")
+}
+
+func DisplayMetaProperty(w *http.Response, property string, content string) {
+	if content == "" {
+		return
+	}
+	w.WriteString(`<meta property="`)
+	w.WriteString(property)
+	w.WriteString(`" content="`)
+	w.WriteHTMLString(content)
+	w.WriteString(`">`)
+}
+
+func DisplayMetaName(w *http.Response, name string, content string) {
+	if content == "" {
+		return
+	}
+	w.WriteString(`<meta name="`)
+	w.WriteString(name)
+	w.WriteString(`" content="`)
+	w.WriteHTMLString(content)
+	w.WriteString(`">`)
+}
+
+/* URLPreviewPage renders minimal page with Open Graph and Twitter tags for preview bots. */
+func URLPreviewPage(w *http.Response, r *http.Request, url *URL) error {
+	defer trace.End(trace.Begin(""))
+
+	preview := &url.Preview
+
+	card := "summary"
+	if preview.ImageURL != "" {
+		card = "summary_large_image"
+	}
+
+	DisplayHTMLStart(w)
+
+	DisplayHeadStart(w)
+	{
+		w.WriteString(`<title>`)
+		w.WriteHTMLString(preview.Title)
+		w.WriteString(`</title>`)
+
+		DisplayMetaProperty(w, "og:type", "website")
+		DisplayMetaProperty(w, "og:title", preview.Title)
+		DisplayMetaProperty(w, "og:description", preview.Description)
+		DisplayMetaProperty(w, "og:image", preview.ImageURL)
+
+		DisplayMetaName(w, "twitter:card", card)
+		DisplayMetaName(w, "twitter:title", preview.Title)
+		DisplayMetaName(w, "twitter:description", preview.Description)
+		DisplayMetaName(w, "twitter:image", preview.ImageURL)
+	}
+	DisplayHeadEnd(w)
+
+	DisplayBodyStart(w)
+	DisplayBodyEnd(w)
+
+	DisplayHTMLEnd(w)
+	return nil
+}
+
+func URLPreviewImageValid(l Language, image string) error {
+	if len(image) > MaxPreviewImageURLLen {
+		return http.BadRequest(Ls(l, "length of the image URL must not exceed %d characters"), MaxPreviewImageURLLen)
+	}
+	if len(image) > 0 {
+		u, err := url.Parse(image)
+		if err != nil {
+			return http.BadRequest(Ls(l, "provided URL is incorrect: %v"), err)
+		}
+		/* NOTE(anton2920): bots cannot resolve relative URLs against our host the way we want, so image must be absolute. */
+		if ((u.Scheme != "http") && (u.Scheme != "https")) || (u.Host == "") {
+			return http.BadRequest(Ls(l, "image URL must be absolute and start with http:// or https://"))
+		}
+	}
+	return nil
+}
+
+func DisplayURLPreviewForm(w *http.Response, path string, url *URL) {
+	w.WriteString(`<h3>`)
+	w.WriteString(Ls(GL, "Social preview"))
+	w.WriteString(`</h3>`)
+
+	w.WriteString(`<p>`)
+	w.WriteString(Ls(GL, "Chat apps and social networks will show this card instead of the one of destination page. Leave all fields empty to use destination's card"))
+	w.WriteString(`.</p>`)
+
+	w.WriteString(`<form method="POST" action="` + APIPrefix + `/url/preview">`)
+	{
+		DisplayHiddenInput(w, "Path", path)
+
+		DisplayLabel(w, GL, "Title")
+		DisplayConstraintInput(w, "text", 0, MaxPreviewTitleLen, "PreviewTitle", url.Preview.Title, false)
+		w.WriteString(`<br><br>`)
+
+		DisplayLabel(w, GL, "Description")
+		DisplayTextarea(w, "PreviewDescription", 3, url.Preview.Description)
+		w.WriteString(`<br><br>`)
+
+		DisplayLabel(w, GL, "Image URL")
+		DisplayConstraintInput(w, "text", 0, MaxPreviewImageURLLen, "PreviewImageURL", url.Preview.ImageURL, false)
+		w.WriteString(`<br><br>`)
+
+		DisplaySubmit(w, GL, "", "Save")
+	}
+	w.WriteString(`</form>`)
+}
+
+func URLPreviewHandler(w *http.Response, r *http.Request) error {
+	defer trace.End(trace.Begin(""))
+
+	if err := r.ParseForm(); err != nil {
+		return http.ClientError(err)
+	}
+
+	path := r.Form.Get("Path")
+
+	var u URL
+	if _, err := GetOwnedURLFromRequest(r, path, &u); err != nil {
+		return err
+	}
+
+	title := strings.TrimSpace(r.Form.Get("PreviewTitle"))
+	if len(title) > MaxPreviewTitleLen {
+		return URLPage(w, r, path, http.BadRequest(Ls(GL, "length of the title must not exceed %d characters"), MaxPreviewTitleLen))
+	}
+	description := strings.TrimSpace(r.Form.Get("PreviewDescription"))
+	if len(description) > MaxPreviewDescriptionLen {
+		return URLPage(w, r, path, http.BadRequest(Ls(GL, "length of the description must not exceed %d characters"), MaxPreviewDescriptionLen))
+	}
+	image := strings.TrimSpace(r.Form.Get("PreviewImageURL"))
+	if err := URLPreviewImageValid(GL, image); err != nil {
+		return URLPage(w, r, path, err)
+	}
+
+	if err := UpdateURL(path, func(u *URL) error {
+		u.Preview = URLPreview{
+			Title:       CloneString(title),
+			Description: CloneString(description),
+			ImageURL:    CloneString(image),
+		}
+		return nil
+	}); err != nil {
+		return http.ServerError(err)
+	}
+
+	w.Redirect("/url/"+path, http.StatusSeeOther)
+	return nil
+}
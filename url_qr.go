@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/anton2920/gofa/database"
+	"github.com/anton2920/gofa/net/http"
+	"github.com/anton2920/gofa/trace"
+)
+
+type QRFormat int
+
+const (
+	QRFormatSVG QRFormat = iota
+	QRFormatPNG
+)
+
+const (
+	/* QRScanSuffix is appended to the short link encoded into QR code, so scans could be told apart from other visits. */
+	QRScanSuffix = ".qr"
+
+	QRSVGSuffix = ".qr.svg"
+	QRPNGSuffix = ".qr.png"
+
+	/* Size is in pixels, margin is in modules. */
+	DefaultQRSize   = 256
+	MinQRSize       = 64
+	MaxQRSize       = 2048
+	DefaultQRMargin = 4
+	MaxQRMargin     = 16
+
+	QRMaxAge = 60 * 60 * 24
+)
+
+var QRLevel2String = [...]string{
+	QRLevelL: "L",
+	QRLevelM: "M",
+	QRLevelQ: "Q",
+	QRLevelH: "H",
+}
+
+func ParseQRLevel(s string) (QRLevel, bool) {
+	for level, name := range QRLevel2String {
+		if strings.EqualFold(s, name) {
+			return QRLevel(level), true
+		}
+	}
+	return 0, false
+}
+
+/* SplitQRPath splits '<code>.qr.svg' into code and format of the image. Codes never contain '/', so paths with it are left to passthrough. */
+func SplitQRPath(path string) (string, QRFormat, bool) {
+	if strings.IndexByte(path, '/') >= 0 {
+		return "", 0, false
+	}
+	if code, ok := strings.CutSuffix(path, QRSVGSuffix); ok {
+		return code, QRFormatSVG, true
+	}
+	if code, ok := strings.CutSuffix(path, QRPNGSuffix); ok {
+		return code, QRFormatPNG, true
+	}
+	return "", 0, false
+}
+
+/* SplitScanPath strips suffix of links encoded into QR codes and reports whether it was there. Passthrough paths are never scans. */
+func SplitScanPath(path string) (string, bool) {
+	if strings.IndexByte(path, '/') >= 0 {
+		return path, false
+	}
+	return strings.CutSuffix(path, QRScanSuffix)
+}
+
+/* HostValid reports whether 'host' taken from request is 'name[:port]' and nothing else. */
+func HostValid(host string) bool {
+	if host == "" {
+		return false
+	}
+	u, err := url.Parse("http://" + host)
+	return (err == nil) && (u.Host == host) && (u.User == nil) && (u.Path == "") && (!u.ForceQuery) && (u.RawQuery == "") && (u.Fragment == "")
+}
+
+/*
+ * URLBaseFromRequest returns public address of the service. Unless it is configured, it is built from headers of 'r',
+ * which are controlled by client, so it also reports whether responses using it may be shared with other clients.
+ */
+func URLBaseFromRequest(r *http.Request) (string, bool, error) {
+	if base := GetConfig().BaseURL; base != "" {
+		return base, true, nil
+	}
+
+	host := r.Headers.Get("Host")
+	if !HostValid(host) {
+		return "", false, http.BadRequest(Ls(GL, "Host header is incorrect"))
+	}
+
+	scheme := "http"
+	if r.Headers.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + host, false, nil
+}
+
+/* WriteQRSVG writes 'qr' as SVG image 'size' pixels wide with 'margin' light modules around. */
+func WriteQRSVG(w *http.Response, qr *QRCode, size int, margin int) {
+	dim := qr.Size + 2*margin
+
+	w.WriteString(`<svg xmlns="http://www.w3.org/2000/svg" version="1.1" shape-rendering="crispEdges" width="`)
+	w.WriteInt(size)
+	w.WriteString(`" height="`)
+	w.WriteInt(size)
+	w.WriteString(`" viewBox="0 0 `)
+	w.WriteInt(dim)
+	w.WriteString(` `)
+	w.WriteInt(dim)
+	w.WriteString(`">`)
+
+	w.WriteString(`<rect width="100%" height="100%" fill="#FFFFFF"/>`)
+
+	w.WriteString(`<path fill="#000000" d="`)
+	for y := 0; y < qr.Size; y++ {
+		for x := 0; x < qr.Size; x++ {
+			if qr.Get(x, y) {
+				w.WriteString(`M`)
+				w.WriteInt(x + margin)
+				w.WriteString(`,`)
+				w.WriteInt(y + margin)
+				w.WriteString(`h1v1h-1z`)
+			}
+		}
+	}
+	w.WriteString(`"/>`)
+
+	w.WriteString(`</svg>`)
+}
+
+/* WriteQRPNG writes 'qr' as PNG image. Modules must be whole pixels, so image may be smaller than 'size'. */
+func WriteQRPNG(w *http.Response, qr *QRCode, size int, margin int) error {
+	dim := qr.Size + 2*margin
+	scale := max(size/dim, 1)
+
+	img := image.NewPaletted(image.Rect(0, 0, dim*scale, dim*scale), color.Palette{color.White, color.Black})
+	for y := 0; y < qr.Size; y++ {
+		for x := 0; x < qr.Size; x++ {
+			if !qr.Get(x, y) {
+				continue
+			}
+			for py := (y + margin) * scale; py < (y+margin+1)*scale; py++ {
+				for px := (x + margin) * scale; px < (x+margin+1)*scale; px++ {
+					img.SetColorIndex(px, py, 1)
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return err
+	}
+	w.Write(buf.Bytes())
+	return nil
+}
+
+/* URLQRHandler serves QR code of the short link. Parameters are 'size', 'ec' (one of 'L', 'M', 'Q', 'H') and 'margin'. */
+func URLQRHandler(w *http.Response, r *http.Request, path string, format QRFormat) error {
+	defer trace.End(trace.Begin(""))
+
+	var url URL
+	if err := GetURLByPath(path, &url); err != nil {
+		if err == database.NotFound {
+			return http.NotFound(Ls(GL, "shortened URL does not exist"))
+		}
+		return http.ServerError(err)
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return http.ClientError(err)
+	}
+
+	size := DefaultQRSize
+	if s := r.Form.Get("size"); s != "" {
+		var err error
+		size, err = strconv.Atoi(s)
+		if (err != nil) || (size < MinQRSize) || (size > MaxQRSize) {
+			return http.BadRequest(Ls(GL, "size must be between %d and %d pixels"), MinQRSize, MaxQRSize)
+		}
+	}
+
+	margin := DefaultQRMargin
+	if m := r.Form.Get("margin"); m != "" {
+		var err error
+		margin, err = strconv.Atoi(m)
+		if (err != nil) || (margin < 0) || (margin > MaxQRMargin) {
+			return http.BadRequest(Ls(GL, "margin must be between %d and %d modules"), 0, MaxQRMargin)
+		}
+	}
+
+	level := QRLevelM
+	if l := r.Form.Get("ec"); l != "" {
+		var ok bool
+		level, ok = ParseQRLevel(l)
+		if !ok {
+			return http.BadRequest(Ls(GL, "error correction level must be one of L, M, Q or H"))
+		}
+	}
+
+	base, shared, err := URLBaseFromRequest(r)
+	if err != nil {
+		return err
+	}
+	qr, err := QREncode([]byte(base+"/"+path+QRScanSuffix), level)
+	if err != nil {
+		return http.ServerError(err)
+	}
+
+	/* NOTE(anton2920): otherwise shared cache could give code pointing to whatever host one client has claimed to everybody. */
+	if shared {
+		w.Headers.Set("Cache-Control", "public, max-age="+strconv.Itoa(QRMaxAge))
+	} else {
+		w.Headers.Set("Cache-Control", "private, max-age="+strconv.Itoa(QRMaxAge))
+	}
+	switch format {
+	case QRFormatSVG:
+		w.Headers.Set("Content-Type", "image/svg+xml")
+		WriteQRSVG(w, qr, size, margin)
+	case QRFormatPNG:
+		w.Headers.Set("Content-Type", "image/png")
+		if err := WriteQRPNG(w, qr, size, margin); err != nil {
+			return http.ServerError(err)
+		}
+	}
+	return nil
+}
+
+func DisplayURLQR(w *http.Response, path string) {
+	w.WriteString(`<img src="/`)
+	w.WriteString(path)
+	w.WriteString(QRSVGSuffix + `" alt="QR" width="`)
+	w.WriteInt(DefaultQRSize)
+	w.WriteString(`" height="`)
+	w.WriteInt(DefaultQRSize)
+	w.WriteString(`"><br>`)
+
+	w.WriteString(`<a href="/`)
+	w.WriteString(path)
+	w.WriteString(QRSVGSuffix + `" download>SVG</a> <a href="/`)
+	w.WriteString(path)
+	w.WriteString(QRPNGSuffix + `?size=1024&amp;ec=Q" download>PNG</a>`)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"github.com/anton2920/gofa/net/http"
+	"github.com/anton2920/gofa/trace"
+)
+
+/* URLIsScheduled returns true if link should redirect to its target at time 'now'. */
+func URLIsScheduled(url *URL, now int64) bool {
+	return ((url.NotBefore == 0) || (now >= url.NotBefore)) && ((url.NotAfter == 0) || (now <= url.NotAfter))
+}
+
+/* URLComingSoonPage is displayed instead of redirect when link is outside of its activation window. */
+func URLComingSoonPage(w *http.Response, r *http.Request, url *URL, now int64) error {
+	defer trace.End(trace.Begin(""))
+
+	var title string
+	if now < url.NotBefore {
+		title = "Coming soon"
+		w.StatusCode = http.StatusNotFound
+	} else {
+		title = "Link is no longer active"
+		w.StatusCode = http.StatusGone
+	}
+
+	DisplayHTMLStart(w)
+
+	DisplayHeadStart(w)
+	{
+		w.WriteString(`<title>`)
+		w.WriteString(Ls(GL, title))
+		w.WriteString(`</title>`)
+	}
+	DisplayHeadEnd(w)
+
+	DisplayBodyStart(w)
+	{
+		w.WriteString(`<h2>`)
+		w.WriteString(Ls(GL, title))
+		w.WriteString(`</h2>`)
+
+		if now < url.NotBefore {
+			w.WriteString(`<p>`)
+			w.WriteString(Ls(GL, "This link becomes active on"))
+			w.WriteString(` `)
+			DisplayFormattedTime(w, url.NotBefore)
+			w.WriteString(`.</p>`)
+		}
+
+		if url.ScheduleMessage != "" {
+			w.WriteString(`<p>`)
+			w.WriteHTMLString(url.ScheduleMessage)
+			w.WriteString(`</p>`)
+		}
+	}
+	DisplayBodyEnd(w)
+
+	DisplayHTMLEnd(w)
+	return nil
+}
+
+func DisplayURLScheduleForm(w *http.Response, path string, url *URL) {
+	w.WriteString(`<h3>`)
+	w.WriteString(Ls(GL, "Schedule"))
+	w.WriteString(`</h3>`)
+
+	w.WriteString(`<form method="POST" action="` + APIPrefix + `/url/schedule">`)
+	{
+		DisplayHiddenInput(w, "Path", path)
+
+		DisplayLabel(w, GL, "Active from")
+		DisplayDateTimeInput(w, "NotBefore", url.NotBefore, false)
+		w.WriteString(`<br><br>`)
+
+		DisplayLabel(w, GL, "Active until")
+		DisplayDateTimeInput(w, "NotAfter", url.NotAfter, false)
+		w.WriteString(`<br><br>`)
+
+		DisplayLabel(w, GL, "URL outside of schedule (optional)")
+		DisplayConstraintInput(w, "text", 0, GetConfig().MaxURLLen, "ScheduleURL", url.ScheduleURL, false)
+		w.WriteString(`<br><br>`)
+
+		DisplayLabel(w, GL, "Message outside of schedule (optional)")
+		DisplayConstraintInput(w, "text", 0, MaxScheduleMessageLen, "ScheduleMessage", url.ScheduleMessage, false)
+		w.WriteString(`<br><br>`)
+
+		DisplaySubmit(w, GL, "", "Save schedule")
+	}
+	w.WriteString(`</form>`)
+}
+
+const MaxScheduleMessageLen = 256
+
+func URLScheduleHandler(w *http.Response, r *http.Request) error {
+	defer trace.End(trace.Begin(""))
+
+	if err := r.ParseForm(); err != nil {
+		return http.ClientError(err)
+	}
+
+	path := r.Form.Get("Path")
+
+	var u URL
+	if _, err := GetOwnedURLFromRequest(r, path, &u); err != nil {
+		return err
+	}
+
+	notBefore, err := ParseDateTimeInput(r.Form.Get("NotBefore"))
+	if err != nil {
+		return URLPage(w, r, path, http.BadRequest(Ls(GL, "provided activation time is incorrect")))
+	}
+	notAfter, err := ParseDateTimeInput(r.Form.Get("NotAfter"))
+	if err != nil {
+		return URLPage(w, r, path, http.BadRequest(Ls(GL, "provided deactivation time is incorrect")))
+	}
+	if (notBefore != 0) && (notAfter != 0) && (notAfter < notBefore) {
+		return URLPage(w, r, path, http.BadRequest(Ls(GL, "link must be activated before it is deactivated")))
+	}
+
+	scheduleURL := r.Form.Get("ScheduleURL")
+	if err := URLFallbackValid(GL, scheduleURL); err != nil {
+		return URLPage(w, r, path, err)
+	}
+
+	scheduleMessage := r.Form.Get("ScheduleMessage")
+	if len(scheduleMessage) > MaxScheduleMessageLen {
+		return URLPage(w, r, path, http.BadRequest(Ls(GL, "length of the message must not exceed %d characters"), MaxScheduleMessageLen))
+	}
+
+	if err := UpdateURL(path, func(u *URL) error {
+		u.NotBefore = notBefore
+		u.NotAfter = notAfter
+		u.ScheduleURL = CloneString(scheduleURL)
+		u.ScheduleMessage = CloneString(scheduleMessage)
+		return nil
+	}); err != nil {
+		return http.ServerError(err)
+	}
+
+	w.Redirect("/url/"+path, http.StatusSeeOther)
+	return nil
+}
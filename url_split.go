@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"math/rand/v2"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/anton2920/gofa/net/http"
+	"github.com/anton2920/gofa/time"
+	"github.com/anton2920/gofa/trace"
+)
+
+/* URLTarget is one of the destinations of a link that splits its traffic. */
+type URLTarget struct {
+	RawURL    string
+	Weight    int32
+	Redirects int64
+}
+
+const (
+	MaxTargets      = 16
+	MaxTargetWeight = 1000
+
+	URLVariantLifetime = 60 * 60 * 24 * 30
+)
+
+/* URLVariantCookieName returns name of the cookie that pins visitor to one of the targets of link 'path'. */
+func URLVariantCookieName(path string) string {
+	return "Variant-" + path
+}
+
+/* URLWeightedVariant returns index of the target 'n' falls into when targets take up [0, sum of weights) in order. If all weights are zero, the last target is chosen. */
+func URLWeightedVariant(targets []URLTarget, n int) int {
+	for i := 0; i < len(targets); i++ {
+		n -= int(targets[i].Weight)
+		if n < 0 {
+			return i
+		}
+	}
+	return len(targets) - 1
+}
+
+/* URLChooseVariant returns index of the target client should be redirected to or -1 if link does not split traffic. */
+func URLChooseVariant(w *http.Response, r *http.Request, path string, url *URL) int {
+	defer trace.End(trace.Begin(""))
+
+	if len(url.Targets) == 0 {
+		return -1
+	}
+
+	if url.Flags&FlagStickyVariant == FlagStickyVariant {
+		variant, err := strconv.Atoi(r.Cookie(URLVariantCookieName(path)))
+		if (err == nil) && (variant >= 0) && (variant < len(url.Targets)) && (url.Targets[variant].Weight > 0) {
+			return variant
+		}
+	}
+
+	var total int
+	for i := 0; i < len(url.Targets); i++ {
+		total += int(url.Targets[i].Weight)
+	}
+
+	var n int
+	if total > 0 {
+		n = rand.IntN(total)
+	}
+	variant := URLWeightedVariant(url.Targets, n)
+
+	if url.Flags&FlagStickyVariant == FlagStickyVariant {
+		expiry := time.Unix() + URLVariantLifetime
+		SetCookie(w, URLVariantCookieName(path), strconv.Itoa(variant), expiry)
+	}
+
+	return variant
+}
+
+/* ParseURLTargets parses lines of the form '<weight> <URL>'. */
+func ParseURLTargets(l Language, s string) ([]URLTarget, error) {
+	var targets []URLTarget
+
+	sc := bufio.NewScanner(strings.NewReader(s))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+
+		weightString, rawURL, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, http.BadRequest(Ls(l, "target must be specified as weight followed by URL: %q"), line)
+		}
+		rawURL = strings.TrimSpace(rawURL)
+
+		weight, err := strconv.Atoi(weightString)
+		if (err != nil) || (weight < 0) || (weight > MaxTargetWeight) {
+			return nil, http.BadRequest(Ls(l, "weight of the target must be between %d and %d"), 0, MaxTargetWeight)
+		}
+		if (len(rawURL) == 0) || (len(rawURL) > GetConfig().MaxURLLen) {
+			return nil, http.BadRequest(Ls(l, "length of the URL must be between %d and %d characters"), MinURLLen, GetConfig().MaxURLLen)
+		}
+		if _, err := url.Parse(rawURL); err != nil {
+			return nil, http.BadRequest(Ls(l, "provided URL is incorrect: %v"), err)
+		}
+
+		if len(targets) == MaxTargets {
+			return nil, http.BadRequest(Ls(l, "number of targets must not exceed %d"), MaxTargets)
+		}
+		targets = append(targets, URLTarget{RawURL: CloneString(rawURL), Weight: int32(weight)})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, http.ClientError(err)
+	}
+
+	return targets, nil
+}
+
+func DisplayURLTargets(w *http.Response, url *URL) {
+	if len(url.Targets) == 0 {
+		return
+	}
+
+	var total int64
+	for i := 0; i < len(url.Targets); i++ {
+		total += url.Targets[i].Redirects
+	}
+
+	w.WriteString(`<table><tr><th>`)
+	w.WriteString(Ls(GL, "Variant"))
+	w.WriteString(`</th><th>`)
+	w.WriteString(Ls(GL, "Weight"))
+	w.WriteString(`</th><th>`)
+	w.WriteString(Ls(GL, "Redirects"))
+	w.WriteString(`</th><th>%</th></tr>`)
+	for i := 0; i < len(url.Targets); i++ {
+		target := &url.Targets[i]
+
+		w.WriteString(`<tr><td>`)
+		w.WriteHTMLString(target.RawURL)
+		w.WriteString(`</td><td>`)
+		w.WriteInt(int(target.Weight))
+		w.WriteString(`</td><td>`)
+		w.WriteInt(int(target.Redirects))
+		w.WriteString(`</td><td>`)
+		if total > 0 {
+			w.WriteInt(int(target.Redirects * 100 / total))
+		} else {
+			w.WriteInt(0)
+		}
+		w.WriteString(`</td></tr>`)
+	}
+	w.WriteString(`</table>`)
+}
+
+func DisplayURLTargetsForm(w *http.Response, path string, url *URL) {
+	w.WriteString(`<h3>`)
+	w.WriteString(Ls(GL, "Split testing"))
+	w.WriteString(`</h3>`)
+
+	DisplayURLTargets(w, url)
+
+	w.WriteString(`<form method="POST" action="` + APIPrefix + `/url/targets">`)
+	{
+		DisplayHiddenInput(w, "Path", path)
+
+		var buf strings.Builder
+		for i := 0; i < len(url.Targets); i++ {
+			buf.WriteString(strconv.Itoa(int(url.Targets[i].Weight)))
+			buf.WriteString(" ")
+			buf.WriteString(url.Targets[i].RawURL)
+			buf.WriteString("\n")
+		}
+
+		DisplayLabel(w, GL, "Targets, one per line as 'weight URL'")
+		DisplayTextarea(w, "Targets", MaxTargets, buf.String())
+		w.WriteString(`<br><br>`)
+
+		w.WriteString(`<label>`)
+		DisplayCheckbox(w, "Sticky", url.Flags&FlagStickyVariant == FlagStickyVariant)
+		w.WriteString(` `)
+		w.WriteString(Ls(GL, "Keep visitor on the same variant"))
+		w.WriteString(`</label>`)
+		w.WriteString(`<br><br>`)
+
+		DisplaySubmit(w, GL, "", "Save")
+	}
+	w.WriteString(`</form>`)
+}
+
+func URLTargetsHandler(w *http.Response, r *http.Request) error {
+	defer trace.End(trace.Begin(""))
+
+	if err := r.ParseForm(); err != nil {
+		return http.ClientError(err)
+	}
+
+	path := r.Form.Get("Path")
+
+	var u URL
+	if _, err := GetOwnedURLFromRequest(r, path, &u); err != nil {
+		return err
+	}
+
+	targets, err := ParseURLTargets(GL, r.Form.Get("Targets"))
+	if err != nil {
+		return URLPage(w, r, path, err)
+	}
+	sticky := r.Form.Get("Sticky") != ""
+
+	if err := UpdateURL(path, func(u *URL) error {
+		/* NOTE(anton2920): keep statistics of the targets that survived editing. */
+		for i := 0; i < len(targets); i++ {
+			for j := 0; j < len(u.Targets); j++ {
+				if targets[i].RawURL == u.Targets[j].RawURL {
+					targets[i].Redirects = u.Targets[j].Redirects
+					break
+				}
+			}
+		}
+		u.Targets = targets
+
+		if sticky {
+			u.Flags |= FlagStickyVariant
+		} else {
+			u.Flags &^= FlagStickyVariant
+		}
+		return nil
+	}); err != nil {
+		return http.ServerError(err)
+	}
+
+	w.Redirect("/url/"+path, http.StatusSeeOther)
+	return nil
+}
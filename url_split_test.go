@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/anton2920/gofa/net/http"
+)
+
+func TestURLWeightedVariant(t *testing.T) {
+	targets := []URLTarget{{Weight: 1}, {Weight: 0}, {Weight: 3}, {Weight: 2}}
+
+	tests := [...]struct {
+		N       int
+		Variant int
+	}{
+		{0, 0},
+		{1, 2},
+		{3, 2},
+		{4, 3},
+		{5, 3},
+	}
+	for _, test := range tests {
+		if variant := URLWeightedVariant(targets, test.N); variant != test.Variant {
+			t.Errorf("URLWeightedVariant(%d) = %d, expected %d", test.N, variant, test.Variant)
+		}
+	}
+
+	if variant := URLWeightedVariant([]URLTarget{{Weight: 0}, {Weight: 0}}, 0); variant != 1 {
+		t.Errorf("URLWeightedVariant() with zero weights = %d, expected last target", variant)
+	}
+}
+
+func TestURLChooseVariantWeights(t *testing.T) {
+	url := URL{Targets: []URLTarget{{RawURL: "a", Weight: 1}, {RawURL: "b", Weight: 0}, {RawURL: "c", Weight: 3}}}
+
+	const n = 4000
+	var counts [3]int
+	for i := 0; i < n; i++ {
+		var w http.Response
+		var r http.Request
+		counts[URLChooseVariant(&w, &r, "split", &url)]++
+	}
+
+	if counts[1] != 0 {
+		t.Errorf("target with zero weight was chosen %d times", counts[1])
+	}
+	/* NOTE(anton2920): expected shares are 1000 and 3000, bounds are far enough to never fail by chance. */
+	if (counts[0] < 800) || (counts[0] > 1200) || (counts[2] < 2800) || (counts[2] > 3200) {
+		t.Errorf("targets are chosen %v times, expected about [1000 0 3000]", counts)
+	}
+}
+
+func TestURLChooseTarget(t *testing.T) {
+	url := URL{
+		RawURL: "https://example.com/default",
+		DeviceRules: []URLDeviceRule{
+			{Platform: PlatformMobile, RawURL: "https://example.com/mobile"},
+		},
+		GeoRules: []URLGeoRule{
+			{Kind: GeoRuleCountry, Values: []string{"DE", "AT"}, RawURL: "https://example.de"},
+			{Kind: GeoRuleLanguage, Values: []string{"fr"}, RawURL: "https://example.fr"},
+		},
+	}
+	split := URL{
+		RawURL:  "https://example.com/default",
+		Targets: []URLTarget{{RawURL: "https://example.com/a", Weight: 0}, {RawURL: "https://example.com/b", Weight: 5}},
+	}
+
+	tests := [...]struct {
+		Name    string
+		URL     *URL
+		Visitor Visitor
+		Target  string
+		Variant int
+	}{
+		{"no rules match", &url, Visitor{Platform: PlatformLinux, Country: "US", Languages: []string{"en-us"}}, "https://example.com/default", -1},
+		{"device rule", &url, Visitor{Platform: PlatformIOS, Country: "DE"}, "https://example.com/mobile", -1},
+		{"country rule", &url, Visitor{Platform: PlatformWindows, Country: "AT"}, "https://example.de", -1},
+		{"language rule", &url, Visitor{Platform: PlatformMacOS, Languages: []string{"fr-ca", "en"}}, "https://example.fr", -1},
+		{"only most preferred language", &url, Visitor{Languages: []string{"en", "fr"}}, "https://example.com/default", -1},
+		{"split", &split, Visitor{}, "https://example.com/b", 1},
+	}
+	for _, test := range tests {
+		var w http.Response
+		var r http.Request
+
+		target, variant := URLChooseTarget(&w, &r, "code", test.URL, &test.Visitor)
+		if (target != test.Target) || (variant != test.Variant) {
+			t.Errorf("%s: URLChooseTarget() = (%q, %d), expected (%q, %d)", test.Name, target, variant, test.Target, test.Variant)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/anton2920/gofa/net/http"
+	"github.com/anton2920/gofa/trace"
+)
+
+/* PermanentRedirectMaxAge is how long clients may cache permanent redirects, in seconds. */
+const PermanentRedirectMaxAge = 60 * 60 * 24
+
+var RedirectStatuses = [...]http.Status{
+	http.StatusMovedPermanently,
+	http.StatusFound,
+	http.StatusSeeOther,
+	http.StatusTemporaryRedirect,
+	http.StatusPermanentRedirect,
+}
+
+var RedirectStatus2String = map[http.Status]string{
+	http.StatusMovedPermanently:  "301 Moved Permanently",
+	http.StatusFound:             "302 Found",
+	http.StatusSeeOther:          "303 See Other",
+	http.StatusTemporaryRedirect: "307 Temporary Redirect",
+	http.StatusPermanentRedirect: "308 Permanent Redirect",
+}
+
+func RedirectStatusPermanent(status http.Status) bool {
+	return (status == http.StatusMovedPermanently) || (status == http.StatusPermanentRedirect)
+}
+
+func URLRedirectStatus(url *URL) http.Status {
+	if url.StatusCode == 0 {
+		return GetConfig().DefaultRedirectStatus
+	}
+	return url.StatusCode
+}
+
+/*
+ * URLCacheable reports whether redirect of 'url' is the same for every visitor at any time, so shared caches may keep it.
+ * Passwords, rules, limits and time bounds are checked on every visit, cached redirect would skip them.
+ */
+func URLCacheable(url *URL) bool {
+	return (len(url.PasswordHash) == 0) && (len(url.Targets) == 0) && (len(url.DeviceRules) == 0) && (len(url.GeoRules) == 0) &&
+		(url.Flags&FlagPassthrough == 0) && (url.MaxRedirects == 0) && (url.NotBefore == 0) && (url.NotAfter == 0) && (url.ExpiresAt == 0)
+}
+
+/* URLRedirect redirects client to 'target' and tells caches whether they may remember it. Only permanent redirects marked 'cacheable' are. */
+func URLRedirect(w *http.Response, target string, status http.Status, cacheable bool) {
+	defer trace.End(trace.Begin(""))
+
+	if (RedirectStatusPermanent(status)) && (cacheable) {
+		w.Headers.Set("Cache-Control", "public, max-age="+strconv.Itoa(PermanentRedirectMaxAge))
+	} else {
+		/* NOTE(anton2920): every redirect must reach us, otherwise statistics and rules lie. */
+		w.Headers.Set("Cache-Control", "private, no-store")
+	}
+	w.Redirect(target, status)
+}
+
+func DisplayURLStatusForm(w *http.Response, path string, url *URL) {
+	w.WriteString(`<h3>`)
+	w.WriteString(Ls(GL, "Redirect status"))
+	w.WriteString(`</h3>`)
+
+	w.WriteString(`<form method="POST" action="` + APIPrefix + `/url/status">`)
+	{
+		DisplayHiddenInput(w, "Path", path)
+
+		w.WriteString(`<select name="StatusCode"><option value="0">`)
+		w.WriteString(Ls(GL, "Default"))
+		w.WriteString(` (`)
+		w.WriteString(RedirectStatus2String[GetConfig().DefaultRedirectStatus])
+		w.WriteString(`)</option>`)
+		for _, status := range RedirectStatuses {
+			w.WriteString(`<option value="`)
+			w.WriteInt(int(status))
+			w.WriteString(`"`)
+			if status == url.StatusCode {
+				w.WriteString(` selected`)
+			}
+			w.WriteString(`>`)
+			w.WriteString(RedirectStatus2String[status])
+			w.WriteString(`</option>`)
+		}
+		w.WriteString(`</select>`)
+		w.WriteString(`<br><br>`)
+
+		if url.Flags&FlagServedPermanent == FlagServedPermanent {
+			w.WriteString(`<label>`)
+			DisplayCheckbox(w, "Confirm", false)
+			w.WriteString(` `)
+			w.WriteString(Ls(GL, "I understand that clients may keep using cached permanent redirect"))
+			w.WriteString(`</label>`)
+			w.WriteString(`<br><br>`)
+		}
+
+		DisplaySubmit(w, GL, "", "Save")
+	}
+	w.WriteString(`</form>`)
+}
+
+func URLStatusHandler(w *http.Response, r *http.Request) error {
+	defer trace.End(trace.Begin(""))
+
+	if err := r.ParseForm(); err != nil {
+		return http.ClientError(err)
+	}
+
+	path := r.Form.Get("Path")
+
+	var u URL
+	if _, err := GetOwnedURLFromRequest(r, path, &u); err != nil {
+		return err
+	}
+
+	code, err := strconv.Atoi(r.Form.Get("StatusCode"))
+	if err != nil {
+		return URLPage(w, r, path, http.BadRequest(Ls(GL, "status code must be an integer")))
+	}
+	status := http.Status(code)
+	if _, ok := RedirectStatus2String[status]; (!ok) && (status != 0) {
+		return URLPage(w, r, path, http.BadRequest(Ls(GL, "status code must be one of 301, 302, 303, 307 or 308")))
+	}
+
+	effective := status
+	if effective == 0 {
+		effective = GetConfig().DefaultRedirectStatus
+	}
+
+	/* NOTE(anton2920): browsers remember permanent redirects, so going back to temporary one may not have any effect for them. */
+	if (u.Flags&FlagServedPermanent == FlagServedPermanent) && (!RedirectStatusPermanent(effective)) && (r.Form.Get("Confirm") == "") {
+		return URLPage(w, r, path, http.Conflict(Ls(GL, "this link has already been served as permanent redirect and clients may have cached it, confirm the change to proceed")))
+	}
+
+	if err := UpdateURL(path, func(u *URL) error {
+		u.StatusCode = status
+		return nil
+	}); err != nil {
+		return http.ServerError(err)
+	}
+
+	w.Redirect("/url/"+path, http.StatusSeeOther)
+	return nil
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/anton2920/gofa/database"
+	"github.com/anton2920/gofa/net/http"
+	"github.com/anton2920/gofa/trace"
+)
+
+/* URLListItem is how link is represented in responses of the API. */
+type URLListItem struct {
+	Code      string   `json:"code"`
+	Target    string   `json:"target"`
+	Title     string   `json:"title"`
+	Note      string   `json:"note"`
+	Tags      []string `json:"tags"`
+	Folder    string   `json:"folder"`
+	Redirects int64    `json:"redirects"`
+	Deleted   bool     `json:"deleted"`
+}
+
+const (
+	MaxTagLen     = 32
+	MaxTags       = 16
+	MaxFolderLen  = 128
+	MaxFolderPath = 8
+
+	MaxTitleLen = 128
+	MaxNoteLen  = 1024
+)
+
+/* ParseTags parses comma-separated list of tags. Tags are case-insensitive and stored in lowercase. */
+func ParseTags(l Language, s string) ([]string, error) {
+	var tags []string
+
+	for _, tag := range strings.Split(s, ",") {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			continue
+		}
+		if len(tag) > MaxTagLen {
+			return nil, http.BadRequest(Ls(l, "length of the tag must not exceed %d characters"), MaxTagLen)
+		}
+		if slices.Contains(tags, tag) {
+			continue
+		}
+		if len(tags) == MaxTags {
+			return nil, http.BadRequest(Ls(l, "number of tags must not exceed %d"), MaxTags)
+		}
+		tags = append(tags, CloneString(tag))
+	}
+	slices.Sort(tags)
+
+	return tags, nil
+}
+
+/* ParseFolder normalizes folder path like '/marketing//2026/' into 'marketing/2026'. */
+func ParseFolder(l Language, s string) (string, error) {
+	var parts []string
+
+	for _, part := range strings.Split(s, "/") {
+		part = strings.TrimSpace(part)
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) > MaxFolderPath {
+		return "", http.BadRequest(Ls(l, "folders must not be nested deeper than %d levels"), MaxFolderPath)
+	}
+
+	folder := strings.Join(parts, "/")
+	if len(folder) > MaxFolderLen {
+		return "", http.BadRequest(Ls(l, "length of the folder must not exceed %d characters"), MaxFolderLen)
+	}
+	return CloneString(folder), nil
+}
+
+/* URLInFolder returns true if link is in 'folder' or any of its subfolders. Empty folder contains all links. */
+func URLInFolder(url *URL, folder string) bool {
+	return (folder == "") || (url.Folder == folder) || (strings.HasPrefix(url.Folder, folder+"/"))
+}
+
+func URLMatchesFilter(url *URL, tag string, folder string) bool {
+	return ((tag == "") || (slices.Contains(url.Tags, tag))) && (URLInFolder(url, folder))
+}
+
+/* URLDisplayTitle returns title set by owner or, if there is none, the one of destination page. */
+func URLDisplayTitle(url *URL) string {
+	if url.Title != "" {
+		return url.Title
+	}
+	return url.Metadata.Title
+}
+
+func URLToListItem(path string, url *URL) URLListItem {
+	return URLListItem{
+		Code:      path,
+		Target:    url.RawURL,
+		Title:     URLDisplayTitle(url),
+		Note:      url.Note,
+		Tags:      url.Tags,
+		Folder:    url.Folder,
+		Redirects: url.Redirects,
+		Deleted:   url.Flags&FlagDeleted == FlagDeleted,
+	}
+}
+
+func DisplayURLOrganizeForm(w *http.Response, path string, url *URL) {
+	w.WriteString(`<h3>`)
+	w.WriteString(Ls(GL, "Organization"))
+	w.WriteString(`</h3>`)
+
+	w.WriteString(`<form method="POST" action="` + APIPrefix + `/url/organize">`)
+	{
+		DisplayHiddenInput(w, "Path", path)
+
+		DisplayLabel(w, GL, "Title")
+		DisplayConstraintInput(w, "text", 0, MaxTitleLen, "Title", url.Title, false)
+		w.WriteString(`<br><br>`)
+
+		DisplayLabel(w, GL, "Note")
+		DisplayTextarea(w, "Note", 4, url.Note)
+		w.WriteString(`<br><br>`)
+
+		DisplayLabel(w, GL, "Tags, comma-separated")
+		DisplayConstraintInput(w, "text", 0, MaxTags*(MaxTagLen+1), "Tags", strings.Join(url.Tags, ", "), false)
+		w.WriteString(`<br><br>`)
+
+		DisplayLabel(w, GL, "Folder")
+		DisplayConstraintInput(w, "text", 0, MaxFolderLen, "Folder", url.Folder, false)
+		w.WriteString(`<br><br>`)
+
+		DisplaySubmit(w, GL, "", "Save")
+	}
+	w.WriteString(`</form>`)
+}
+
+func URLOrganizeHandler(w *http.Response, r *http.Request) error {
+	defer trace.End(trace.Begin(""))
+
+	if err := r.ParseForm(); err != nil {
+		return http.ClientError(err)
+	}
+
+	path := r.Form.Get("Path")
+
+	var u URL
+	if _, err := GetOwnedURLFromRequest(r, path, &u); err != nil {
+		return err
+	}
+
+	title := r.Form.Get("Title")
+	if len(title) > MaxTitleLen {
+		return URLPage(w, r, path, http.BadRequest(Ls(GL, "length of the title must not exceed %d characters"), MaxTitleLen))
+	}
+	note := r.Form.Get("Note")
+	if len(note) > MaxNoteLen {
+		return URLPage(w, r, path, http.BadRequest(Ls(GL, "length of the note must not exceed %d characters"), MaxNoteLen))
+	}
+
+	tags, err := ParseTags(GL, r.Form.Get("Tags"))
+	if err != nil {
+		return URLPage(w, r, path, err)
+	}
+	folder, err := ParseFolder(GL, r.Form.Get("Folder"))
+	if err != nil {
+		return URLPage(w, r, path, err)
+	}
+
+	if err := UpdateURL(path, func(u *URL) error {
+		u.Title = CloneString(title)
+		u.Note = CloneString(note)
+		u.Tags = tags
+		u.Folder = folder
+		return nil
+	}); err != nil {
+		return http.ServerError(err)
+	}
+
+	w.Redirect("/url/"+path, http.StatusSeeOther)
+	return nil
+}
+
+/* DisplayUserURLs displays user's links matching 'Tag' and 'Folder' filters with controls for bulk tagging. */
+func DisplayUserURLs(w *http.Response, r *http.Request, id database.ID) {
+	defer trace.End(trace.Begin(""))
+
+	tag := strings.ToLower(r.Form.Get("Tag"))
+	folder, _ := ParseFolder(GL, r.Form.Get("Folder"))
+
+	w.WriteString(`<form method="GET" action="/user/`)
+	w.WriteID(id)
+	w.WriteString(`">`)
+	{
+		w.WriteString(Ls(GL, "Tag"))
+		w.WriteString(`: `)
+		DisplayConstraintInput(w, "text", 0, MaxTagLen, "Tag", tag, false)
+		w.WriteString(` `)
+		w.WriteString(Ls(GL, "Folder"))
+		w.WriteString(`: `)
+		DisplayConstraintInput(w, "text", 0, MaxFolderLen, "Folder", folder, false)
+		w.WriteString(` `)
+		DisplaySubmit(w, GL, "", "Filter")
+	}
+	w.WriteString(`</form>`)
+
+	w.WriteString(`<form method="POST" action="` + APIPrefix + `/url/tag">`)
+	{
+		var url URL
+
+		w.WriteString(`<ul>`)
+		for _, path := range GetURLPathsByUserID(id) {
+			if (GetURLByPath(path, &url) != nil) || (!URLMatchesFilter(&url, tag, folder)) {
+				continue
+			}
+
+			w.WriteString(`<li>`)
+			DisplayCheckbox(w, "Select-"+path, false)
+			w.WriteString(` <a href="/url/`)
+			w.WriteString(path)
+			w.WriteString(`">`)
+			w.WriteString(path)
+			w.WriteString(`</a> &rarr; `)
+			w.WriteHTMLString(url.RawURL)
+			if title := URLDisplayTitle(&url); title != "" {
+				w.WriteString(` &mdash; `)
+				w.WriteHTMLString(title)
+			}
+			if url.Folder != "" {
+				w.WriteString(` [`)
+				w.WriteHTMLString(url.Folder)
+				w.WriteString(`]`)
+			}
+			for _, tag := range url.Tags {
+				w.WriteString(` #`)
+				w.WriteHTMLString(tag)
+			}
+			if url.Flags&FlagDeleted == FlagDeleted {
+				w.WriteString(` (`)
+				w.WriteString(Ls(GL, "deleted"))
+				w.WriteString(`)`)
+			}
+			w.WriteString(`</li>`)
+		}
+		w.WriteString(`</ul>`)
+
+		w.WriteString(Ls(GL, "Selected"))
+		w.WriteString(`: `)
+		DisplayConstraintInput(w, "text", 0, MaxTagLen, "BulkTag", "", true)
+		w.WriteString(` `)
+		DisplaySubmit(w, GL, "Action", "Add tag")
+		w.WriteString(` `)
+		DisplaySubmit(w, GL, "Action", "Remove tag")
+	}
+	w.WriteString(`</form>`)
+}
+
+/* DisplayUserTagStats displays statistics of user's links aggregated by tags and folders. */
+func DisplayUserTagStats(w *http.Response, id database.ID) {
+	defer trace.End(trace.Begin(""))
+
+	type group struct {
+		Name      string
+		Links     int
+		Redirects int64
+	}
+	var tags, folders []group
+
+	add := func(groups []group, name string, u *URL) []group {
+		i := slices.IndexFunc(groups, func(g group) bool { return g.Name == name })
+		if i == -1 {
+			groups = append(groups, group{Name: name})
+			i = len(groups) - 1
+		}
+		groups[i].Links++
+		groups[i].Redirects += u.Redirects
+		return groups
+	}
+
+	var u URL
+	for _, path := range GetURLPathsByUserID(id) {
+		if GetURLByPath(path, &u) != nil {
+			continue
+		}
+
+		for _, tag := range u.Tags {
+			tags = add(tags, tag, &u)
+		}
+
+		/* NOTE(anton2920): link counts towards its folder and all folders above it. */
+		for folder := u.Folder; folder != ""; {
+			folders = add(folders, folder, &u)
+
+			i := strings.LastIndexByte(folder, '/')
+			if i == -1 {
+				break
+			}
+			folder = folder[:i]
+		}
+	}
+
+	for _, stats := range [...]struct {
+		Title  string
+		Filter string
+		Groups []group
+	}{
+		{"Tags", "Tag", tags},
+		{"Folders", "Folder", folders},
+	} {
+		if len(stats.Groups) == 0 {
+			continue
+		}
+		slices.SortFunc(stats.Groups, func(a, b group) int { return strings.Compare(a.Name, b.Name) })
+
+		w.WriteString(`<h3>`)
+		w.WriteString(Ls(GL, stats.Title))
+		w.WriteString(`</h3>`)
+
+		w.WriteString(`<table><tr><th></th><th>`)
+		w.WriteString(Ls(GL, "Links"))
+		w.WriteString(`</th><th>`)
+		w.WriteString(Ls(GL, "Redirects"))
+		w.WriteString(`</th></tr>`)
+		for i := 0; i < len(stats.Groups); i++ {
+			g := &stats.Groups[i]
+
+			w.WriteString(`<tr><td><a href="/user/`)
+			w.WriteID(id)
+			w.WriteString(`?`)
+			w.WriteString(stats.Filter)
+			w.WriteString(`=`)
+			w.WriteHTMLString(url.QueryEscape(g.Name))
+			w.WriteString(`">`)
+			w.WriteHTMLString(g.Name)
+			w.WriteString(`</a></td><td>`)
+			w.WriteInt(g.Links)
+			w.WriteString(`</td><td>`)
+			w.WriteInt(int(g.Redirects))
+			w.WriteString(`</td></tr>`)
+		}
+		w.WriteString(`</table>`)
+	}
+}
+
+/* URLBulkTagHandler adds tag to or removes it from all links selected with 'Select-<code>' checkboxes. */
+func URLBulkTagHandler(w *http.Response, r *http.Request) error {
+	defer trace.End(trace.Begin(""))
+
+	session, err := GetSessionFromRequest(r)
+	if err != nil {
+		return http.UnauthorizedError
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return http.ClientError(err)
+	}
+
+	tags, err := ParseTags(GL, r.Form.Get("BulkTag"))
+	if err != nil {
+		return err
+	}
+	if len(tags) != 1 {
+		return http.BadRequest(Ls(GL, "exactly one tag must be specified"))
+	}
+	tag := tags[0]
+
+	var remove bool
+	switch r.Form.Get("Action") {
+	default:
+		return http.BadRequest(Ls(GL, "unknown action"))
+	case "Add tag", Ls(GL, "Add tag"):
+	case "Remove tag", Ls(GL, "Remove tag"):
+		remove = true
+	}
+
+	var paths []string
+	for _, path := range GetURLPathsByUserID(session.ID) {
+		if r.Form.Get("Select-"+path) != "" {
+			paths = append(paths, path)
+		}
+	}
+
+	/* NOTE(anton2920): links are changed all at once, so failure on one of them leaves others as they were. */
+	if err := UpdateURLs(paths, func(u *URL) error {
+		i := slices.Index(u.Tags, tag)
+		switch {
+		case (remove) && (i >= 0):
+			u.Tags = slices.Delete(slices.Clone(u.Tags), i, i+1)
+		case (!remove) && (i == -1):
+			if len(u.Tags) == MaxTags {
+				return http.BadRequest(Ls(GL, "number of tags must not exceed %d"), MaxTags)
+			}
+			u.Tags = append(slices.Clone(u.Tags), tag)
+			slices.Sort(u.Tags)
+		}
+		return nil
+	}); err != nil {
+		if _, ok := err.(http.Error); ok {
+			return err
+		}
+		return http.ServerError(err)
+	}
+
+	w.Redirect("/user/"+strconv.Itoa(int(session.ID)), http.StatusSeeOther)
+	return nil
+}
+
+/* URLListHandler returns JSON list of signed in user's links, optionally filtered by 'Tag' and 'Folder'. */
+func URLListHandler(w *http.Response, r *http.Request) error {
+	defer trace.End(trace.Begin(""))
+
+	session, err := GetSessionFromRequest(r)
+	if err != nil {
+		return http.UnauthorizedError
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return http.ClientError(err)
+	}
+
+	tag := strings.ToLower(r.Form.Get("Tag"))
+	folder, err := ParseFolder(GL, r.Form.Get("Folder"))
+	if err != nil {
+		return err
+	}
+
+	items := make([]URLListItem, 0)
+	var url URL
+	for _, path := range GetURLPathsByUserID(session.ID) {
+		if (GetURLByPath(path, &url) == nil) && (URLMatchesFilter(&url, tag, folder)) {
+			items = append(items, URLToListItem(path, &url))
+		}
+	}
+
+	return WriteJSON(w, items)
+}
@@ -22,18 +22,23 @@ type User struct {
 	Password  string
 	CreatedOn int64
 
+	/* FallbackURL is used for user's links that have no fallback of their own. */
+	FallbackURL string
+
+	CampaignPresets []CampaignPreset
+
 	URLs []database.ID
 }
 
+/* FlagUserDisabled is set on users who are not allowed to sign in. */
+const FlagUserDisabled int32 = 1
+
 const (
 	MinUserNameLen = 1
 	MaxUserNameLen = 64
 
 	MinEmailLen = 1
 	MaxEmailLen = 128
-
-	MinPasswordLen = 5
-	MaxPasswordLen = 64
 )
 
 func UserNameValid(l Language, name string) error {
@@ -68,32 +73,6 @@ var TestUser = User{
 	CreatedOn: int64(time.Unix()),
 }
 
-func GetUserByEmail(email string, user *User) error {
-	if email != TestUser.Email {
-		return database.NotFound
-	}
-
-	*user = TestUser
-	return nil
-}
-
-func GetUserByID(id database.ID, user *User) error {
-	if id != TestUser.ID {
-		return database.NotFound
-	}
-
-	*user = TestUser
-	return nil
-}
-
-func CreateUser(user *User) error {
-	return nil
-}
-
-func SaveUser(user *User) error {
-	return nil
-}
-
 func DisplayUserTitle(w *http.Response, user *User) {
 	w.WriteHTMLString(user.LastName)
 	w.WriteString(` `)
@@ -120,6 +99,10 @@ func UserPage(w *http.Response, r *http.Request) error {
 		return http.ServerError(err)
 	}
 
+	if err := r.ParseForm(); err != nil {
+		return http.ClientError(err)
+	}
+
 	DisplayHTMLStart(w)
 
 	DisplayHeadStart(w)
@@ -151,6 +134,25 @@ func UserPage(w *http.Response, r *http.Request) error {
 		w.WriteString(`<h3>`)
 		w.WriteString(Ls(GL, "Shortened links"))
 		w.WriteString(`</h3>`)
+
+		if session, err := GetSessionFromRequest(r); (err == nil) && (session.ID == user.ID) {
+			DisplayUserSearch(w, r, session)
+			DisplayUserBulkLinks(w)
+			DisplayUserURLs(w, r, user.ID)
+			DisplayUserTagStats(w, user.ID)
+			DisplayUserCampaigns(w, user.ID)
+			DisplayUserCampaignPresets(w, &user)
+
+			w.WriteString(`<form method="POST" action="` + APIPrefix + `/user/fallback">`)
+			{
+				DisplayLabel(w, GL, "Default fallback URL")
+				DisplayConstraintInput(w, "text", 0, GetConfig().MaxURLLen, "FallbackURL", user.FallbackURL, false)
+				w.WriteString(`<br><br>`)
+
+				DisplaySubmit(w, GL, "", "Save")
+			}
+			w.WriteString(`</form>`)
+		}
 	}
 	DisplayBodyEnd(w)
 
@@ -192,7 +194,7 @@ func UserSigninPage(w *http.Response, r *http.Request, ierr error) error {
 			w.WriteString(`<br><br>`)
 
 			DisplayLabel(w, GL, "Password")
-			DisplayConstraintInput(w, "password", MinPasswordLen, MaxPasswordLen, "Password", "", true)
+			DisplayConstraintInput(w, "password", GetConfig().MinPasswordLen, GetConfig().MaxPasswordLen, "Password", "", true)
 			w.WriteString(`<br><br>`)
 
 			DisplaySubmit(w, GL, "", title)
@@ -247,11 +249,11 @@ func UserSignupPage(w *http.Response, r *http.Request, ierr error) error {
 			w.WriteString(`<br><br>`)
 
 			DisplayLabel(w, GL, "Password")
-			DisplayConstraintInput(w, "password", MinPasswordLen, MaxPasswordLen, "Password", "", true)
+			DisplayConstraintInput(w, "password", GetConfig().MinPasswordLen, GetConfig().MaxPasswordLen, "Password", "", true)
 			w.WriteString(`<br><br>`)
 
 			DisplayLabel(w, GL, "Repeat Password")
-			DisplayConstraintInput(w, "password", MinPasswordLen, MaxPasswordLen, "RepeatPassword", "", true)
+			DisplayConstraintInput(w, "password", GetConfig().MinPasswordLen, GetConfig().MaxPasswordLen, "RepeatPassword", "", true)
 			w.WriteString(`<br><br>`)
 
 			DisplaySubmit(w, GL, "", title)
@@ -289,12 +291,15 @@ func UserSigninHandler(w *http.Response, r *http.Request) error {
 	if user.Password != password {
 		return UserSigninPage(w, r, http.Conflict(Ls(GL, "provided password is incorrect")))
 	}
+	if user.Flags&FlagUserDisabled == FlagUserDisabled {
+		return UserSigninPage(w, r, http.Conflict(Ls(GL, "this account has been disabled")))
+	}
 
 	token, err := GenerateSessionToken()
 	if err != nil {
 		return http.ServerError(err)
 	}
-	expiry := time.Unix() + OneWeek
+	expiry := time.Unix() + SessionLifetime()
 
 	session := &Session{
 		ID:     user.ID,
@@ -305,11 +310,7 @@ func UserSigninHandler(w *http.Response, r *http.Request) error {
 	Sessions[token] = session
 	SessionsLock.Unlock()
 
-	if Debug {
-		w.SetCookieUnsafe("Token", token, expiry)
-	} else {
-		w.SetCookie("Token", token, expiry)
-	}
+	SetCookie(w, "Token", token, expiry)
 	w.Redirect("/", http.StatusSeeOther)
 	return nil
 }
@@ -360,8 +361,8 @@ func UserSignupHandler(w *http.Response, r *http.Request) error {
 
 	password := r.Form.Get("Password")
 	repeatPassword := r.Form.Get("RepeatPassword")
-	if !strings.LengthInRange(password, MinPasswordLen, MaxPasswordLen) {
-		return UserSignupPage(w, r, http.BadRequest(Ls(GL, "password length must be between %d and %d characters long"), MinPasswordLen, MaxPasswordLen))
+	if !strings.LengthInRange(password, GetConfig().MinPasswordLen, GetConfig().MaxPasswordLen) {
+		return UserSignupPage(w, r, http.BadRequest(Ls(GL, "password length must be between %d and %d characters long"), GetConfig().MinPasswordLen, GetConfig().MaxPasswordLen))
 	}
 	if password != repeatPassword {
 		return UserSignupPage(w, r, http.BadRequest(Ls(GL, "passwords do not match each other")))
@@ -372,10 +373,10 @@ func UserSignupHandler(w *http.Response, r *http.Request) error {
 		return UserSignupPage(w, r, http.Conflict(Ls(GL, "user with this email already exists")))
 	}
 
-	user.FirstName = firstName
-	user.LastName = lastName
-	user.Email = email
-	user.Password = password
+	user.FirstName = CloneString(firstName)
+	user.LastName = CloneString(lastName)
+	user.Email = CloneString(email)
+	user.Password = CloneString(password)
 	user.CreatedOn = int64(time.Unix())
 
 	if err := CreateUser(&user); err != nil {
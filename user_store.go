@@ -0,0 +1,85 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/anton2920/gofa/database"
+	"github.com/anton2920/gofa/trace"
+)
+
+const UsersFile = "users.gob"
+
+/* Users are kept in memory and stored to UsersFile the same way links are, so settings users change, like default fallback URL, survive restarts. */
+var (
+	Users     = map[database.ID]User{TestUser.ID: TestUser}
+	UsersLock sync.RWMutex
+)
+
+func GetUserByEmail(email string, user *User) error {
+	UsersLock.RLock()
+	defer UsersLock.RUnlock()
+
+	for _, u := range Users {
+		if u.Email == email {
+			*user = u
+			return nil
+		}
+	}
+
+	return database.NotFound
+}
+
+func GetUserByID(id database.ID, user *User) error {
+	UsersLock.RLock()
+	u, ok := Users[id]
+	UsersLock.RUnlock()
+	if !ok {
+		return database.NotFound
+	}
+
+	*user = u
+	return nil
+}
+
+func CreateUser(user *User) error {
+	UsersLock.Lock()
+
+	/* NOTE(anton2920): users are disabled, never deleted, so IDs are not reused. */
+	user.ID = database.ID(len(Users) + 1)
+	Users[user.ID] = *user
+
+	UsersLock.Unlock()
+	return nil
+}
+
+func SaveUser(user *User) error {
+	UsersLock.Lock()
+
+	Users[user.ID] = *user
+
+	UsersLock.Unlock()
+	return nil
+}
+
+func StoreUsersToFile(filename string) error {
+	defer trace.End(trace.Begin(""))
+
+	UsersLock.RLock()
+	defer UsersLock.RUnlock()
+
+	return StoreGobToFile(filename, Users)
+}
+
+func RestoreUsersFromFile(filename string) error {
+	defer trace.End(trace.Begin(""))
+
+	users := make(map[database.ID]User)
+	if err := RestoreGobFromFile(filename, &users); err != nil {
+		return err
+	}
+
+	UsersLock.Lock()
+	Users = users
+	UsersLock.Unlock()
+	return nil
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"encoding/json"
 	"math/rand/v2"
 	"strconv"
+	"unicode/utf8"
 
 	"github.com/anton2920/gofa/database"
 	"github.com/anton2920/gofa/net/http"
@@ -26,6 +28,38 @@ func GetIDFromURL(l Language, u url.URL, prefix string) (database.ID, error) {
 	return database.ID(id), nil
 }
 
+/* WriteJSON replaces body of the response with JSON representation of 'v'. */
+func WriteJSON(w *http.Response, v interface{}) error {
+	defer trace.End(trace.Begin(""))
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return http.ServerError(err)
+	}
+
+	w.Headers.Set("Content-Type", "application/json")
+	w.Write(data)
+	return nil
+}
+
+/* CloneString returns copy of 's' that does not share memory with request buffer. */
+func CloneString(s string) string {
+	buffer := make([]byte, len(s))
+	copy(buffer, s)
+	return string(buffer)
+}
+
+/* TruncateString cuts 's' to at most 'n' bytes without splitting UTF-8 sequences. */
+func TruncateString(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for (n > 0) && (!utf8.RuneStart(s[n])) {
+		n--
+	}
+	return s[:n]
+}
+
 func SlicePutRandomBase26(buffer []byte) {
 	const letters = "abcdefghijklmnopqrstuvwxyz"
 
@@ -41,3 +75,12 @@ func SlicePutRandomBase52(buffer []byte) {
 		buffer[i] = letters[rand.Int()%len(letters)]
 	}
 }
+
+/* SetCookie sets cookie that is sent only over HTTPS, unless configured otherwise. */
+func SetCookie(w *http.Response, name string, value string, expiry int) {
+	if GetConfig().SecureCookies {
+		w.SetCookie(name, value, expiry)
+	} else {
+		w.SetCookieUnsafe(name, value, expiry)
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"net/url"
+	"slices"
+	"strconv"
+
+	"github.com/anton2920/gofa/database"
+	"github.com/anton2920/gofa/net/http"
+	"github.com/anton2920/gofa/trace"
+)
+
+/* UTM holds campaign parameters that are added to the target URL. */
+type UTM struct {
+	Campaign string
+	Source   string
+	Medium   string
+	Term     string
+	Content  string
+}
+
+/* CampaignPreset is a named set of UTM parameters saved by user. */
+type CampaignPreset struct {
+	Name string
+	UTM  UTM
+}
+
+const (
+	MaxUTMLen          = 64
+	MaxCampaignPresets = 32
+)
+
+func UTMFromForm(r *http.Request) UTM {
+	return UTM{
+		Campaign: r.Form.Get("UTMCampaign"),
+		Source:   r.Form.Get("UTMSource"),
+		Medium:   r.Form.Get("UTMMedium"),
+		Term:     r.Form.Get("UTMTerm"),
+		Content:  r.Form.Get("UTMContent"),
+	}
+}
+
+func (utm *UTM) Empty() bool {
+	return (utm.Campaign == "") && (utm.Source == "") && (utm.Medium == "") && (utm.Term == "") && (utm.Content == "")
+}
+
+/* Fill sets parameters that are empty in 'utm' to values from 'preset'. */
+func (utm *UTM) Fill(preset *UTM) {
+	if utm.Campaign == "" {
+		utm.Campaign = preset.Campaign
+	}
+	if utm.Source == "" {
+		utm.Source = preset.Source
+	}
+	if utm.Medium == "" {
+		utm.Medium = preset.Medium
+	}
+	if utm.Term == "" {
+		utm.Term = preset.Term
+	}
+	if utm.Content == "" {
+		utm.Content = preset.Content
+	}
+}
+
+func (utm *UTM) Clone() UTM {
+	return UTM{
+		Campaign: CloneString(utm.Campaign),
+		Source:   CloneString(utm.Source),
+		Medium:   CloneString(utm.Medium),
+		Term:     CloneString(utm.Term),
+		Content:  CloneString(utm.Content),
+	}
+}
+
+func UTMValid(l Language, utm *UTM) error {
+	for _, value := range [...]string{utm.Campaign, utm.Source, utm.Medium, utm.Term, utm.Content} {
+		if len(value) > MaxUTMLen {
+			return http.BadRequest(Ls(l, "length of campaign parameters must not exceed %d characters"), MaxUTMLen)
+		}
+	}
+	return nil
+}
+
+/* URLAddUTM sets 'utm_*' query parameters of 'rawURL', replacing the existing ones. */
+func URLAddUTM(rawURL string, utm *UTM) (string, error) {
+	defer trace.End(trace.Begin(""))
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	query := u.Query()
+	for _, param := range [...]struct {
+		Name  string
+		Value string
+	}{
+		{"utm_campaign", utm.Campaign},
+		{"utm_source", utm.Source},
+		{"utm_medium", utm.Medium},
+		{"utm_term", utm.Term},
+		{"utm_content", utm.Content},
+	} {
+		if param.Value != "" {
+			query.Set(param.Name, param.Value)
+		}
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+func FindCampaignPreset(user *User, name string) *CampaignPreset {
+	for i := 0; i < len(user.CampaignPresets); i++ {
+		if user.CampaignPresets[i].Name == name {
+			return &user.CampaignPresets[i]
+		}
+	}
+	return nil
+}
+
+/* SaveCampaignPreset adds preset to user 'id' or replaces the one with the same name. */
+func SaveCampaignPreset(l Language, id database.ID, name string, utm *UTM) error {
+	defer trace.End(trace.Begin(""))
+
+	if len(name) > MaxUTMLen {
+		return http.BadRequest(Ls(l, "length of preset name must not exceed %d characters"), MaxUTMLen)
+	}
+
+	var user User
+	if err := GetUserByID(id, &user); err != nil {
+		return http.ServerError(err)
+	}
+
+	/* NOTE(anton2920): presets are shared with the stored copy of user, so they must not be modified in place. */
+	user.CampaignPresets = slices.Clone(user.CampaignPresets)
+	if preset := FindCampaignPreset(&user, name); preset != nil {
+		preset.UTM = utm.Clone()
+	} else {
+		if len(user.CampaignPresets) == MaxCampaignPresets {
+			return http.BadRequest(Ls(l, "number of campaign presets must not exceed %d"), MaxCampaignPresets)
+		}
+		user.CampaignPresets = append(user.CampaignPresets, CampaignPreset{Name: CloneString(name), UTM: utm.Clone()})
+	}
+
+	if err := SaveUser(&user); err != nil {
+		return http.ServerError(err)
+	}
+	return nil
+}
+
+/* DisplayUTMInputs displays campaign fields of the form that creates links. 'user' may be nil. */
+func DisplayUTMInputs(w *http.Response, r *http.Request, user *User) {
+	w.WriteString(`<fieldset><legend>`)
+	w.WriteString(Ls(GL, "Campaign (optional)"))
+	w.WriteString(`</legend>`)
+
+	if (user != nil) && (len(user.CampaignPresets) > 0) {
+		DisplayLabel(w, GL, "Preset")
+		w.WriteString(`<select name="Preset"><option value=""></option>`)
+		for i := 0; i < len(user.CampaignPresets); i++ {
+			preset := &user.CampaignPresets[i]
+
+			w.WriteString(`<option value="`)
+			w.WriteHTMLString(preset.Name)
+			w.WriteString(`"`)
+			if r.Form.Get("Preset") == preset.Name {
+				w.WriteString(` selected`)
+			}
+			w.WriteString(`>`)
+			w.WriteHTMLString(preset.Name)
+			w.WriteString(`</option>`)
+		}
+		w.WriteString(`</select><br><br>`)
+	}
+
+	for _, field := range [...]struct {
+		Label string
+		Name  string
+	}{
+		{"Campaign", "UTMCampaign"},
+		{"Source", "UTMSource"},
+		{"Medium", "UTMMedium"},
+		{"Term", "UTMTerm"},
+		{"Content", "UTMContent"},
+	} {
+		DisplayLabel(w, GL, field.Label)
+		DisplayConstraintInput(w, "text", 0, MaxUTMLen, field.Name, r.Form.Get(field.Name), false)
+		w.WriteString(`<br><br>`)
+	}
+
+	if user != nil {
+		DisplayLabel(w, GL, "Save as preset")
+		DisplayConstraintInput(w, "text", 0, MaxUTMLen, "PresetName", r.Form.Get("PresetName"), false)
+		w.WriteString(`<br><br>`)
+	}
+
+	w.WriteString(`</fieldset><br>`)
+}
+
+func DisplayUserCampaignPresets(w *http.Response, user *User) {
+	if len(user.CampaignPresets) == 0 {
+		return
+	}
+
+	w.WriteString(`<h3>`)
+	w.WriteString(Ls(GL, "Campaign presets"))
+	w.WriteString(`</h3>`)
+
+	w.WriteString(`<ul>`)
+	for i := 0; i < len(user.CampaignPresets); i++ {
+		preset := &user.CampaignPresets[i]
+
+		w.WriteString(`<li><form method="POST" action="` + APIPrefix + `/user/preset/delete">`)
+		w.WriteHTMLString(preset.Name)
+		w.WriteString(`: `)
+		w.WriteHTMLString(preset.UTM.Campaign)
+		w.WriteString(` / `)
+		w.WriteHTMLString(preset.UTM.Source)
+		w.WriteString(` / `)
+		w.WriteHTMLString(preset.UTM.Medium)
+		w.WriteString(` `)
+		DisplayHiddenInput(w, "Name", preset.Name)
+		DisplaySubmit(w, GL, "", "Delete")
+		w.WriteString(`</form></li>`)
+	}
+	w.WriteString(`</ul>`)
+}
+
+/* DisplayUserCampaigns displays statistics of user's links grouped by campaign. */
+func DisplayUserCampaigns(w *http.Response, id database.ID) {
+	defer trace.End(trace.Begin(""))
+
+	type campaign struct {
+		Name      string
+		Links     int
+		Redirects int64
+	}
+	var campaigns []campaign
+
+	var url URL
+	for _, path := range GetURLPathsByUserID(id) {
+		if (GetURLByPath(path, &url) != nil) || (url.Campaign == "") {
+			continue
+		}
+
+		i := slices.IndexFunc(campaigns, func(c campaign) bool { return c.Name == url.Campaign })
+		if i == -1 {
+			campaigns = append(campaigns, campaign{Name: url.Campaign})
+			i = len(campaigns) - 1
+		}
+		campaigns[i].Links++
+		campaigns[i].Redirects += url.Redirects
+	}
+	if len(campaigns) == 0 {
+		return
+	}
+	slices.SortFunc(campaigns, func(a, b campaign) int {
+		switch {
+		case a.Name < b.Name:
+			return -1
+		case a.Name > b.Name:
+			return 1
+		}
+		return 0
+	})
+
+	w.WriteString(`<h3>`)
+	w.WriteString(Ls(GL, "Campaigns"))
+	w.WriteString(`</h3>`)
+
+	w.WriteString(`<table><tr><th>`)
+	w.WriteString(Ls(GL, "Campaign"))
+	w.WriteString(`</th><th>`)
+	w.WriteString(Ls(GL, "Links"))
+	w.WriteString(`</th><th>`)
+	w.WriteString(Ls(GL, "Redirects"))
+	w.WriteString(`</th></tr>`)
+	for i := 0; i < len(campaigns); i++ {
+		w.WriteString(`<tr><td>`)
+		w.WriteHTMLString(campaigns[i].Name)
+		w.WriteString(`</td><td>`)
+		w.WriteInt(campaigns[i].Links)
+		w.WriteString(`</td><td>`)
+		w.WriteInt(int(campaigns[i].Redirects))
+		w.WriteString(`</td></tr>`)
+	}
+	w.WriteString(`</table>`)
+}
+
+func UserPresetDeleteHandler(w *http.Response, r *http.Request) error {
+	defer trace.End(trace.Begin(""))
+
+	session, err := GetSessionFromRequest(r)
+	if err != nil {
+		return http.UnauthorizedError
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return http.ClientError(err)
+	}
+	name := r.Form.Get("Name")
+
+	var user User
+	if err := GetUserByID(session.ID, &user); err != nil {
+		return http.ServerError(err)
+	}
+
+	presets := make([]CampaignPreset, 0, len(user.CampaignPresets))
+	for i := 0; i < len(user.CampaignPresets); i++ {
+		if user.CampaignPresets[i].Name != name {
+			presets = append(presets, user.CampaignPresets[i])
+		}
+	}
+	user.CampaignPresets = presets
+
+	if err := SaveUser(&user); err != nil {
+		return http.ServerError(err)
+	}
+
+	w.Redirect("/user/"+strconv.Itoa(int(session.ID)), http.StatusSeeOther)
+	return nil
+}
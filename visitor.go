@@ -0,0 +1,139 @@
+package main
+
+import (
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/anton2920/gofa/net/http"
+	"github.com/anton2920/gofa/trace"
+)
+
+/* Visitor describes client following a link, as much as routing rules care. */
+type Visitor struct {
+	Address   netip.Addr
+	Country   string
+	Languages []string
+	Platform  Platform
+}
+
+const MaxAcceptLanguages = 8
+
+/*
+ * ClientAddress returns address of client sending 'r'. 'X-Forwarded-For' is believed only from TLS terminator and trusted proxies,
+ * and only its last entry, because that is the one proxy has added itself. Anything before it comes from client.
+ */
+func ClientAddress(ctx *http.Context, r *http.Request) string {
+	forwarded := r.Headers.Get("X-Forwarded-For")
+	if (forwarded == "") || (!ProxyTrusted(ctx)) {
+		return ctx.ClientAddress
+	}
+
+	if i := strings.LastIndexByte(forwarded, ','); i >= 0 {
+		forwarded = forwarded[i+1:]
+	}
+	return strings.TrimSpace(forwarded)
+}
+
+/* ProxyTrusted reports whether connection of 'ctx' comes from TLS terminator, trusted Unix domain socket or one of configured trusted proxies. */
+func ProxyTrusted(ctx *http.Context) bool {
+	switch GetConnection(ctx).Listener {
+	case ListenerBackend:
+		return true
+	case ListenerUnix:
+		return GetConfig().TrustUnixSocket
+	}
+
+	peer := ParseClientAddress(ctx.ClientAddress)
+	if !peer.IsValid() {
+		return false
+	}
+	for _, prefix := range GetConfig().TrustedProxies {
+		if prefix.Contains(peer) {
+			return true
+		}
+	}
+	return false
+}
+
+/* ParseClientAddress extracts IP address from 'host:port' or bare address. */
+func ParseClientAddress(addr string) netip.Addr {
+	addr = strings.TrimSpace(addr)
+
+	if ap, err := netip.ParseAddrPort(addr); err == nil {
+		return ap.Addr().Unmap()
+	}
+	if a, err := netip.ParseAddr(addr); err == nil {
+		return a.Unmap()
+	}
+	return netip.Addr{}
+}
+
+/* ParseAcceptLanguage returns lowercase language tags from 'Accept-Language' header, most preferred first. */
+func ParseAcceptLanguage(header string) []string {
+	type language struct {
+		Tag string
+		Q   float64
+	}
+	var languages []language
+
+	for len(header) > 0 {
+		var entry string
+		entry, header, _ = strings.Cut(header, ",")
+
+		tag, params, _ := strings.Cut(entry, ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if (tag == "") || (tag == "*") {
+			continue
+		}
+
+		q := 1.0
+		if params = strings.TrimSpace(params); strings.HasPrefix(params, "q=") {
+			var err error
+			if q, err = strconv.ParseFloat(params[len("q="):], 64); err != nil {
+				continue
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+
+		languages = append(languages, language{tag, q})
+		if len(languages) == MaxAcceptLanguages {
+			break
+		}
+	}
+	slices.SortStableFunc(languages, func(a, b language) int {
+		switch {
+		case a.Q > b.Q:
+			return -1
+		case a.Q < b.Q:
+			return 1
+		}
+		return 0
+	})
+
+	tags := make([]string, len(languages))
+	for i := 0; i < len(languages); i++ {
+		tags[i] = languages[i].Tag
+	}
+	return tags
+}
+
+func NewVisitor(addr string, acceptLanguage string, userAgent string) Visitor {
+	defer trace.End(trace.Begin(""))
+
+	var v Visitor
+
+	v.Address = ParseClientAddress(addr)
+	v.Country = GeoIPCountry(v.Address)
+	v.Languages = ParseAcceptLanguage(acceptLanguage)
+	v.Platform = PlatformFromUserAgent(userAgent)
+
+	return v
+}
+
+func VisitorFromRequest(r *http.Request, addr string) Visitor {
+	return NewVisitor(addr, r.Headers.Get("Accept-Language"), r.Headers.Get("User-Agent"))
+}